@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/alfin-efendy/lua-bundler/internal/bundler"
 	httpserver "github.com/alfin-efendy/lua-bundler/internal/http"
@@ -53,7 +55,7 @@ var rootCmd = &cobra.Command{
 		"  • Bundle local Lua modules with require()",
 		"  • Embed HTTP dependencies from game:HttpGet()",
 		"  • Release mode to remove debug statements",
-		"  • Code obfuscation support (3 levels)",
+		"  • Code obfuscation support (4 levels)",
 		"  • HTTP server to serve bundled output",
 		"  • Beautiful terminal output with colors",
 		"",
@@ -71,12 +73,44 @@ var rootCmd = &cobra.Command{
 		port, _ := cmd.Flags().GetInt("port")
 		noCache, _ := cmd.Flags().GetBool("no-cache")
 		envFile, _ := cmd.Flags().GetString("env-file")
+		envTarget, _ := cmd.Flags().GetString("env-target")
+		envAllow, _ := cmd.Flags().GetStringSlice("env-allow")
+		printEnv, _ := cmd.Flags().GetBool("print-env")
+		resolveOrder, _ := cmd.Flags().GetStringSlice("resolve-order")
+		aliasFlags, _ := cmd.Flags().GetStringArray("alias")
+		searchRoots, _ := cmd.Flags().GetStringSlice("search-root")
+		treeShake, _ := cmd.Flags().GetBool("tree-shake")
+		defineFlags, _ := cmd.Flags().GetStringArray("define")
+		stripCalls, _ := cmd.Flags().GetStringSlice("strip-calls")
+		stripAsserts, _ := cmd.Flags().GetBool("strip-asserts")
+		strictEnv, _ := cmd.Flags().GetBool("strict-env")
+		secretScan, _ := cmd.Flags().GetString("secret-scan")
+		remoteLoaders, _ := cmd.Flags().GetStringSlice("remote-loaders")
+		remotePatterns, _ := cmd.Flags().GetStringArray("remote-pattern")
+		secretVars, _ := cmd.Flags().GetStringSlice("secret-vars")
+		virtualize, _ := cmd.Flags().GetStringSlice("virtualize")
+		manglePrivate, _ := cmd.Flags().GetBool("mangle-private")
+		mangleFields, _ := cmd.Flags().GetStringSlice("mangle-fields")
+		antiTamper, _ := cmd.Flags().GetBool("anti-tamper")
+		watermark, _ := cmd.Flags().GetString("watermark")
+		junk, _ := cmd.Flags().GetFloat64("junk")
 
 		if entryFile == "" {
 			fmt.Println(errorStyle.Render("❌ Entry file is required"))
 			os.Exit(1)
 		}
 
+		aliases, err := parseAliases(aliasFlags)
+		if err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		defines, err := parseDefines(defineFlags)
+		if err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+
 		// Print header
 		fmt.Println(titleStyle.Render(" Lua Script Bundler "))
 		fmt.Println()
@@ -89,12 +123,21 @@ var rootCmd = &cobra.Command{
 			fmt.Printf("  Mode: %s\n", infoStyle.Render("Development"))
 		}
 		if obfuscateLevel > 0 {
-			levelName := []string{"None", "Basic", "Medium", "Heavy"}
-			if obfuscateLevel > 3 {
-				obfuscateLevel = 3
+			levelName := []string{"None", "Basic", "Medium", "Heavy", "Flatten"}
+			if obfuscateLevel > 4 {
+				obfuscateLevel = 4
 			}
 			fmt.Printf("  Obfuscation: %s\n", warningStyle.Render(levelName[obfuscateLevel]))
 		}
+		if treeShake {
+			fmt.Printf("  Tree Shaking: %s\n", infoStyle.Render("Enabled"))
+		}
+		if watermark != "" {
+			fmt.Printf("  Watermark: %s\n", infoStyle.Render(watermark))
+		}
+		if len(defines) > 0 {
+			fmt.Printf("  Defines: %s\n", infoStyle.Render(strings.Join(defineFlags, ", ")))
+		}
 		if verbose {
 			fmt.Printf("  Verbose: %s\n", infoStyle.Render("Enabled"))
 		}
@@ -106,10 +149,10 @@ var rootCmd = &cobra.Command{
 		} else {
 			fmt.Printf("  HTTP Cache: %s\n", infoStyle.Render("Enabled"))
 		}
-		if envFile != "" {
-			fmt.Printf("  Env File: %s\n", infoStyle.Render(envFile))
-		} else {
-			fmt.Printf("  Env File: %s\n", infoStyle.Render(".env (default, if present)"))
+		envLayers := bundler.EnvLayers(bundler.EnvOptions{File: envFile, Target: envTarget})
+		fmt.Printf("  Env Files: %s\n", infoStyle.Render(strings.Join(envLayers, " < ")+" (if present)"))
+		if len(envAllow) > 0 {
+			fmt.Printf("  Env Allowlist: %s\n", infoStyle.Render(strings.Join(envAllow, ", ")))
 		}
 		fmt.Println()
 
@@ -120,18 +163,61 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		b.SetResolveOrder(resolveOrder)
+		b.SetAliases(aliases)
+		b.SetSearchRoots(searchRoots)
+		b.SetTreeShake(treeShake)
+		b.SetStripCalls(stripCalls)
+		b.SetStripAsserts(stripAsserts)
+		b.SetStrictEnv(strictEnv)
+		b.SetSecretVars(secretVars)
+		if err := b.SetRemoteLoaders(remoteLoaders, remotePatterns); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		if err := b.SetSecretScan(secretScan); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		if err := b.SetDefines(defines); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		if err := b.SetVirtualize(virtualize); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		b.SetManglePrivateFields(manglePrivate)
+		if err := b.SetMangleFields(mangleFields); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		b.SetAntiTamper(antiTamper)
+		if err := b.SetWatermark(watermark); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+		if err := b.SetJunkDensity(junk); err != nil {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ %v", err)))
+			os.Exit(1)
+		}
+
 		// Set obfuscation level (will be applied per-module during bundling for local files only)
 		if obfuscateLevel > 0 {
 			b.SetObfuscationLevel(obfuscateLevel)
 		}
 
 		// Load env vars for {{VAR_NAME}} substitution
-		envVars, err := bundler.BuildEnvVars(envFile)
+		envVars, err := bundler.LoadEnv(bundler.EnvOptions{File: envFile, Target: envTarget, Allow: envAllow})
 		if err != nil {
 			fmt.Println(errorStyle.Render(fmt.Sprintf("❌ Failed to load env file: %v", err)))
 			os.Exit(1)
 		}
-		b.SetEnvVars(envVars)
+		if printEnv {
+			printEnvVars(envVars, secretVars)
+			return
+		}
+		b.SetEnvVars(bundler.EnvValues(envVars))
 
 		// Bundle
 		fmt.Println(infoStyle.Render("🔄 Processing dependencies..."))
@@ -164,6 +250,27 @@ func printSuccess(b *bundler.Bundler, outputFile string, obfuscateLevel int) {
 		infoStyle.Render("📦 Modules embedded:"),
 		len(b.GetModules()))
 
+	if findings := b.GetSecretFindings(); len(findings) > 0 {
+		fmt.Println(warningStyle.Render(fmt.Sprintf("🔐 %d possible secret(s) in the bundle:", len(findings))))
+		for _, f := range findings {
+			fmt.Printf("   %s\n", f)
+		}
+	}
+
+	if report := b.GetShakeReport(); len(report) > 0 {
+		removed, saved := 0, 0
+		for _, r := range report {
+			removed += len(r.Removed)
+			saved += r.BytesSaved
+		}
+		fmt.Printf("%s %d unused definitions removed from %d modules (%d bytes saved)\n",
+			infoStyle.Render("🌳 Tree shaking:"),
+			removed, len(report), saved)
+		for _, r := range report {
+			fmt.Printf("   %s: -%d bytes (%s)\n", r.Module, r.BytesSaved, strings.Join(r.Removed, ", "))
+		}
+	}
+
 	if obfuscateLevel > 0 {
 		fmt.Printf("%s Level %d applied\n",
 			infoStyle.Render("🔒 Obfuscation:"),
@@ -175,6 +282,52 @@ func printSuccess(b *bundler.Bundler, outputFile string, obfuscateLevel int) {
 		outputFile)
 }
 
+// printEnvVars lists every env var available for {{VAR_NAME}} substitution
+// with the layer it came from, masking the values of secretVars.
+func printEnvVars(vars map[string]bundler.EnvVar, secretVars []string) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println(infoStyle.Render("Environment:"))
+	for _, name := range names {
+		value := vars[name].Value
+		if bundler.IsSecretVar(name, secretVars) {
+			value = bundler.MaskSecret(value)
+		}
+		fmt.Printf("  %s=%s %s\n", name, value, warningStyle.Render("("+vars[name].Source+")"))
+	}
+}
+
+// parseAliases turns repeated --alias NAME=PATH flags into an alias map.
+func parseAliases(flags []string) (map[string]string, error) {
+	aliases := make(map[string]string, len(flags))
+	for _, f := range flags {
+		name, target, ok := strings.Cut(f, "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("invalid --alias %q: expected NAME=PATH", f)
+		}
+		aliases[name] = strings.TrimSpace(target)
+	}
+	return aliases, nil
+}
+
+// parseDefines turns repeated --define NAME=VALUE flags into a define map.
+func parseDefines(flags []string) (map[string]string, error) {
+	defines := make(map[string]string, len(flags))
+	for _, f := range flags {
+		name, value, ok := strings.Cut(f, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --define %q: expected NAME=VALUE", f)
+		}
+		defines[name] = value
+	}
+	return defines, nil
+}
+
 // SetVersionInfo sets the version information from build-time variables
 func SetVersionInfo(v, date, commit string) {
 	version = v
@@ -201,10 +354,31 @@ func init() {
 	rootCmd.Flags().StringP("entry", "e", "main.lua", "Entry point Lua file")
 	rootCmd.Flags().StringP("output", "o", "bundle.lua", "Output bundled file")
 	rootCmd.Flags().BoolP("release", "r", false, "Release mode: remove print and warn statements")
-	rootCmd.Flags().IntP("obfuscate", "O", 0, "Obfuscation level (0=none, 1=basic, 2=medium, 3=heavy)")
+	rootCmd.Flags().IntP("obfuscate", "O", 0, "Obfuscation level (0=none, 1=basic, 2=medium, 3=heavy, 4=flatten)")
 	rootCmd.Flags().BoolP("verbose", "v", false, "Enable verbose output")
 	rootCmd.Flags().BoolP("serve", "s", false, "Start HTTP server to serve the output file")
 	rootCmd.Flags().IntP("port", "p", 8080, "Port for HTTP server (used with --serve)")
 	rootCmd.Flags().BoolP("no-cache", "n", false, "Disable HTTP cache for remote scripts")
 	rootCmd.Flags().String("env-file", "", "Path to .env file for {{VAR_NAME}} substitution (default: .env in working dir)")
+	rootCmd.Flags().String("env-target", "", "Env profile: also load <env-file>.<target> (before <env-file>.local)")
+	rootCmd.Flags().StringSlice("env-allow", nil, "Only take OS env vars matching these patterns, e.g. LB_* (repeatable)")
+	rootCmd.Flags().Bool("print-env", false, "Print resolved env vars and where each came from, then exit")
+	rootCmd.Flags().StringSlice("remote-loaders", bundler.DefaultRemoteLoaders, "Remote-load idioms to embed (HttpGet, HttpGetAsync, request, GetAsync)")
+	rootCmd.Flags().StringArray("remote-pattern", nil, "Custom remote-load regex; its one capture group is the URL (repeatable)")
+	rootCmd.Flags().String("secret-scan", bundler.SecretScanWarn, "Action on secrets found in the bundle: off, warn, fail or redact")
+	rootCmd.Flags().StringSlice("secret-vars", bundler.DefaultSecretVars, "Env var name patterns whose values are secret and must not be bundled")
+	rootCmd.Flags().Bool("strict-env", false, "Fail the build on any unresolved {{VAR_NAME}} placeholder")
+	rootCmd.Flags().StringSlice("resolve-order", bundler.DefaultResolveOrder, "Suffixes tried in order for extensionless require paths")
+	rootCmd.Flags().StringArray("alias", nil, "Require-path alias NAME=PATH, e.g. @shared=src/shared (repeatable)")
+	rootCmd.Flags().StringSlice("search-root", nil, "Extra directories searched for bare require paths (repeatable)")
+	rootCmd.Flags().Bool("tree-shake", false, "Remove module exports and local functions no consumer uses")
+	rootCmd.Flags().StringSlice("strip-calls", bundler.DefaultStripCalls, "Callees whose call statements release mode removes, e.g. print,warn,debug.*,Logger:debug")
+	rootCmd.Flags().Bool("strip-asserts", false, "Release mode: also remove assert(...) statements")
+	rootCmd.Flags().StringArray("define", nil, "Compile-time define NAME=VALUE, e.g. DEBUG=false (repeatable)")
+	rootCmd.Flags().StringSlice("virtualize", nil, "Module globs compiled to bytecode for an embedded VM when obfuscating, e.g. src/secret/* (repeatable)")
+	rootCmd.Flags().Bool("mangle-private", false, "Rename _private table fields consistently across the entry and local modules")
+	rootCmd.Flags().StringSlice("mangle-fields", nil, "Further table field names renamed like private ones (repeatable)")
+	rootCmd.Flags().Bool("anti-tamper", false, "Embed integrity checks so an obfuscated bundle refuses to run once modified")
+	rootCmd.Flags().Float64("junk", 0, "Density (0-1) of dead decoy code inserted when obfuscating at level 2 or higher")
+	rootCmd.Flags().String("watermark", "", "Hide an ID (e.g. the user's) in the obfuscated bundle; recover it with 'lua-bundler watermark identify'")
 }
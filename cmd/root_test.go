@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -175,3 +176,44 @@ func TestPrintSuccess(t *testing.T) {
 		// The function exists and is used in the root command, that's sufficient
 	}, "printSuccess() should not panic")
 }
+
+func TestPrintEnvVars_MasksSecrets(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	printEnvVars(map[string]bundler.EnvVar{
+		"API_URL":   {Value: "https://api.example.com", Source: ".env"},
+		"API_TOKEN": {Value: "tok-1234567890", Source: "env"},
+	}, nil)
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(out), "API_URL=https://api.example.com")
+	assert.Contains(t, string(out), "API_TOKEN=tok-********")
+	assert.NotContains(t, string(out), "tok-1234567890")
+}
+
+func TestParseAliases(t *testing.T) {
+	got, err := parseAliases([]string{"@shared=src/shared", "vendor = vendor/"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"shared": "src/shared", "vendor": "vendor/"}, got)
+
+	for _, bad := range []string{"shared", "=src", "@x="} {
+		_, err := parseAliases([]string{bad})
+		assert.Error(t, err, "parseAliases(%q) should fail", bad)
+	}
+}
+
+func TestParseDefines(t *testing.T) {
+	got, err := parseDefines([]string{"DEBUG=false", `VERSION="1.2"`, "MODE=a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"DEBUG": "false", "VERSION": `"1.2"`, "MODE": "a=b"}, got)
+
+	for _, bad := range []string{"DEBUG", "=false"} {
+		_, err := parseDefines([]string{bad})
+		assert.Error(t, err, "parseDefines(%q) should fail", bad)
+	}
+}
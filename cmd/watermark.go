@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alfin-efendy/lua-bundler/internal/bundler"
+	"github.com/spf13/cobra"
+)
+
+var watermarkCmd = &cobra.Command{
+	Use:   "watermark",
+	Short: "Work with bundle watermarks",
+}
+
+var watermarkIdentifyCmd = &cobra.Command{
+	Use:   "identify <bundle>",
+	Short: "Recover the watermark ID from a bundle built with --watermark",
+	Args:  cobra.ExactArgs(1),
+	// Execute prints the error; a failed lookup is not a usage mistake.
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		content, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		id, err := bundler.IdentifyWatermark(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", successStyle.Render("🔖 Watermark:"), id)
+		return nil
+	},
+}
+
+func init() {
+	watermarkCmd.AddCommand(watermarkIdentifyCmd)
+	rootCmd.AddCommand(watermarkCmd)
+}
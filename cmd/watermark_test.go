@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfin-efendy/lua-bundler/internal/bundler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatermarkIdentifyCmd(t *testing.T) {
+	dir := t.TempDir()
+	var src strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&src, "local function f%d(a, b) local s = \"n%d\" return s .. (a + b) end\n", i, i)
+	}
+	src.WriteString("print(f1(1, 2))")
+	entry := filepath.Join(dir, "main.lua")
+	require.NoError(t, os.WriteFile(entry, []byte(src.String()), 0644))
+
+	b, err := bundler.NewBundler(entry, false, false)
+	require.NoError(t, err)
+	b.SetObfuscationLevel(3)
+	require.NoError(t, b.SetWatermark("customer-42"))
+	out, err := b.Bundle(true)
+	require.NoError(t, err)
+	bundle := filepath.Join(dir, "bundle.lua")
+	require.NoError(t, os.WriteFile(bundle, []byte(out), 0644))
+
+	var buf bytes.Buffer
+	watermarkIdentifyCmd.SetOut(&buf)
+	defer watermarkIdentifyCmd.SetOut(nil)
+	require.NoError(t, watermarkIdentifyCmd.RunE(watermarkIdentifyCmd, []string{bundle}))
+	assert.Contains(t, buf.String(), "customer-42")
+
+	assert.Error(t, watermarkIdentifyCmd.RunE(watermarkIdentifyCmd, []string{entry}), "the unobfuscated entry has no watermark")
+	assert.Error(t, watermarkIdentifyCmd.RunE(watermarkIdentifyCmd, []string{filepath.Join(dir, "missing.lua")}))
+}
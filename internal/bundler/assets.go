@@ -0,0 +1,113 @@
+package bundler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// assetExts are the file extensions a require path may name to embed a data
+// file instead of a Lua module. JSON becomes a table; anything else becomes
+// a string (a long string for text, base64-decoded at load time for binary).
+var assetExts = map[string]bool{
+	".json": true,
+	".txt":  true, ".md": true, ".csv": true, ".xml": true, ".html": true,
+	".css": true, ".svg": true, ".yaml": true, ".yml": true, ".toml": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".bmp": true, ".ico": true, ".wav": true, ".mp3": true, ".ogg": true,
+	".ttf": true, ".otf": true, ".bin": true, ".dat": true,
+}
+
+// base64Decoder decodes the standard base64 alphabet in plain Lua 5.1/Luau
+// (no bit library): each character becomes 6 bits, every 8 bits one byte.
+const base64Decoder = `local function _b64(s)
+	local a = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	return (s:gsub("[^%w%+/]", ""):gsub(".", function(c)
+		local n, bits = a:find(c, 1, true) - 1, ""
+		for i = 6, 1, -1 do
+			bits = bits .. (n % 2 ^ i - n % 2 ^ (i - 1) > 0 and "1" or "0")
+		end
+		return bits
+	end):gsub("%d%d%d?%d?%d?%d?%d?%d?", function(bits)
+		if #bits ~= 8 then return "" end
+		local c = 0
+		for i = 1, 8 do
+			c = c + (bits:sub(i, i) == "1" and 2 ^ (8 - i) or 0)
+		end
+		return string.char(c)
+	end))
+end
+`
+
+// isAssetPath reports whether path names an embeddable data file.
+func isAssetPath(path string) bool {
+	return assetExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// embedAsset stores the data file at resolvedPath as a module returning its
+// contents as a Lua value. Assets are not env-substituted or obfuscated, but
+// are scanned for secrets like any module.
+func (b *Bundler) embedAsset(resolvedPath, key string) error {
+	data, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", resolvedPath, err)
+	}
+	source, kind, err := assetSource(data, filepath.Ext(resolvedPath))
+	if err != nil {
+		return fmt.Errorf("failed to embed %s: %w", resolvedPath, err)
+	}
+
+	if b.assetModules == nil {
+		b.assetModules = make(map[string]bool)
+	}
+	b.assetModules[key] = true
+	b.modules[key] = source
+
+	if b.verbose {
+		fmt.Printf("🗂️  Embedded %s asset: %s (%d bytes)\n", kind, key, len(data))
+	}
+	return nil
+}
+
+// assetSource returns the Lua module source for an asset and its kind
+// ("json", "text" or "binary").
+func assetSource(data []byte, ext string) (string, string, error) {
+	if strings.EqualFold(ext, ".json") {
+		value, err := lua.FromJSON(data)
+		if err != nil {
+			return "", "", err
+		}
+		chunk := &lua.Chunk{Body: []lua.Stat{&lua.ReturnStat{Values: []lua.Expr{value}}}}
+		return chunk.Print(), "json", nil
+	}
+	if isText(data) {
+		return "return " + longString(string(data)), "text", nil
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return base64Decoder + "return _b64(\"" + encoded + "\")", "binary", nil
+}
+
+// isText reports whether data can be embedded verbatim in a long string:
+// valid UTF-8 without NUL bytes or carriage returns (Lua normalizes line
+// endings inside long strings).
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !strings.ContainsAny(string(data), "\x00\r")
+}
+
+// longString wraps s in a long bracket whose level does not occur in s. A
+// leading newline is doubled because Lua drops the first one.
+func longString(s string) string {
+	eq := ""
+	for strings.Contains(s+"]", "]"+eq+"]") {
+		eq += "="
+	}
+	if strings.HasPrefix(s, "\n") {
+		s = "\n" + s
+	}
+	return "[" + eq + "[" + s + "]" + eq + "]"
+}
@@ -0,0 +1,64 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongString(t *testing.T) {
+	assert.Equal(t, "[[abc]]", longString("abc"))
+	assert.Equal(t, "[=[a]]b]=]", longString("a]]b"))
+	assert.Equal(t, "[=[x]]=]", longString("x]"))
+	assert.Equal(t, "[[\n\nfirst]]", longString("\nfirst"))
+}
+
+func TestAssetSource(t *testing.T) {
+	src, kind, err := assetSource([]byte(`{"speed": 16, "tags": ["a"]}`), ".json")
+	require.NoError(t, err)
+	assert.Equal(t, "json", kind)
+	assert.Equal(t, `return{speed=16,tags={"a"}}`, src)
+
+	src, kind, err = assetSource([]byte("hello\nworld"), ".txt")
+	require.NoError(t, err)
+	assert.Equal(t, "text", kind)
+	assert.Equal(t, "return [[hello\nworld]]", src)
+
+	src, kind, err = assetSource([]byte{0x89, 'P', 'N', 'G', 0}, ".png")
+	require.NoError(t, err)
+	assert.Equal(t, "binary", kind)
+	assert.True(t, strings.HasSuffix(src, `return _b64("iVBORwA=")`), src)
+
+	_, _, err = assetSource([]byte(`{bad`), ".json")
+	assert.Error(t, err)
+}
+
+func TestBundle_EmbedsAssets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("data/config.json", `{"title": "Demo", "max": 3}`)
+	write("data/banner.txt", "  ==\n  hi\n  ==")
+	write("ui.lua", `local cfg = require("./data/config.json")
+return cfg.title`)
+	write("main.lua", `local banner = require("./data/banner.txt")
+local title = require("./ui")
+return banner, title`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.Equal(t, `return{title="Demo",max=3}`, mods["data/config.json"])
+	assert.Contains(t, out, `loadModule("data/config.json")`)
+	assert.Contains(t, out, `loadModule("data/banner.txt")`)
+	assert.Contains(t, out, "return [[  ==\n  hi\n  ==]]", "text assets are written without extra indentation")
+}
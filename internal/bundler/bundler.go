@@ -4,16 +4,23 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alfin-efendy/lua-bundler/internal/cache"
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
 	"github.com/alfin-efendy/lua-bundler/internal/obfuscator"
 )
 
 type Bundler struct {
 	modules        map[string]string // path -> content
+	plainModules   map[string]string // path -> content before obfuscation
 	httpModules    map[string]bool   // track which modules are from HTTP
+	assetModules   map[string]bool   // modules generated from JSON/text/binary files
 	baseDir        string
 	entryFile      string
 	httpClient     *http.Client
@@ -21,9 +28,35 @@ type Bundler struct {
 	verbose        bool
 	obfuscator     *obfuscator.Obfuscator
 	obfuscateLevel int
-	envVars        map[string]string // env var substitutions for {{VAR_NAME}}
+	envVars        map[string]string            // env var substitutions for {{VAR_NAME}}
+	resolveOrder   []string                     // suffixes tried for an extensionless require path
+	aliases        map[string]string            // "@name" require prefix (without "@") -> directory
+	searchRoots    []string                     // extra directories tried for bare require paths
+	luaurcs        map[string]map[string]string // dir -> parsed .luaurc aliases (nil if none)
+	treeShake      bool                         // drop module exports no consumer reads
+	shakeReport    []ShakeResult
+	skipped        map[string]string // module key -> file that required it only from dead code
+	remoteLoaders  []remoteLoader    // remote-load idioms embedded (nil: all built-ins)
+	secretScan     string            // SecretScanOff/Warn/Fail/Redact
+	secretVars     []string          // env var name patterns whose values are secret
+	secretFindings []SecretFinding
+	strictEnv      bool                // fail on any unresolved {{VAR}} placeholder
+	stripCalls     []string            // callees removed in release mode (nil: DefaultStripCalls)
+	stripAsserts   bool                // also remove assert(...) statements in release mode
+	defines        map[string]lua.Expr // compile-time define name -> literal value
+	virtualize     []string            // module key globs compiled to VM bytecode when obfuscating
+	manglePrivate  bool                // rename _private table fields across units
+	mangleNames    map[string]bool     // further field names renamed like private ones
+	antiTamper     bool                // embed units as hash-checked strings when obfuscating
+	watermark      *lua.Watermark      // identifier hidden in obfuscated names and nonces
+	junk           float64             // density of decoy code inserted when obfuscating
+	release        bool                // the current Bundle call is in release mode
 }
 
+// DefaultResolveOrder is the candidate order tried when a require path has no
+// .lua/.luau extension: a plain file first, then a directory's init module.
+var DefaultResolveOrder = []string{".lua", ".luau", "/init.lua", "/init.luau"}
+
 func NewBundler(entryFile string, verbose bool, useCache bool) (*Bundler, error) {
 	baseDir := filepath.Dir(entryFile)
 	if baseDir == "." {
@@ -41,10 +74,11 @@ func NewBundler(entryFile string, verbose bool, useCache bool) (*Bundler, error)
 	}
 
 	return &Bundler{
-		modules:     make(map[string]string),
-		httpModules: make(map[string]bool),
-		baseDir:     baseDir,
-		entryFile:   entryFile,
+		modules:      make(map[string]string),
+		plainModules: make(map[string]string),
+		httpModules:  make(map[string]bool),
+		baseDir:      baseDir,
+		entryFile:    entryFile,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -52,6 +86,10 @@ func NewBundler(entryFile string, verbose bool, useCache bool) (*Bundler, error)
 		verbose:        verbose,
 		obfuscateLevel: 0,
 		envVars:        make(map[string]string),
+		resolveOrder:   DefaultResolveOrder,
+		aliases:        make(map[string]string),
+		luaurcs:        make(map[string]map[string]string),
+		secretScan:     SecretScanWarn,
 	}, nil
 }
 
@@ -60,6 +98,57 @@ func (b *Bundler) SetEnvVars(vars map[string]string) {
 	b.envVars = vars
 }
 
+// SetStrictEnv makes any {{VAR}} placeholder without a value (and without a
+// :-default) fail the build instead of being left in place.
+func (b *Bundler) SetStrictEnv(strict bool) {
+	b.strictEnv = strict
+}
+
+// SetResolveOrder sets the suffixes tried, in order, when resolving an
+// extensionless require path (e.g. ".luau", "/init.lua"). An empty order
+// restores DefaultResolveOrder.
+func (b *Bundler) SetResolveOrder(order []string) {
+	if len(order) == 0 {
+		order = DefaultResolveOrder
+	}
+	b.resolveOrder = order
+}
+
+// SetAliases sets the require-path aliases: require("@shared/net") resolves
+// to net under aliases["shared"]. Keys may be written with or without the
+// leading "@"; relative targets are anchored at the entry file's directory.
+func (b *Bundler) SetAliases(aliases map[string]string) {
+	b.aliases = make(map[string]string, len(aliases))
+	for name, target := range aliases {
+		b.aliases[strings.TrimPrefix(name, "@")] = target
+	}
+}
+
+// SetSearchRoots sets the directories tried, in order, for a bare require
+// path (one not starting with ".", "/" or "@") that is not found next to
+// the requiring file. Relative roots are anchored at the entry file's directory.
+func (b *Bundler) SetSearchRoots(roots []string) {
+	b.searchRoots = roots
+}
+
+// SetTreeShake enables removal of unused exports from local modules that
+// return a table (see shakeModules).
+func (b *Bundler) SetTreeShake(enabled bool) {
+	b.treeShake = enabled
+}
+
+// SetStripCalls sets the callees whose call statements release mode removes:
+// global names ("print"), field or method paths ("Logger:debug") and prefix
+// patterns ("debug.*"). An empty list restores DefaultStripCalls.
+func (b *Bundler) SetStripCalls(callees []string) {
+	b.stripCalls = callees
+}
+
+// SetStripAsserts makes release mode remove assert(...) statements as well.
+func (b *Bundler) SetStripAsserts(enabled bool) {
+	b.stripAsserts = enabled
+}
+
 // SetObfuscationLevel sets the obfuscation level for local modules
 func (b *Bundler) SetObfuscationLevel(level int) {
 	b.obfuscateLevel = level
@@ -68,17 +157,149 @@ func (b *Bundler) SetObfuscationLevel(level int) {
 	}
 }
 
+// SetWatermark hides id, such as the ID of the user a build is for, in the
+// bundle so a leaked copy can be traced (see lua.Watermark and
+// IdentifyWatermark). It needs obfuscation level 2 or higher; an empty id
+// turns watermarking off.
+func (b *Bundler) SetWatermark(id string) error {
+	if id == "" {
+		b.watermark = nil
+		return nil
+	}
+	w, err := lua.NewWatermark(id)
+	if err != nil {
+		return err
+	}
+	b.watermark = w
+	return nil
+}
+
+// SetJunkDensity sets how much dead but plausible code obfuscation at level 2
+// and above inserts, from 0 (none) to 1 (before most statements); see
+// lua.Junk. Bundle fails if the density is set below level 2.
+func (b *Bundler) SetJunkDensity(density float64) error {
+	if density < 0 || density > 1 {
+		return fmt.Errorf("junk density must be between 0 and 1, got %g", density)
+	}
+	b.junk = density
+	return nil
+}
+
+// IdentifyWatermark returns the identifier SetWatermark hid in bundle.
+func IdentifyWatermark(bundle string) (string, error) {
+	return lua.IdentifyWatermark(bundle)
+}
+
+// SetVirtualize sets the module key globs (path.Match syntax, e.g.
+// "src/secret/*") whose modules are compiled to bytecode for an embedded
+// virtual machine instead of being obfuscated normally; "main" is the entry.
+// Files with a `--!virtualize` line are virtualized as well. It only applies
+// when obfuscation is enabled.
+func (b *Bundler) SetVirtualize(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid virtualize pattern %q: %w", pattern, err)
+		}
+	}
+	b.virtualize = patterns
+	return nil
+}
+
+// virtualizeDirectiveRegex matches a `--!virtualize` comment line, written
+// like Luau's --!strict.
+var virtualizeDirectiveRegex = regexp.MustCompile(`(?m)^[ \t]*--!virtualize[ \t]*\r?$`)
+
+// shouldVirtualize reports whether the unit with this key is selected for
+// virtualization by a glob or by its own directive.
+func (b *Bundler) shouldVirtualize(key, content string) bool {
+	for _, pattern := range b.virtualize {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return virtualizeDirectiveRegex.MatchString(content)
+}
+
+// obfuscateModules obfuscates every local module, keeping its source in
+// plainModules for checkPreludeNames. Remote modules and assets are embedded
+// as is.
+func (b *Bundler) obfuscateModules() {
+	keys := make([]string, 0, len(b.modules))
+	for key := range b.modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if b.httpModules[key] || b.assetModules[key] {
+			continue
+		}
+		b.plainModules[key] = b.modules[key]
+		b.modules[key] = b.obfuscate(key, b.modules[key])
+	}
+}
+
+// obfuscate obfuscates one unit, virtualizing it if selected. A unit the VM
+// compiler rejects is obfuscated normally with a warning. In release mode the
+// unit's stripped calls are removed first: once field and method names are
+// encrypted or compiled to bytecode, the pass over the final bundle can no
+// longer recognize calls such as Logger:debug(...).
+func (b *Bundler) obfuscate(key, content string) string {
+	virtualize := b.shouldVirtualize(key, content)
+	if b.release {
+		content = b.stripUnitCalls(content)
+	}
+	if virtualize {
+		out, err := b.obfuscator.Virtualize(content)
+		if err == nil {
+			if b.verbose {
+				fmt.Printf("🧬 Virtualized: %s\n", key)
+			}
+			return out
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  virtualize %s: %v; obfuscating normally\n", key, err)
+	}
+	return b.obfuscator.Obfuscate(content)
+}
+
 func (b *Bundler) Bundle(releaseMode bool) (string, error) {
+	b.release = releaseMode
+	if b.watermark != nil {
+		// Level 1 only minifies: it has no generated names to carry the mark.
+		if b.obfuscateLevel < 2 || b.obfuscator == nil {
+			return "", fmt.Errorf("watermarking needs obfuscation level 2 or higher")
+		}
+		b.obfuscator.SetWatermark(b.watermark)
+	}
+	if b.junk > 0 {
+		// Junk is inserted in parsed, renamed units; level 1 only minifies.
+		if b.obfuscateLevel < 2 || b.obfuscator == nil {
+			return "", fmt.Errorf("junk code needs obfuscation level 2 or higher")
+		}
+	}
+	if b.antiTamper && b.obfuscator == nil {
+		return "", fmt.Errorf("anti-tamper needs obfuscation level 1 or higher")
+	}
+	if b.obfuscator != nil {
+		b.obfuscator.SetJunk(b.junk)
+	}
+
 	// Read entry file
 	content, err := os.ReadFile(b.entryFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read entry file: %w", err)
 	}
 
-	mainContent := string(content)
+	// Evaluate --#if/--#include directives before any require is discovered
+	mainContent, err := b.preprocess(b.entryFile, string(content))
+	if err != nil {
+		return "", err
+	}
 
 	// Apply env var substitution to entry file
-	mainContent = substituteEnvVars(mainContent, b.envVars, b.verbose)
+	mainContent, err = substituteEnvVars(mainContent, b.envVars, b.verbose, b.strictEnv)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", b.entryFile, err)
+	}
 
 	// Process all dependencies
 	if b.verbose {
@@ -88,24 +309,60 @@ func (b *Bundler) Bundle(releaseMode bool) (string, error) {
 		return "", err
 	}
 
+	if skipped := b.GetSkippedModules(); len(skipped) > 0 && b.verbose {
+		fmt.Println("✂️  Skipped unreachable modules:")
+		for _, key := range skipped {
+			fmt.Printf("  - %s (required from %s)\n", key, b.skipped[key])
+		}
+	}
+
 	// Rewrite module calls on the entry (raw source) before obfuscation.
 	mainContent = b.rewriteModuleCalls(mainContent, b.entryFile)
+	mainContent = b.applyDefines(mainContent, "main")
+
+	// Drop exports nobody reads, now that every consumer has been rewritten.
+	if b.treeShake {
+		if b.verbose {
+			fmt.Println("🌳 Tree shaking unused exports...")
+		}
+		b.shakeModules(mainContent)
+	}
+
+	// Rename private table fields the same way in every unit.
+	mainContent = b.mangleFields(mainContent)
 
-	// Obfuscate main content (entry file) if obfuscation is enabled
+	// Scan what will be embedded, before obfuscation hides string values.
+	mainContent = b.scanBundle(mainContent)
+	if err := b.secretScanError(); err != nil {
+		return "", err
+	}
+
+	// Obfuscate local modules and the entry if obfuscation is enabled. This
+	// runs once every unit is final, after the passes above read their
+	// field names.
+	plainMain := mainContent
 	if b.obfuscateLevel > 0 && b.obfuscator != nil {
-		mainContent = b.obfuscator.Obfuscate(mainContent)
+		b.obfuscateModules()
+		mainContent = b.obfuscate("main", mainContent)
+	}
+
+	if err := b.checkPreludeNames(plainMain); err != nil {
+		return "", err
 	}
 
 	// Generate bundle
+	if b.antiTamper && b.obfuscator != nil && b.verbose {
+		fmt.Println("🔒 Embedding integrity checks...")
+	}
 	bundleOutput := b.generateBundle(mainContent)
 
 	// Apply release mode if enabled
 	if releaseMode {
 		if b.verbose {
 			fmt.Println("🚀 Applying release mode...")
-			fmt.Println("  - Removing print/warn statements...")
+			fmt.Println("  - Removing debug calls...")
 		}
-		bundleOutput = removeDebugStatements(bundleOutput)
+		bundleOutput = b.stripReleaseCalls(bundleOutput)
 
 		if b.verbose {
 			fmt.Println("  - Minifying to single line...")
@@ -119,3 +376,17 @@ func (b *Bundler) Bundle(releaseMode bool) (string, error) {
 func (b *Bundler) GetModules() map[string]string {
 	return b.modules
 }
+
+// GetSkippedModules returns, sorted, the modules that were required only from
+// statically dead branches and therefore not embedded. A module that is also
+// required from live code elsewhere is embedded and not listed.
+func (b *Bundler) GetSkippedModules() []string {
+	var keys []string
+	for key := range b.skipped {
+		if _, embedded := b.modules[key]; !embedded {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -193,3 +193,182 @@ return ok
 	assert.NotContains(t, out, `"function "`, "minifier must not inject a space inside the string literal")
 	assert.NotContains(t, out, "-- keep the type guard", "release mode should strip comments")
 }
+
+func TestBundle_ReleaseStripsConfiguredCalls(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	mainContent := `local log = print
+print("a)") keep()
+log("aliased")
+debug.profilebegin("x")
+Logger:debug("noisy")
+Logger:info("kept")
+assert(ready, "not ready")
+return warn
+`
+	require.NoError(t, os.WriteFile(mainFile, []byte(mainContent), 0644), "write main file")
+
+	b, err := NewBundler(mainFile, false, false)
+	require.NoError(t, err, "NewBundler() should not fail")
+	b.SetStripCalls([]string{"print", "warn", "debug.*", "Logger:debug"})
+
+	out, err := b.Bundle(true)
+	require.NoError(t, err, "Bundle() should not return error")
+
+	assert.Contains(t, out, "keep()", "code after a print on the same line must survive")
+	assert.NotContains(t, out, "aliased")
+	assert.NotContains(t, out, "profilebegin")
+	assert.NotContains(t, out, "noisy")
+	assert.Contains(t, out, "Logger:info")
+	assert.Contains(t, out, "assert(", "assert stripping is opt-in")
+	assert.Contains(t, out, "return warn", "only call statements are removed")
+
+	b, err = NewBundler(mainFile, false, false)
+	require.NoError(t, err, "NewBundler() should not fail")
+	b.SetStripAsserts(true)
+	out, err = b.Bundle(true)
+	require.NoError(t, err, "Bundle() should not return error")
+	assert.NotContains(t, out, "assert(")
+}
+
+func TestBundle_ReleaseStripsCallsBeforeObfuscation(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`Logger:debug("noisy")
+Logger:info("kept")
+`), 0644), "write main file")
+
+	// Level 3 encrypts method names, so the calls must go before that: the
+	// strings left are the "info" key and "kept".
+	for release, want := range map[bool]int{false: 4, true: 2} {
+		b, err := NewBundler(mainFile, false, false)
+		require.NoError(t, err, "NewBundler() should not fail")
+		b.SetObfuscationLevel(3)
+		b.SetStripCalls([]string{"Logger:debug"})
+		out, err := b.Bundle(release)
+		require.NoError(t, err, "Bundle() should not return error")
+		assert.Equal(t, want, strings.Count(out, b.obfuscator.DecoderName()+"("), "release=%v:\n%s", release, out)
+	}
+}
+
+func TestBundle_ReleaseStripsCallsOnRequiredLogger(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`local Logger = require("./logger")
+Logger:debug("noisy")
+Logger:info("kept")
+`), 0644), "write main file")
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "logger.lua"), []byte(`local Logger = {}
+function Logger:debug(msg) print(msg) end
+function Logger:info(msg) print(msg) end
+return Logger
+`), 0644), "write logger module")
+
+	for _, level := range []int{0, 2} {
+		b, err := NewBundler(mainFile, false, false)
+		require.NoError(t, err, "NewBundler() should not fail")
+		b.SetObfuscationLevel(level)
+		b.SetStripCalls([]string{"Logger:debug"})
+		out, err := b.Bundle(true)
+		require.NoError(t, err, "Bundle() should not return error")
+		assert.NotContains(t, out, "noisy", "level %d", level)
+		assert.Contains(t, out, "kept", "level %d", level)
+	}
+}
+
+func TestBundle_Level3EncryptsStrings(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`local m = require("./mod")
+print("hello from main", m.name)
+`), 0644), "write main file")
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "mod.lua"), []byte(`return { name = "hello from mod" }
+`), 0644), "write module file")
+
+	b, err := NewBundler(mainFile, false, false)
+	require.NoError(t, err, "NewBundler() should not fail")
+	b.SetObfuscationLevel(3)
+
+	out, err := b.Bundle(false)
+	require.NoError(t, err, "decoder calls added by obfuscation are not name collisions")
+	assert.NotContains(t, out, "hello from")
+	assert.Contains(t, out, b.obfuscator.DecoderName()+"(")
+}
+
+func TestBundle_VirtualizesSelectedModules(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	files := map[string]string{
+		"main.lua":       "local a = require(\"./marked\")\nlocal b = require(\"./secret/key\")\nlocal c = require(\"./plain\")\nprint(a, b, c)\n",
+		"marked.lua":     "--!virtualize\nreturn \"marked value\"\n",
+		"secret/key.lua": "return \"secret value\"\n",
+		"plain.lua":      "local plainLocal = \"plain value\"\nreturn plainLocal\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644), "write %s", name)
+	}
+
+	b, err := NewBundler(mainFile, false, false)
+	require.NoError(t, err, "NewBundler() should not fail")
+	b.SetObfuscationLevel(2)
+	require.NoError(t, b.SetVirtualize([]string{"secret/*"}))
+
+	out, err := b.Bundle(false)
+	require.NoError(t, err, "Bundle() should not return error")
+	vm := b.obfuscator.VMName()
+	require.NotEmpty(t, vm, "selected modules must be virtualized")
+	assert.Equal(t, 1, strings.Count(out, "local "+vm+"="), "one interpreter for the bundle")
+	assert.NotContains(t, out, "marked value")
+	assert.NotContains(t, out, "secret value")
+	assert.Contains(t, out, "plain value", "unselected modules are obfuscated normally")
+	assert.NotContains(t, out, "plainLocal")
+	assert.Less(t, strings.Index(out, "local "+vm+"="), strings.Index(out, "local EmbeddedModules"))
+
+	assert.Error(t, b.SetVirtualize([]string{"["}), "invalid globs are rejected")
+}
+
+func TestBundle_InsertsJunk(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	require.NoError(t, os.WriteFile(mainFile, []byte("local a = 1\nlocal b = a + 2\nprint(a, b)\nreturn b\n"), 0644))
+
+	b, err := NewBundler(mainFile, false, false)
+	require.NoError(t, err)
+	b.SetObfuscationLevel(2)
+	plain, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	require.NoError(t, b.SetJunkDensity(1))
+	junk, err := b.Bundle(false)
+	require.NoError(t, err)
+	assert.Greater(t, len(junk), len(plain))
+
+	assert.Error(t, b.SetJunkDensity(1.5), "density is a fraction")
+	assert.Error(t, b.SetJunkDensity(-0.1))
+
+	for _, level := range []int{0, 1} {
+		b, err := NewBundler(mainFile, false, false)
+		require.NoError(t, err)
+		b.SetObfuscationLevel(level)
+		require.NoError(t, b.SetJunkDensity(0.5))
+		_, err = b.Bundle(false)
+		assert.Error(t, err, "level %d cannot take junk code", level)
+	}
+}
+
+func TestBundle_VirtualizeFallsBackToObfuscation(t *testing.T) {
+	tempDir := t.TempDir()
+	mainFile := filepath.Join(tempDir, "main.lua")
+	require.NoError(t, os.WriteFile(mainFile, []byte("--!virtualize\nlocal who = \"world\"\nprint(\"hello \\z \" .. who)\n"), 0644))
+
+	b, err := NewBundler(mainFile, false, false)
+	require.NoError(t, err, "NewBundler() should not fail")
+	b.SetObfuscationLevel(2)
+
+	out, err := b.Bundle(false)
+	require.NoError(t, err, "an entry the VM cannot compile is still bundled")
+	assert.Empty(t, b.obfuscator.VMName())
+	assert.Contains(t, out, `"hello \z "`)
+}
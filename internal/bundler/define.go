@@ -0,0 +1,63 @@
+package bundler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+var defineNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetDefines sets compile-time defines. Every global read of a defined name in
+// the entry file and local modules is replaced with the define's value, and
+// code that becomes statically dead is removed. Values are typed: true, false,
+// nil, numbers and quoted strings ("1.2" or '1.2') are Lua literals; anything
+// else is taken as a plain string.
+func (b *Bundler) SetDefines(defines map[string]string) error {
+	b.defines = make(map[string]lua.Expr, len(defines))
+	for name, raw := range defines {
+		if !defineNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid define name %q", name)
+		}
+		b.defines[name] = defineValue(raw)
+	}
+	return nil
+}
+
+// defineValue parses a raw define value as a Lua constant, falling back to a
+// plain string for anything that is not one (e.g. "production").
+func defineValue(raw string) lua.Expr {
+	raw = strings.TrimSpace(raw)
+	if value, err := lua.ParseConstant(raw); err == nil {
+		return value
+	}
+	return lua.StringLiteral(raw)
+}
+
+// applyDefines substitutes the defines into content and folds away the
+// branches they make dead. Content that uses no define, or that does not
+// parse, is returned unchanged; otherwise the result is printed from the AST
+// (and is therefore minified).
+func (b *Bundler) applyDefines(content, label string) string {
+	if len(b.defines) == 0 {
+		return content
+	}
+	chunk, err := lua.Parse(content)
+	if err != nil {
+		if b.verbose {
+			fmt.Printf("⚠️  Defines not applied to %s: %v\n", label, err)
+		}
+		return content
+	}
+	n := lua.ApplyDefines(chunk, b.defines)
+	if n == 0 {
+		return content
+	}
+	lua.FoldConstants(chunk)
+	if b.verbose {
+		fmt.Printf("🔧 Applied %d define reference(s) in %s\n", n, label)
+	}
+	return chunk.Print()
+}
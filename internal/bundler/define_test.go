@@ -0,0 +1,52 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDefines_Values(t *testing.T) {
+	b := &Bundler{}
+	require.NoError(t, b.SetDefines(map[string]string{
+		"DEBUG": "false", "LEVEL": "3", "VERSION": `"1.2"`, "MODE": "production",
+	}))
+	assert.Equal(t, `return false,3,"1.2","production"`,
+		b.applyDefines("return DEBUG, LEVEL, VERSION, MODE", "main"))
+
+	assert.Error(t, b.SetDefines(map[string]string{"not valid": "1"}))
+}
+
+func TestBundle_DefinesRemoveDevCode(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("devtools.lua", `return {}`)
+	write("ui.lua", `local M = {}
+function M.draw()
+	if DEBUG then print("drawing", VERSION) end
+	return "v" .. VERSION
+end
+return M`)
+	write("main.lua", `if DEBUG then
+	require("./devtools").attach()
+end
+return require("./ui")`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	require.NoError(t, b.SetDefines(map[string]string{"DEBUG": "false", "VERSION": `"1.2"`}))
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.NotContains(t, mods, "devtools", "module required only behind a false define must not be embedded")
+	assert.NotContains(t, mods["ui"], "DEBUG")
+	assert.NotContains(t, mods["ui"], "print")
+	assert.Contains(t, mods["ui"], `"v1.2"`)
+	assert.NotContains(t, out, "attach")
+}
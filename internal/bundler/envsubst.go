@@ -3,27 +3,75 @@ package bundler
 import (
 	"fmt"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
 	"github.com/joho/godotenv"
 )
 
-var envVarRegex = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_]*)\}\}`)
+// envVarRegex matches {{NAME}}, optionally with a ":-default" or ":?message"
+// modifier and "|filter" suffixes, e.g. {{TITLE:-Untitled|lua}}.
+var envVarRegex = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_]*)(?::([-?])([^|}]*))?((?:\|[A-Za-z]+)*)\}\}`)
+
+// envFilters transform a substituted value. "lua" emits an escaped Lua string
+// literal, so quotes or newlines in the value cannot break out of it.
+var envFilters = map[string]func(string) string{
+	"lua": lua.QuoteString,
+}
 
 // substituteEnvVars replaces {{VAR_NAME}} placeholders with values from envVars.
-// Missing variables are left as-is and optionally warned about.
-func substituteEnvVars(content string, envVars map[string]string, verbose bool) string {
-	return envVarRegex.ReplaceAllStringFunc(content, func(match string) string {
-		varName := envVarRegex.FindStringSubmatch(match)[1]
-		if val, ok := envVars[varName]; ok {
-			return val
+//
+//	{{VAR:-default}}  uses default when VAR is unset or empty
+//	{{VAR:?message}}  fails with message when VAR is unset or empty
+//	{{VAR|lua}}       applies a filter to the value (see envFilters)
+//
+// Other missing variables are left as-is and optionally warned about, or make
+// the substitution fail when strict is set.
+func substituteEnvVars(content string, envVars map[string]string, verbose, strict bool) (string, error) {
+	var errs []string
+	var unresolved []string
+	out := envVarRegex.ReplaceAllStringFunc(content, func(match string) string {
+		m := envVarRegex.FindStringSubmatch(match)
+		varName, modifier, arg, filters := m[1], m[2], m[3], m[4]
+		val, ok := envVars[varName]
+		switch {
+		case modifier == "-" && val == "":
+			val, ok = arg, true
+		case modifier == "?" && val == "":
+			if arg == "" {
+				arg = "required but not set"
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", varName, arg))
+			return match
+		case !ok:
+			unresolved = append(unresolved, varName)
+			if verbose {
+				fmt.Printf("⚠️  Env var not found: %s\n", varName)
+			}
+			return match
 		}
-		if verbose {
-			fmt.Printf("⚠️  Env var not found: %s\n", varName)
+		for _, name := range strings.Split(strings.TrimPrefix(filters, "|"), "|") {
+			if name == "" {
+				continue
+			}
+			filter, known := envFilters[name]
+			if !known {
+				errs = append(errs, fmt.Sprintf("%s: unknown filter %q", varName, name))
+				return match
+			}
+			val = filter(val)
 		}
-		return match
+		return val
 	})
+	if strict && len(unresolved) > 0 {
+		errs = append(errs, "unresolved placeholder(s): "+strings.Join(unresolved, ", "))
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("env substitution failed: %s", strings.Join(errs, "; "))
+	}
+	return out, nil
 }
 
 // loadEnvFile loads variables from a .env file.
@@ -42,19 +90,92 @@ func loadEnvFile(envFilePath string) (map[string]string, error) {
 	return vars, nil
 }
 
-// BuildEnvVars loads a .env file and merges with OS env vars (OS wins).
-// Exported so cmd package can call it.
-func BuildEnvVars(envFilePath string) (map[string]string, error) {
-	fileVars, err := loadEnvFile(envFilePath)
-	if err != nil {
-		return nil, err
+// EnvVar is a resolved env value and where it came from (a file path or
+// "OS environment").
+type EnvVar struct {
+	Value  string
+	Source string
+}
+
+// EnvOptions selects the env layers LoadEnv reads.
+type EnvOptions struct {
+	File   string   // base env file (default: .env in the working dir)
+	Target string   // profile name: also reads <File>.<Target>
+	Allow  []string // glob patterns (e.g. "LB_*") OS variables must match; empty allows all
+}
+
+// osEnvSource labels values taken from the process environment.
+const osEnvSource = "OS environment"
+
+// EnvLayers returns the env files read for opts, lowest precedence first:
+// <File>, <File>.<Target>, <File>.local.
+func EnvLayers(opts EnvOptions) []string {
+	base := opts.File
+	if base == "" {
+		base = ".env"
+	}
+	layers := []string{base}
+	if opts.Target != "" {
+		layers = append(layers, base+"."+opts.Target)
+	}
+	return append(layers, base+".local")
+}
+
+// LoadEnv reads the layered env files (missing files are skipped) and then
+// the OS environment, each layer overriding the previous one. OS variables
+// are only taken when they match one of opts.Allow (all of them if empty).
+func LoadEnv(opts EnvOptions) (map[string]EnvVar, error) {
+	for _, pattern := range opts.Allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid env allow pattern %q: %w", pattern, err)
+		}
+	}
+	result := make(map[string]EnvVar)
+	for _, file := range EnvLayers(opts) {
+		vars, err := loadEnvFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for name, value := range vars {
+			result[name] = EnvVar{Value: value, Source: file}
+		}
 	}
-	result := fileVars
 	for _, entry := range os.Environ() {
-		parts := strings.SplitN(entry, "=", 2)
-		if len(parts) == 2 {
-			result[parts[0]] = parts[1]
+		name, value, ok := strings.Cut(entry, "=")
+		if ok && envAllowed(name, opts.Allow) {
+			result[name] = EnvVar{Value: value, Source: osEnvSource}
 		}
 	}
 	return result, nil
 }
+
+func envAllowed(name string, allow []string) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	for _, pattern := range allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvValues flattens a LoadEnv result into the name -> value map SetEnvVars takes.
+func EnvValues(vars map[string]EnvVar) map[string]string {
+	values := make(map[string]string, len(vars))
+	for name, v := range vars {
+		values[name] = v.Value
+	}
+	return values
+}
+
+// BuildEnvVars loads the layered env files for envFilePath and merges the OS
+// env vars on top (OS wins). It is LoadEnv without a target or allowlist.
+func BuildEnvVars(envFilePath string) (map[string]string, error) {
+	vars, err := LoadEnv(EnvOptions{File: envFilePath})
+	if err != nil {
+		return nil, err
+	}
+	return EnvValues(vars), nil
+}
@@ -122,7 +122,8 @@ func TestSubstituteEnvVars(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := substituteEnvVars(tt.content, tt.envVars, false)
+			got, err := substituteEnvVars(tt.content, tt.envVars, false, false)
+			require.NoError(t, err)
 			assert.Equal(t, tt.want, got)
 		})
 	}
@@ -130,10 +131,49 @@ func TestSubstituteEnvVars(t *testing.T) {
 
 func TestSubstituteEnvVars_VerboseWarning(t *testing.T) {
 	// Just verify it doesn't panic and still returns the placeholder
-	result := substituteEnvVars("{{MISSING}}", map[string]string{}, true)
+	result, err := substituteEnvVars("{{MISSING}}", map[string]string{}, true, false)
+	require.NoError(t, err)
 	assert.Equal(t, "{{MISSING}}", result)
 }
 
+func TestSubstituteEnvVars_Modifiers(t *testing.T) {
+	env := map[string]string{"NAME": "World", "EMPTY": "", "QUOTE": "a\"b\nc]]"}
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{{NAME:-x}}`, `World`},
+		{`{{MISSING:-fallback value}}`, `fallback value`},
+		{`{{EMPTY:-d}}`, `d`},
+		{`{{MISSING:-}}`, ``},
+		{`{{NAME:?needed}}`, `World`},
+		{`local s = {{QUOTE|lua}}`, `local s = "a\"b\010c]]"`},
+		{`{{MISSING:-it's|lua}}`, `"it's"`},
+	}
+	for _, tt := range tests {
+		got, err := substituteEnvVars(tt.content, env, false, false)
+		require.NoError(t, err, tt.content)
+		assert.Equal(t, tt.want, got, tt.content)
+	}
+
+	_, err := substituteEnvVars(`{{TOKEN:?set TOKEN in .env}}`, env, false, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TOKEN: set TOKEN in .env")
+
+	_, err = substituteEnvVars(`{{NAME|shout}}`, env, false, false)
+	assert.Error(t, err, "unknown filters fail")
+}
+
+func TestSubstituteEnvVars_Strict(t *testing.T) {
+	_, err := substituteEnvVars(`{{A}} {{B:-ok}} {{C}}`, map[string]string{}, false, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "A, C")
+
+	got, err := substituteEnvVars(`{{A}}`, map[string]string{"A": "1"}, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, "1", got)
+}
+
 func TestLoadEnvFile(t *testing.T) {
 	t.Run("existing .env file", func(t *testing.T) {
 		dir := t.TempDir()
@@ -179,6 +219,33 @@ func TestBuildEnvVars_OSOverridesFile(t *testing.T) {
 	assert.Equal(t, "from_os", vars["MY_TEST_VAR"])
 }
 
+func TestLoadEnv_Layers(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, ".env")
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(p, []byte(s), 0644))
+	}
+	write(base, "A=base\nB=base\nC=base\nD=base\n")
+	write(base+".prod", "B=prod\nC=prod\n")
+	write(base+".local", "C=local\n")
+	write(base+".staging", "D=staging\n")
+	t.Setenv("LB_D", "os")
+	t.Setenv("OTHER_LB_VAR", "os")
+
+	vars, err := LoadEnv(EnvOptions{File: base, Target: "prod", Allow: []string{"LB_*"}})
+	require.NoError(t, err)
+	assert.Equal(t, EnvVar{Value: "base", Source: base}, vars["A"])
+	assert.Equal(t, EnvVar{Value: "prod", Source: base + ".prod"}, vars["B"])
+	assert.Equal(t, EnvVar{Value: "local", Source: base + ".local"}, vars["C"], ".local wins over the target profile")
+	assert.Equal(t, "base", vars["D"].Value, "other profiles are not read")
+	assert.Equal(t, EnvVar{Value: "os", Source: osEnvSource}, vars["LB_D"])
+	assert.NotContains(t, vars, "OTHER_LB_VAR", "OS vars outside the allowlist are ignored")
+	assert.NotContains(t, vars, "PATH")
+
+	_, err = LoadEnv(EnvOptions{File: base, Allow: []string{"["}})
+	assert.Error(t, err, "malformed allow patterns fail")
+}
+
 func TestBundleWithEnvVars(t *testing.T) {
 	dir := t.TempDir()
 	mainLua := filepath.Join(dir, "main.lua")
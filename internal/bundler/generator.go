@@ -2,20 +2,83 @@ package bundler
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
+	"unicode"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
 )
 
+// scriptRequirePattern matches require(<instance path>) where the path starts
+// at `script` and continues through .Name, ["Name"] and :WaitForChild("Name")
+// / :FindFirstChild("Name") steps. Group 1 is the instance path expression.
+const scriptRequirePattern = `require\s*\(\s*(script(?:\s*(?:\.\s*[A-Za-z_]\w*|\[\s*(?:"[^"]*"|'[^']*')\s*\]|:\s*(?:WaitForChild|FindFirstChild)\s*\(\s*(?:"[^"]*"|'[^']*')\s*\)))*)\s*\)`
+
 // Module-call detection patterns, compiled once and shared by discovery
 // (processFile) and rewriting (rewriteModuleCalls).
 var (
-	moduleRequireRegex    = regexp.MustCompile(`require\s*\(\s*['"]([^'"]+)['"]\s*\)`)
-	moduleHTTPGetRegex    = regexp.MustCompile(`loadstring\s*\(\s*game:HttpGet\s*\(\s*['"]([^'"]+)['"]\s*\)\s*\)\s*\(\s*\)`)
-	moduleFuncWrapHTTPGet = regexp.MustCompile(`\w+\s*\([^)]*loadstring\s*\(\s*game:HttpGet`)
+	scriptRequireRegex = regexp.MustCompile(scriptRequirePattern)
+	moduleRequireRegex = regexp.MustCompile(`require\s*\(\s*['"]([^'"]+)['"]\s*\)`)
 )
 
+// bundleNames are the locals generateBundle declares around the modules.
+var bundleNames = []string{"EmbeddedModules", "_cache", "_cached", "loadModule", verifyName}
+
+// checkPreludeNames makes sure no module, the entry, or the bundle scaffold
+// declares, assigns or reads the name of the level-3 string decoder or of the
+// virtual machine: a local of that name would shadow the prelude and an
+// assignment would replace it, corrupting every encrypted string or
+// virtualized module after it. Units are checked as written, before
+// obfuscation adds the calls to the preludes; a unit that does not parse is
+// checked token by token instead.
+func (b *Bundler) checkPreludeNames(mainContent string) error {
+	if b.obfuscator == nil {
+		return nil
+	}
+	type prelude struct{ what, name string }
+	var preludes []prelude
+	if b.obfuscateLevel >= 3 {
+		preludes = append(preludes, prelude{"string decoder", b.obfuscator.DecoderName()})
+	}
+	if vm := b.obfuscator.VMName(); vm != "" {
+		preludes = append(preludes, prelude{"virtual machine", vm})
+	}
+	units := map[string]string{"main": mainContent}
+	maps.Copy(units, b.modules)
+	maps.Copy(units, b.plainModules)
+	keys := slices.Sorted(maps.Keys(units))
+	for _, p := range preludes {
+		if slices.Contains(bundleNames, p.name) {
+			return fmt.Errorf("%s name %s collides with the bundle's own locals", p.what, p.name)
+		}
+		for _, key := range keys {
+			if usesName(units[key], p.name) {
+				return fmt.Errorf("%s name %s collides with a name in %s; code after it would be corrupted (rebuild to pick a new name)", p.what, p.name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// usesName is lua.UsesName for source that may not parse.
+func usesName(content, name string) bool {
+	chunk, err := lua.Parse(content)
+	if err == nil {
+		return lua.UsesName(chunk, name)
+	}
+	return slices.Contains(strings.FieldsFunc(content, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), name)
+}
+
 // generateBundle creates the final bundled output
 func (b *Bundler) generateBundle(mainContent string) string {
+	if b.antiTamper && b.obfuscator != nil {
+		return b.generateCheckedBundle(mainContent)
+	}
+
 	var output strings.Builder
 
 	output.WriteString("-- Bundled Lua Script\n")
@@ -23,29 +86,26 @@ func (b *Bundler) generateBundle(mainContent string) string {
 	output.WriteString("-- https://github.com/alfin-efendy/lua-bundler\n\n")
 
 	// Inject the level-3 string-decoder once, before any module closure that
-	// references it (closures capture _d as an upvalue).
+	// references it (closures capture the decoder as an upvalue).
 	if b.obfuscateLevel >= 3 && b.obfuscator != nil {
 		if prelude := b.obfuscator.DecoderPrelude(); prelude != "" {
 			output.WriteString(prelude)
 			output.WriteString("\n\n")
 		}
 	}
+	// Likewise the interpreter of virtualized modules, if there are any.
+	if b.obfuscator != nil {
+		if prelude := b.obfuscator.VMPrelude(); prelude != "" {
+			output.WriteString(prelude)
+			output.WriteString("\n\n")
+		}
+	}
 
 	// Generate EmbeddedModules table
 	output.WriteString("local EmbeddedModules = {}\n\n")
 
 	// Add loadModule function (memoized, like require)
-	output.WriteString("-- Load module helper (memoized, like require)\n")
-	output.WriteString("local _cache, _cached = {}, {}\n")
-	output.WriteString("local function loadModule(url)\n")
-	output.WriteString("    if _cached[url] then return _cache[url] end\n")
-	output.WriteString("    if EmbeddedModules[url] then\n")
-	output.WriteString("        _cache[url] = EmbeddedModules[url]()\n")
-	output.WriteString("        _cached[url] = true\n")
-	output.WriteString("        return _cache[url]\n")
-	output.WriteString("    end\n")
-	output.WriteString("    return require(url)\n")
-	output.WriteString("end\n\n")
+	writeLoadModule(&output, "")
 
 	// Add all modules
 	for path, content := range b.modules {
@@ -53,6 +113,13 @@ func (b *Bundler) generateBundle(mainContent string) string {
 		output.WriteString(fmt.Sprintf("EmbeddedModules[\"%s\"] = function()\n", escapeString(path)))
 
 		// Indent content
+		if b.assetModules[path] {
+			// Written as is: indenting would change multi-line long strings.
+			output.WriteString(content + "\n")
+			output.WriteString("end\n\n")
+			continue
+		}
+
 		lines := strings.Split(content, "\n")
 		for _, line := range lines {
 			if strings.TrimSpace(line) != "" {
@@ -71,8 +138,9 @@ func (b *Bundler) generateBundle(mainContent string) string {
 	return output.String()
 }
 
-// rewriteModuleCalls rewrites local require() and direct loadstring(HttpGet())()
-// calls in content into loadModule(canonicalKey) calls. currentFile gives the
+// rewriteModuleCalls rewrites local require(), resolvable require(script...)
+// and direct loadstring(HttpGet())() calls in content into
+// loadModule(canonicalKey) calls. currentFile gives the
 // caller's location so relative require paths resolve to canonical keys. It runs
 // on raw (pre-obfuscation) source, so the function-wrapped-HttpGet skip is
 // evaluated on readable, multi-line text.
@@ -80,20 +148,20 @@ func (b *Bundler) rewriteModuleCalls(content, currentFile string) string {
 	processed := content
 
 	// Replace direct loadstring(game:HttpGet(url))() — skip lines where it's wrapped in a call.
-	lines := strings.Split(processed, "\n")
-	for i, line := range lines {
-		if moduleFuncWrapHTTPGet.MatchString(line) {
-			continue
-		}
-		lines[i] = moduleHTTPGetRegex.ReplaceAllStringFunc(line, func(match string) string {
-			m := moduleHTTPGetRegex.FindStringSubmatch(match)
-			if len(m) > 1 {
-				return fmt.Sprintf("loadModule(\"%s\")", escapeString(m[1]))
-			}
-			return match
-		})
+	loads := b.remoteLoads(processed)
+	for i := len(loads) - 1; i >= 0; i-- {
+		l := loads[i]
+		processed = processed[:l.start] + l.replacement + processed[l.end:]
 	}
-	processed = strings.Join(lines, "\n")
+
+	// Replace require(script...) instance paths that resolve on disk.
+	processed = scriptRequireRegex.ReplaceAllStringFunc(processed, func(match string) string {
+		m := scriptRequireRegex.FindStringSubmatch(match)
+		if resolved, ok := b.resolveScriptRequire(currentFile, m[1]); ok {
+			return fmt.Sprintf("loadModule(\"%s\")", escapeString(b.keyForPath(resolved)))
+		}
+		return match
+	})
 
 	// Replace local require() with loadModule(canonicalKey).
 	processed = moduleRequireRegex.ReplaceAllStringFunc(processed, func(match string) string {
@@ -180,12 +180,13 @@ func TestGenerateBundle_InjectsDecoderAtLevel3(t *testing.T) {
 	require.NoError(t, err)
 	b.SetObfuscationLevel(3)
 	out := b.generateBundle(`return 1`)
+	decl := "local " + b.obfuscator.DecoderName() + "="
 
 	// Decoder defined exactly once, before EmbeddedModules.
-	if strings.Count(out, "local _d=") != 1 {
-		t.Fatalf("expected exactly one _d decoder, got %d:\n%s", strings.Count(out, "local _d="), out)
+	if strings.Count(out, decl) != 1 {
+		t.Fatalf("expected exactly one decoder, got %d:\n%s", strings.Count(out, decl), out)
 	}
-	di := strings.Index(out, "local _d=")
+	di := strings.Index(out, decl)
 	ei := strings.Index(out, "local EmbeddedModules")
 	if di < 0 || ei < 0 || di > ei {
 		t.Fatalf("decoder must precede EmbeddedModules (d=%d, e=%d)", di, ei)
@@ -196,10 +197,10 @@ func TestGenerateBundle_NoDecoderBelowLevel3(t *testing.T) {
 	b, err := NewBundler("test.lua", false, false)
 	require.NoError(t, err)
 	b.SetObfuscationLevel(2)
+	assert.Empty(t, b.obfuscator.DecoderName())
+	assert.Empty(t, b.obfuscator.DecoderPrelude())
 	out := b.generateBundle(`return 1`)
-	if strings.Contains(out, "local _d=") {
-		t.Fatalf("level 2 bundle must not contain a decoder:\n%s", out)
-	}
+	assert.NotRegexp(t, `local _0x\w+=`, out, "level 2 bundle must not declare a decoder")
 }
 
 func TestGenerateBundle_ModuleIndentation(t *testing.T) {
@@ -272,12 +273,13 @@ func TestRewriteModuleCalls_SkipsFunctionWrappedHttpGet(t *testing.T) {
 	}
 }
 
-func TestRewriteModuleCalls_SkipsBareFunctionWrappedHttpGet(t *testing.T) {
+func TestRewriteModuleCalls_EmbedsBareFunctionWrappedHttpGet(t *testing.T) {
 	b, err := NewBundler("x.lua", false, false)
 	require.NoError(t, err)
+	// The load is evaluated now; only its result is passed on.
 	in := `queue_on_teleport(loadstring(game:HttpGet("https://e/x.lua"))())`
-	if got := b.rewriteModuleCalls(in, "x.lua"); got != in {
-		t.Fatalf("bare function-wrapped HttpGet must NOT be rewritten: %q", got)
+	if got := b.rewriteModuleCalls(in, "x.lua"); got != `queue_on_teleport(loadModule("https://e/x.lua"))` {
+		t.Fatalf("bare function-wrapped HttpGet must be rewritten: %q", got)
 	}
 }
 
@@ -322,3 +324,30 @@ func TestGenerateBundle_LoadModuleMemoizes(t *testing.T) {
 		t.Fatalf("loadModule must memoize via _cache/_cached: %s", out)
 	}
 }
+
+func TestCheckDecoderName(t *testing.T) {
+	b, err := NewBundler("test.lua", false, false)
+	require.NoError(t, err)
+	b.SetObfuscationLevel(3)
+	d := b.obfuscator.DecoderName()
+
+	b.plainModules["ok"] = "local t = {} t." + d + " = 1 return t"
+	assert.NoError(t, b.checkPreludeNames("return 1"), "field names are not bindings")
+
+	for _, src := range []string{
+		"local " + d + " = 1 return " + d,
+		"function f(" + d + ") end",
+		d + " = print",
+		"local = " + d + " $$$", // unparseable: token check
+	} {
+		b.plainModules["bad"] = src
+		err := b.checkPreludeNames("return 1")
+		require.Error(t, err, src)
+		assert.Contains(t, err.Error(), "bad")
+	}
+	delete(b.plainModules, "bad")
+	assert.Error(t, b.checkPreludeNames("local "+d+" = 1"), "the entry is checked too")
+
+	b.SetObfuscationLevel(2)
+	assert.NoError(t, b.checkPreludeNames("return 1"))
+}
@@ -104,12 +104,13 @@ func TestBundle_EzRbxUI_Obfuscated3(t *testing.T) {
 	out, err := b.Bundle(true)
 	require.NoError(t, err)
 
-	assert.Contains(t, out, "local _d=", "expected the injected string decoder")
-	assert.Contains(t, out, "_d(", "expected encrypted string decoder calls")
+	d := b.obfuscator.DecoderName()
+	assert.Contains(t, out, "local "+d+"=", "expected the injected string decoder")
+	assert.Contains(t, out, d+"(", "expected encrypted string decoder calls")
 
-	assert.NotContains(t, out, "require(_d(", "require paths must never be encrypted")
-	assert.NotContains(t, out, "loadModule(_d(", "loadModule keys must never be encrypted")
-	assert.NotContains(t, out, "HttpGet(_d(", "HttpGet URLs must never be encrypted")
+	assert.NotContains(t, out, "require("+d+"(", "require paths must never be encrypted")
+	assert.NotContains(t, out, "loadModule("+d+"(", "loadModule keys must never be encrypted")
+	assert.NotContains(t, out, "HttpGet("+d+"(", "HttpGet URLs must never be encrypted")
 
 	if luac := findLuac(); luac != "" {
 		tmp := filepath.Join(t.TempDir(), "obf3.lua")
@@ -0,0 +1,129 @@
+package bundler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// integrityMod is the prime the integrity hash is reduced by. h*257+255
+// stays below 2^53, so Lua's doubles compute the same hash as Go.
+const integrityMod = 4294967291
+
+// verifyName is the bundle local that checks and loads each chunk.
+const verifyName = "_verify"
+
+// integrityPrelude defines verifyName: it hashes a chunk's source, fails with
+// a plain error naming the chunk if the hash is not the one recorded at build
+// time, and otherwise compiles it.
+const integrityPrelude = `-- Integrity checks: each chunk below is hashed before it is loaded
+local function _verify(name, src, sum)
+    local h = 0
+    for i = 1, #src do
+        h = (h * 257 + src:byte(i)) % 4294967291
+    end
+    if h ~= sum then
+        error("integrity check failed: " .. name, 0)
+    end
+    return assert((loadstring or load)(src, "=" .. name))
+end
+
+`
+
+// SetAntiTamper embeds integrity checks in obfuscated bundles: the string
+// decoder, the virtual machine and loadModule, every module and the entry
+// are embedded as source strings that are hashed when the bundle starts and
+// only loaded if they are unchanged. It needs obfuscation level 1 or higher
+// (Bundle fails without it), and loadstring (or load) at runtime.
+func (b *Bundler) SetAntiTamper(enabled bool) {
+	b.antiTamper = enabled
+}
+
+// integrityHash is the hash _verify computes, over the bytes of s.
+func integrityHash(s string) uint64 {
+	var h uint64
+	for i := 0; i < len(s); i++ {
+		h = (h*257 + uint64(s[i])) % integrityMod
+	}
+	return h
+}
+
+// verifiedChunk returns a _verify call that checks and compiles src.
+func verifiedChunk(name, src string) string {
+	return fmt.Sprintf("%s(%s, %s, %d)", verifyName, lua.QuoteString(name), lua.QuoteString(src), integrityHash(src))
+}
+
+// sharedNames are the locals the core chunk defines and every other chunk
+// receives as arguments: the string decoder and the virtual machine, if the
+// bundle has them, and loadModule.
+func (b *Bundler) sharedNames() []string {
+	var names []string
+	if b.obfuscateLevel >= 3 {
+		names = append(names, b.obfuscator.DecoderName())
+	}
+	if vm := b.obfuscator.VMName(); vm != "" {
+		names = append(names, vm)
+	}
+	return append(names, "loadModule")
+}
+
+// generateCheckedBundle is generateBundle with integrity checks (see
+// SetAntiTamper). It runs on the final, obfuscated units, and the release
+// pass over the bundle keeps string literals as written, so the hashes match
+// the text that runs.
+func (b *Bundler) generateCheckedBundle(mainContent string) string {
+	var output strings.Builder
+	shared := strings.Join(b.sharedNames(), ", ")
+	header := "local " + shared + " = ...\n"
+
+	output.WriteString("-- Bundled Lua Script\n")
+	output.WriteString("-- Generated by Lua Bundler\n")
+	output.WriteString("-- https://github.com/alfin-efendy/lua-bundler\n\n")
+	output.WriteString(integrityPrelude)
+
+	// The preludes and loadModule form the core chunk, which hands them to
+	// the others.
+	var core strings.Builder
+	if b.obfuscateLevel >= 3 {
+		core.WriteString(b.obfuscator.DecoderPrelude() + "\n")
+	}
+	if prelude := b.obfuscator.VMPrelude(); prelude != "" {
+		core.WriteString(prelude + "\n")
+	}
+	core.WriteString("local EmbeddedModules = {}\n")
+	writeLoadModule(&core, shared)
+	core.WriteString("return EmbeddedModules, " + shared + "\n")
+	output.WriteString(fmt.Sprintf("local EmbeddedModules, %s = %s()\n\n", shared, verifiedChunk("core", core.String())))
+
+	for key, content := range b.modules {
+		// Remote modules are not obfuscated, so their calls were not
+		// stripped; the release pass cannot reach them inside a string.
+		if b.release && b.httpModules[key] {
+			content = b.stripUnitCalls(content)
+		}
+		output.WriteString(fmt.Sprintf("-- Module: %s\n", key))
+		output.WriteString(fmt.Sprintf("EmbeddedModules[\"%s\"] = %s\n\n", escapeString(key), verifiedChunk(key, header+content)))
+	}
+
+	output.WriteString("-- Main Script\n")
+	output.WriteString(fmt.Sprintf("return %s(%s)\n", verifiedChunk("main", header+mainContent), shared))
+
+	return output.String()
+}
+
+// writeLoadModule writes the memoized loadModule helper. args are the
+// arguments each module function is called with.
+func writeLoadModule(output *strings.Builder, args string) {
+	output.WriteString("-- Load module helper (memoized, like require)\n")
+	output.WriteString("local _cache, _cached = {}, {}\n")
+	output.WriteString("local function loadModule(url)\n")
+	output.WriteString("    if _cached[url] then return _cache[url] end\n")
+	output.WriteString("    if EmbeddedModules[url] then\n")
+	output.WriteString(fmt.Sprintf("        _cache[url] = EmbeddedModules[url](%s)\n", args))
+	output.WriteString("        _cached[url] = true\n")
+	output.WriteString("        return _cache[url]\n")
+	output.WriteString("    end\n")
+	output.WriteString("    return require(url)\n")
+	output.WriteString("end\n\n")
+}
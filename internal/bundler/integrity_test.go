@@ -0,0 +1,100 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrityHash(t *testing.T) {
+	assert.Equal(t, uint64(0), integrityHash(""))
+	assert.Equal(t, uint64(97*257+98), integrityHash("ab"))
+	assert.NotEqual(t, integrityHash("ab"), integrityHash("ba"))
+	assert.Less(t, integrityHash(strings.Repeat("\xff", 1000)), uint64(integrityMod))
+}
+
+// verifiedChunks returns the chunks of a bundle by name, with the hash each
+// _verify call carries. Chunk sources are QuoteString literals.
+func verifiedChunks(t *testing.T, bundle string) (map[string]string, map[string]uint64) {
+	t.Helper()
+	decode := regexp.MustCompile(`\\(\d{3}|.)`)
+	unquote := func(lit string) string {
+		return decode.ReplaceAllStringFunc(lit[1:len(lit)-1], func(esc string) string {
+			if n, err := strconv.Atoi(esc[1:]); err == nil {
+				return string([]byte{byte(n)})
+			}
+			return esc[1:]
+		})
+	}
+	srcs, sums := map[string]string{}, map[string]uint64{}
+	for _, m := range regexp.MustCompile(`_verify\(("[^"]*"),\s*("(?:[^"\\]|\\.)*"),\s*(\d+)\)`).FindAllStringSubmatch(bundle, -1) {
+		name := unquote(m[1])
+		srcs[name] = unquote(m[2])
+		sum, err := strconv.ParseUint(m[3], 10, 64)
+		require.NoError(t, err)
+		sums[name] = sum
+	}
+	return srcs, sums
+}
+
+func TestBundle_AntiTamper(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "license.lua"), []byte(`local License = {}
+function License.check(key)
+	print("checking", key)
+	return key == "let me in"
+end
+return License`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`local License = require("./license")
+if not License.check("let me in") then error("no license") end
+print("licensed")`), 0o644))
+
+	for _, release := range []bool{false, true} {
+		b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+		require.NoError(t, err)
+		b.SetObfuscationLevel(3)
+		b.SetAntiTamper(true)
+		out, err := b.Bundle(release)
+		require.NoError(t, err)
+
+		_, err = lua.Parse(out)
+		require.NoError(t, err, "bundle must parse:\n%s", out)
+		assert.Contains(t, out, "integrity check failed")
+		assert.NotContains(t, out, "License", "units are embedded obfuscated")
+
+		srcs, sums := verifiedChunks(t, out)
+		assert.Len(t, srcs, 3, "core, license and main")
+		for _, name := range []string{"core", "license", "main"} {
+			src, ok := srcs[name]
+			require.True(t, ok, "missing chunk %s", name)
+			assert.Equal(t, integrityHash(src), sums[name], "%s: the hash must match the final text (release=%v)", name, release)
+			_, err := lua.Parse(src)
+			assert.NoError(t, err, "%s must compile", name)
+		}
+		decoder := b.obfuscator.DecoderName()
+		assert.Contains(t, srcs["core"], "local "+decoder+"=", "the decoder is checked")
+		assert.Contains(t, srcs["core"], "local function loadModule", "loadModule is checked")
+		assert.True(t, strings.HasPrefix(srcs["license"], "local "+decoder+", loadModule = ..."))
+		assert.NotContains(t, out[:strings.Index(out, "_verify(\"core\"")], decoder+"=", "the decoder is only defined inside the core chunk")
+		if release {
+			assert.NotContains(t, srcs["license"], "print", "release calls are stripped inside the chunks")
+		}
+	}
+}
+
+func TestBundle_AntiTamperNeedsObfuscation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`print("hi")`), 0o644))
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetAntiTamper(true)
+	_, err = b.Bundle(false)
+	assert.ErrorContains(t, err, "anti-tamper needs obfuscation")
+}
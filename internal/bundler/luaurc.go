@@ -0,0 +1,151 @@
+package bundler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// luaurcName is the per-directory Luau configuration file read by luau-lsp and
+// the Luau require-by-string resolver.
+const luaurcName = ".luaurc"
+
+// luaurc is the subset of a .luaurc file the bundler honors.
+type luaurc struct {
+	Aliases map[string]string `json:"aliases"`
+}
+
+// lookupAlias resolves the alias name (without "@") as seen from currentFile,
+// returning the absolute directory it maps to. Like Luau, it consults the
+// .luaurc in the requiring file's directory and then each parent, the nearest
+// definition winning; alias names are case-insensitive. "@self" is the
+// requiring file's own directory. Aliases set with SetAliases apply last.
+func (b *Bundler) lookupAlias(currentFile, name string) (string, bool, error) {
+	if strings.EqualFold(name, "self") {
+		return filepath.Dir(currentFile), true, nil
+	}
+
+	if !strings.Contains(currentFile, "://") {
+		dir, err := filepath.Abs(filepath.Dir(currentFile))
+		if err != nil {
+			return "", false, err
+		}
+		for {
+			aliases, err := b.luaurcAliases(dir)
+			if err != nil {
+				return "", false, err
+			}
+			if target, ok := aliases[strings.ToLower(name)]; ok {
+				return target, true, nil
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+
+	if target, ok := b.aliases[name]; ok {
+		return b.fromBase(target), true, nil
+	}
+	return "", false, nil
+}
+
+// luaurcAliases returns the aliases declared by dir/.luaurc, keyed by
+// lowercased name with targets made absolute relative to dir. A missing file
+// yields nil. Results are cached per directory for the life of the Bundler.
+func (b *Bundler) luaurcAliases(dir string) (map[string]string, error) {
+	if aliases, ok := b.luaurcs[dir]; ok {
+		return aliases, nil
+	}
+
+	var aliases map[string]string
+	data, err := os.ReadFile(filepath.Join(dir, luaurcName))
+	switch {
+	case err == nil:
+		var cfg luaurc
+		if err := json.Unmarshal([]byte(stripJSONC(string(data))), &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, luaurcName), err)
+		}
+		aliases = make(map[string]string, len(cfg.Aliases))
+		for name, target := range cfg.Aliases {
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+			aliases[strings.ToLower(strings.TrimPrefix(name, "@"))] = filepath.Clean(target)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dir, luaurcName), err)
+	}
+
+	if b.luaurcs == nil {
+		b.luaurcs = make(map[string]map[string]string)
+	}
+	b.luaurcs[dir] = aliases
+	return aliases, nil
+}
+
+// stripJSONC removes // and /* */ comments and trailing commas before a
+// closing '}' or ']', which .luaurc files allow but encoding/json rejects.
+// String contents are copied untouched.
+func stripJSONC(src string) string {
+	var noComments strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '"':
+			j := jsonStringEnd(src, i)
+			noComments.WriteString(src[i:j])
+			i = j - 1
+		case strings.HasPrefix(src[i:], "//"):
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+				break
+			}
+			i += 2 + end + 1
+		default:
+			noComments.WriteByte(src[i])
+		}
+	}
+
+	s := noComments.String()
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			j := jsonStringEnd(s, i)
+			out.WriteString(s[i:j])
+			i = j - 1
+		case ',':
+			rest := strings.TrimLeft(s[i+1:], " \t\r\n")
+			if strings.HasPrefix(rest, "}") || strings.HasPrefix(rest, "]") {
+				continue // trailing comma
+			}
+			out.WriteByte(',')
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
+
+// jsonStringEnd returns the index just past the JSON string literal whose
+// opening quote is at s[i], or len(s) if it is unterminated.
+func jsonStringEnd(s string, i int) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(s)
+}
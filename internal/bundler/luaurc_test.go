@@ -0,0 +1,77 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuaurcAliases_Hierarchical(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write(".luaurc", `{
+		// project-wide aliases
+		"aliases": {
+			"Shared": "src/shared",
+			"pkg": "Packages", /* trailing comma below */
+		},
+	}`)
+	write("game/.luaurc", `{"aliases": {"pkg": "../vendor"}}`)
+	write("main.lua", `return nil`)
+	write("src/shared/net.lua", `return {}`)
+	write("Packages/json.lua", `return {}`)
+	write("vendor/json.lua", `return {}`)
+	write("game/ui.lua", `return {}`)
+	write("game/widgets/init.lua", `return {}`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+
+	entry := filepath.Join(dir, "main.lua")
+	ui := filepath.Join(dir, "game/ui.lua")
+	assert.Equal(t, "src/shared/net", b.canonicalKey(entry, "@shared/net"), "alias names are case-insensitive")
+	assert.Equal(t, "src/shared/net", b.canonicalKey(ui, "@Shared/net"), "parent .luaurc applies to subdirectories")
+	assert.Equal(t, "Packages/json", b.canonicalKey(entry, "@pkg/json"))
+	assert.Equal(t, "vendor/json", b.canonicalKey(ui, "@pkg/json"), "nearest .luaurc wins")
+	assert.Equal(t, "game/widgets/init", b.canonicalKey(ui, "@self/widgets"))
+}
+
+func TestLuaurcAliases_BundlesAliasedRequire(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src/shared"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".luaurc"), []byte(`{"aliases":{"shared":"src/shared"}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src/shared/net.luau"), []byte(`return { ok = true }`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`local net = require("@shared/net")`), 0o644))
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+	assert.Contains(t, b.GetModules(), "src/shared/net")
+	assert.Contains(t, out, `loadModule("src/shared/net")`)
+}
+
+func TestLuaurcAliases_MalformedFails(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".luaurc"), []byte(`{"aliases": [}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`require("@x/y")`), 0o644))
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	_, err = b.Bundle(false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ".luaurc")
+}
+
+func TestStripJSONC(t *testing.T) {
+	in := `{"a": "x // not a comment", /* c */ "b": [1, 2,], // tail
+}`
+	assert.Equal(t, `{"a": "x // not a comment",  "b": [1, 2] 
+}`, stripJSONC(in))
+}
@@ -0,0 +1,136 @@
+package bundler
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// privateFieldRegex matches the private-member convention: one leading
+// underscore followed by a letter or digit (_state, _internalHelper). Names
+// starting with two underscores are metamethods and never match.
+var privateFieldRegex = regexp.MustCompile(`^_[A-Za-z0-9]\w*$`)
+
+// apiFields are Lua and Roblox member names SetMangleFields refuses: the
+// engine reads them by name, so renaming them breaks the program even when
+// every use in the bundle is renamed.
+var apiFields = map[string]bool{
+	"__index": true, "__newindex": true, "__call": true, "__tostring": true,
+	"__len": true, "__eq": true, "__lt": true, "__le": true, "__concat": true,
+	"__unm": true, "__add": true, "__sub": true, "__mul": true, "__div": true,
+	"__idiv": true, "__mod": true, "__pow": true, "__mode": true,
+	"__metatable": true, "__gc": true, "__close": true, "__iter": true,
+	"__type": true, "__name": true, "n": true,
+	"Name": true, "Parent": true, "ClassName": true, "Value": true,
+	"Changed": true, "Connect": true, "Once": true, "Disconnect": true,
+	"Wait": true, "Fire": true, "Event": true, "Destroy": true, "Clone": true,
+	"GetService": true, "WaitForChild": true, "FindFirstChild": true,
+	"GetChildren": true, "GetDescendants": true, "IsA": true, "Position": true,
+	"Size": true, "CFrame": true, "Text": true, "Visible": true,
+	"Enabled": true, "new": true,
+}
+
+// SetManglePrivateFields enables renaming of private table fields, those
+// named with one leading underscore (self._state, Module._helper), across
+// the entry and local modules (see mangleFields).
+func (b *Bundler) SetManglePrivateFields(enabled bool) {
+	b.manglePrivate = enabled
+}
+
+// SetMangleFields sets further field names renamed like private fields,
+// whatever their name. Metamethods and common Lua and Roblox API members are
+// rejected.
+func (b *Bundler) SetMangleFields(names []string) error {
+	b.mangleNames = make(map[string]bool, len(names))
+	for _, name := range names {
+		if !defineNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+		if apiFields[name] {
+			return fmt.Errorf("field %q is a Lua or Roblox API member and cannot be mangled", name)
+		}
+		b.mangleNames[name] = true
+	}
+	return nil
+}
+
+// mangleFields renames the selected table fields consistently in the entry
+// and every local module, and returns the entry. A field is left alone when
+// the entry exports it, when it is read through game, workspace, script or
+// another instance root, or when a remote module or a unit that does not
+// parse uses the name, since those cannot be renamed with it. Dynamic
+// accesses such as self["_" .. k] are not seen; fields used that way must
+// not be selected.
+func (b *Bundler) mangleFields(mainContent string) string {
+	if !b.manglePrivate && len(b.mangleNames) == 0 {
+		return mainContent
+	}
+	units := map[string]string{"main": mainContent}
+	for key, content := range b.modules {
+		if !b.assetModules[key] {
+			units[key] = content
+		}
+	}
+
+	chunks := map[string]*lua.Chunk{}
+	names, keep := map[string]bool{}, map[string]bool{}
+	for key, content := range units {
+		chunk, err := lua.Parse(content)
+		if err != nil {
+			if b.verbose {
+				fmt.Printf("⚠️  Fields not mangled in %s: %v\n", key, err)
+			}
+			for _, word := range strings.FieldsFunc(content, func(r rune) bool {
+				return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}) {
+				keep[word] = true
+			}
+			continue
+		}
+		if b.httpModules[key] {
+			lua.FieldNames(chunk, keep, keep)
+			continue
+		}
+		lua.FieldNames(chunk, names, keep)
+		chunks[key] = chunk
+	}
+	if main := chunks["main"]; main != nil {
+		for _, name := range lua.ExportedFields(main) {
+			keep[name] = true
+		}
+	}
+
+	var fields []string
+	for name := range names {
+		if !keep[name] && (b.mangleNames[name] || b.manglePrivate && privateFieldRegex.MatchString(name)) {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return mainContent
+	}
+	slices.Sort(fields)
+	taken := maps.Clone(names)
+	maps.Copy(taken, keep)
+	mapping := lua.NewFieldNames(fields, taken, b.watermark)
+	if b.verbose {
+		fmt.Printf("🔀 Mangling %d field(s): %s\n", len(fields), strings.Join(fields, ", "))
+	}
+
+	for key, chunk := range chunks {
+		if lua.MangleFields(chunk, mapping) == 0 {
+			continue
+		}
+		if key == "main" {
+			mainContent = chunk.Print()
+		} else {
+			b.modules[key] = chunk.Print()
+		}
+	}
+	return mainContent
+}
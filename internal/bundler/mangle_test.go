@@ -0,0 +1,75 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetMangleFields_Validates(t *testing.T) {
+	b := &Bundler{}
+	require.NoError(t, b.SetMangleFields([]string{"cache", "queue"}))
+	assert.Error(t, b.SetMangleFields([]string{"not valid"}))
+	assert.Error(t, b.SetMangleFields([]string{"Connect"}), "Roblox API members are refused")
+	assert.Error(t, b.SetMangleFields([]string{"__index"}), "metamethods are refused")
+}
+
+func TestBundle_ManglesPrivateFieldsAcrossModules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("counter.lua", `local Counter = {}
+Counter.__index = Counter
+function Counter.new()
+	return setmetatable({ _count = 0, cache = {} }, Counter)
+end
+function Counter:_bump() self._count = self._count + 1 end
+function Counter:inc() self:_bump() return self._count end
+return Counter`)
+	write("main.lua", `local Counter = require("./counter")
+local c = Counter.new()
+c:inc()
+print(c._count, c.cache, script.Parent._Config, `+"`{c._count}`"+`)
+return { _version = 1 }`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetManglePrivateFields(true)
+	require.NoError(t, b.SetMangleFields([]string{"cache"}))
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	for _, renamed := range []string{"_count", "_bump", "c.cache", "cache={}"} {
+		assert.NotContains(t, out, renamed)
+	}
+	assert.Contains(t, out, "__index", "metamethods keep their names")
+	assert.Contains(t, out, "._Config", "fields read through script are kept")
+	assert.Contains(t, out, "_version", "the entry's exports are kept")
+
+	// The entry reads the counter's fields under the counter's new names.
+	mangled := func(src string) map[string]bool {
+		chunk, err := lua.Parse(src)
+		require.NoError(t, err)
+		names, fields := map[string]bool{}, map[string]bool{}
+		lua.FieldNames(chunk, names, map[string]bool{})
+		for name := range names {
+			if strings.HasPrefix(name, "_0x") {
+				fields[name] = true
+			}
+		}
+		return fields
+	}
+	inCounter := mangled(b.modules["counter"])
+	inMain := mangled(out[strings.Index(out, "-- Main Script"):])
+	assert.Len(t, inCounter, 3, "_count, _bump and cache")
+	assert.Len(t, inMain, 2, "_count and cache")
+	for name := range inMain {
+		assert.True(t, inCounter[name], "%s is not a counter field", name)
+	}
+}
@@ -0,0 +1,157 @@
+package bundler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// directiveRegex matches a preprocessor directive line such as
+// `--#if RELEASE` or `--#include "mock/http.lua"`.
+var directiveRegex = regexp.MustCompile(`^\s*--#(if|elseif|else|endif|include)\b\s*(.*?)\s*$`)
+
+var includePathRegex = regexp.MustCompile(`^["']([^"']+)["']$`)
+
+// maxIncludeDepth bounds nested --#include directives (and catches cycles).
+const maxIncludeDepth = 16
+
+// condFrame is one open --#if block.
+type condFrame struct {
+	parentActive bool // lines outside this block are emitted
+	taken        bool // some branch of this block has been selected
+	active       bool // lines in the current branch are emitted
+	sawElse      bool
+	line         int // line of the --#if, for error messages
+}
+
+// preprocess evaluates --#if/--#elseif/--#else/--#endif and --#include
+// directives in content before any require discovery. Conditions are Lua
+// expressions over the env vars and defines (defines win; unknown names are
+// nil). Lines in disabled branches are blanked so line numbers stay stable and
+// requires inside them are never discovered; included files are inlined.
+func (b *Bundler) preprocess(filePath, content string) (string, error) {
+	if !strings.Contains(content, "--#") {
+		return content, nil
+	}
+	return b.preprocessDepth(filePath, content, 0)
+}
+
+func (b *Bundler) preprocessDepth(filePath, content string, depth int) (string, error) {
+	lines := strings.Split(content, "\n")
+	var stack []*condFrame
+	active := true
+	for i, line := range lines {
+		m := directiveRegex.FindStringSubmatch(line)
+		if m == nil {
+			if !active {
+				lines[i] = ""
+			}
+			continue
+		}
+		directive, arg := m[1], m[2]
+		where := fmt.Sprintf("%s:%d", filePath, i+1)
+		switch directive {
+		case "if":
+			cond, err := b.evalDirective(arg, active)
+			if err != nil {
+				return "", fmt.Errorf("%s: --#if %s: %w", where, arg, err)
+			}
+			stack = append(stack, &condFrame{parentActive: active, taken: cond, active: active && cond, line: i + 1})
+		case "elseif", "else":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("%s: --#%s without --#if", where, directive)
+			}
+			f := stack[len(stack)-1]
+			if f.sawElse {
+				return "", fmt.Errorf("%s: --#%s after --#else", where, directive)
+			}
+			cond := true
+			if directive == "else" {
+				f.sawElse = true
+			} else {
+				var err error
+				if cond, err = b.evalDirective(arg, f.parentActive && !f.taken); err != nil {
+					return "", fmt.Errorf("%s: --#elseif %s: %w", where, arg, err)
+				}
+			}
+			f.active = f.parentActive && !f.taken && cond
+			f.taken = f.taken || cond
+		case "endif":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("%s: --#endif without --#if", where)
+			}
+			stack = stack[:len(stack)-1]
+		case "include":
+			if !active {
+				lines[i] = ""
+				continue
+			}
+			included, err := b.include(filePath, arg, depth)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", where, err)
+			}
+			lines[i] = included
+			continue
+		}
+		active = len(stack) == 0 || stack[len(stack)-1].active
+	}
+	if len(stack) > 0 {
+		return "", fmt.Errorf("%s:%d: --#if without --#endif", filePath, stack[len(stack)-1].line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// evalDirective evaluates a directive condition. Conditions in branches that
+// cannot be selected are not evaluated (they may name undefined things).
+func (b *Bundler) evalDirective(expr string, reachable bool) (bool, error) {
+	if !reachable {
+		return false, nil
+	}
+	if expr == "" {
+		return false, fmt.Errorf("missing condition")
+	}
+	return lua.EvalCondition(expr, b.directiveDefines())
+}
+
+// directiveDefines is the name -> value map conditions are evaluated against:
+// env vars typed like --define values, overridden by the defines themselves.
+func (b *Bundler) directiveDefines() map[string]lua.Expr {
+	defines := make(map[string]lua.Expr, len(b.envVars)+len(b.defines))
+	for name, raw := range b.envVars {
+		if defineNameRegex.MatchString(name) {
+			defines[name] = defineValue(raw)
+		}
+	}
+	for name, value := range b.defines {
+		defines[name] = value
+	}
+	return defines
+}
+
+// include reads the file named by an --#include argument (relative to the
+// including file) and preprocesses it in turn.
+func (b *Bundler) include(filePath, arg string, depth int) (string, error) {
+	m := includePathRegex.FindStringSubmatch(arg)
+	if m == nil {
+		return "", fmt.Errorf("--#include expects a quoted path, got %q", arg)
+	}
+	if depth >= maxIncludeDepth {
+		return "", fmt.Errorf("--#include %q: nested too deeply (cycle?)", m[1])
+	}
+	path := m[1]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(filePath), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("--#include %q: %w", m[1], err)
+	}
+	if b.verbose {
+		fmt.Printf("📎 Included: %s\n", path)
+	}
+	return b.preprocessDepth(path, strings.TrimRight(string(data), "\n"), depth+1)
+}
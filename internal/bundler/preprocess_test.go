@@ -0,0 +1,82 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreprocess_Conditionals(t *testing.T) {
+	b := &Bundler{envVars: map[string]string{"TARGET": "dev"}}
+	require.NoError(t, b.SetDefines(map[string]string{"RELEASE": "false"}))
+
+	src := `a()
+--#if RELEASE
+b()
+--#elseif TARGET == "dev"
+c()
+--#if MISSING
+d()
+--#endif
+--#else
+e()
+--#endif
+f()`
+	out, err := b.preprocess("main.lua", src)
+	require.NoError(t, err)
+	assert.Contains(t, out, "a()")
+	assert.Contains(t, out, "c()")
+	assert.Contains(t, out, "f()")
+	for _, dead := range []string{"b()", "d()", "e()"} {
+		assert.NotContains(t, out, dead)
+	}
+	assert.Equal(t, 12, len(strings.Split(out, "\n")), "line numbers are preserved")
+}
+
+func TestPreprocess_Errors(t *testing.T) {
+	b := &Bundler{}
+	for _, src := range []string{
+		"--#if X\na()",
+		"--#endif",
+		"--#else",
+		"--#if X\n--#else\n--#else\n--#endif",
+		"--#if f()\n--#endif",
+		"--#include mock.lua",
+	} {
+		_, err := b.preprocess("main.lua", src)
+		assert.Error(t, err, "preprocess(%q) should fail", src)
+	}
+}
+
+func TestBundle_PreprocessorSwapsImplementation(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("mockhttp.lua", `return { get = function() return "mock" end }`)
+	write("realhttp.lua", `return { get = function() return game:HttpGet("x") end }`)
+	write("header.lua", `local VERSION = "1.0"`)
+	write("net.lua", `--#if RELEASE
+return require("./realhttp")
+--#else
+return require("./mockhttp")
+--#endif`)
+	write("main.lua", `--#include "header.lua"
+local net = require("./net")
+return net.get(), VERSION`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetEnvVars(map[string]string{"RELEASE": "true"})
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.Contains(t, mods, "realhttp")
+	assert.NotContains(t, mods, "mockhttp", "requires in a disabled branch are never discovered")
+	assert.Contains(t, out, `local VERSION = "1.0"`)
+}
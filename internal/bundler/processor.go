@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
 )
 
 // downloadHTTP downloads content from an HTTP URL, or reads a local file for file:// URLs.
@@ -73,146 +75,318 @@ func (b *Bundler) isLocalModule(modulePath string) bool {
 	// 1. Dimulai dengan "." (relatif)
 	// 2. Dimulai dengan "/" (absolut dari base)
 	// 3. Berisi "/" (subdirectory)
-	// 4. Berakhir dengan ".lua"
+	// 4. Berakhir dengan ".lua" atau ".luau"
 	// 5. Tidak berisi karakter yang mengindikasikan external module
 	return strings.HasPrefix(modulePath, ".") ||
 		strings.HasPrefix(modulePath, "/") ||
 		strings.Contains(modulePath, "/") ||
-		strings.HasSuffix(modulePath, ".lua") ||
+		hasLuaExt(modulePath) ||
 		(!strings.Contains(modulePath, ".") && !strings.Contains(modulePath, "::"))
 }
 
-// resolveModulePath resolves relative module paths to absolute paths
-func (b *Bundler) resolveModulePath(currentFile, modulePath string) string {
+// hasLuaExt reports whether path already names a Lua/Luau source file.
+func hasLuaExt(path string) bool {
+	return strings.HasSuffix(path, ".lua") || strings.HasSuffix(path, ".luau")
+}
+
+// resolveSuffixes returns the configured candidate order, or the default one
+// for a Bundler built without NewBundler.
+func (b *Bundler) resolveSuffixes() []string {
+	if len(b.resolveOrder) == 0 {
+		return DefaultResolveOrder
+	}
+	return b.resolveOrder
+}
+
+// moduleBases returns the extensionless paths a require of modulePath from
+// currentFile may refer to, in lookup order:
+//   - "@alias/x": the alias target joined with x, the alias coming from the
+//     nearest .luaurc or SetAliases (unknown aliases are an error)
+//   - "/x": x under baseDir
+//   - "./x", "../x": x relative to the requiring file
+//   - "x": relative to the requiring file, then each search root in turn
+func (b *Bundler) moduleBases(currentFile, modulePath string) ([]string, error) {
 	modulePath = strings.Trim(modulePath, "'\"")
 
-	// Handle absolute paths from base directory
+	if strings.HasPrefix(modulePath, "@") {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(modulePath, "@"), "/")
+		target, ok, err := b.lookupAlias(currentFile, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown module alias %q in require(%q) from %s", "@"+name, modulePath, currentFile)
+		}
+		return []string{filepath.Join(target, rest)}, nil
+	}
+
 	if strings.HasPrefix(modulePath, "/") {
-		resolvedPath := filepath.Join(b.baseDir, strings.TrimPrefix(modulePath, "/"))
-		if !strings.HasSuffix(resolvedPath, ".lua") {
-			resolvedPath += ".lua"
+		// Absolute path from base directory
+		return []string{filepath.Join(b.baseDir, strings.TrimPrefix(modulePath, "/"))}, nil
+	}
+
+	// Relative to the requiring file
+	bases := []string{filepath.Join(filepath.Dir(currentFile), modulePath)}
+	if !strings.HasPrefix(modulePath, ".") {
+		for _, root := range b.searchRoots {
+			bases = append(bases, filepath.Join(b.fromBase(root), modulePath))
 		}
-		return resolvedPath
 	}
+	return bases, nil
+}
+
+// fromBase makes a configured (alias or search-root) path absolute by
+// anchoring relative ones at baseDir.
+func (b *Bundler) fromBase(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(b.baseDir, path)
+}
+
+// moduleCandidates lists, in resolution order, every file path a require of
+// modulePath from currentFile may refer to. A base that already ends in
+// .lua/.luau or an asset extension (.json, .txt, ...) is taken literally;
+// otherwise each resolve-order suffix is tried.
+func (b *Bundler) moduleCandidates(currentFile, modulePath string) ([]string, error) {
+	bases, err := b.moduleBases(currentFile, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	suffixes := b.resolveSuffixes()
+	var candidates []string
+	for _, base := range bases {
+		base = filepath.Clean(base)
+		if hasLuaExt(base) || isAssetPath(base) {
+			candidates = append(candidates, base)
+			continue
+		}
+		for _, suffix := range suffixes {
+			candidates = append(candidates, filepath.Clean(base+filepath.FromSlash(suffix)))
+		}
+	}
+	return candidates, nil
+}
 
-	// Handle relative paths
-	currentDir := filepath.Dir(currentFile)
-	resolvedPath := filepath.Join(currentDir, modulePath)
+// resolveModulePath resolves a module path to the first candidate file that
+// exists on disk. When none exists it returns the first candidate, so callers
+// that only need a stable key (canonicalKey) still get one; an unresolvable
+// alias falls back to the path as written, relative to currentFile.
+func (b *Bundler) resolveModulePath(currentFile, modulePath string) string {
+	candidates, err := b.moduleCandidates(currentFile, modulePath)
+	if err != nil {
+		return filepath.Join(filepath.Dir(currentFile), strings.Trim(modulePath, "'\""))
+	}
+	for _, c := range candidates {
+		if isRegularFile(c) {
+			return c
+		}
+	}
+	return candidates[0]
+}
 
-	// Add .lua extension if not present
-	if !strings.HasSuffix(resolvedPath, ".lua") {
-		resolvedPath += ".lua"
+// findModuleFile is resolveModulePath for discovery: it fails when no
+// candidate exists, naming every path it tried.
+func (b *Bundler) findModuleFile(currentFile, modulePath string) (string, error) {
+	candidates, err := b.moduleCandidates(currentFile, modulePath)
+	if err != nil {
+		return "", err
 	}
+	for _, c := range candidates {
+		if isRegularFile(c) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("module %q required from %s not found; tried: %s",
+		strings.Trim(modulePath, "'\""), currentFile, strings.Join(candidates, ", "))
+}
 
-	// Clean the path to resolve .. and . components
-	resolvedPath = filepath.Clean(resolvedPath)
+// absPath is filepath.Abs that falls back to the cleaned input, so a .luaurc
+// alias (always absolute) and a relative baseDir still relate.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
 
-	return resolvedPath
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
 
 // canonicalKey is the EmbeddedModules key for a local module required as
 // modulePath from currentFile: the resolved file path made relative to baseDir,
-// cleaned, forward-slashed, with any trailing ".lua" removed. The same file
-// required from different callers/spellings collapses to one key.
+// cleaned, forward-slashed, with any trailing ".lua"/".luau" removed. The same
+// file required from different callers/spellings collapses to one key; a
+// directory module keeps its "/init" segment so it never collides with a
+// sibling file of the same name. Wally link modules are followed to the package
+// source they re-export, so each package version has exactly one key.
 func (b *Bundler) canonicalKey(currentFile, modulePath string) string {
-	resolved := b.resolveModulePath(currentFile, modulePath)
-	rel, err := filepath.Rel(b.baseDir, resolved)
+	return b.keyForPath(b.followLinks(b.resolveModulePath(currentFile, modulePath)))
+}
+
+// keyForPath is the canonical EmbeddedModules key of a resolved module file.
+func (b *Bundler) keyForPath(resolved string) string {
+	rel, err := filepath.Rel(absPath(b.baseDir), absPath(resolved))
 	if err != nil {
 		rel = resolved
 	}
 	rel = filepath.ToSlash(filepath.Clean(rel))
+	if strings.HasSuffix(rel, ".luau") {
+		return strings.TrimSuffix(rel, ".luau")
+	}
 	return strings.TrimSuffix(rel, ".lua")
 }
 
 // processFile recursively processes a file and its dependencies
 func (b *Bundler) processFile(filePath string, content string) error {
-	lines := strings.Split(content, "\n")
+	// Require paths and URLs that only appear in statically dead branches
+	// (e.g. `if false then require("./devtools") end`) are not embedded.
+	unreachable := map[string]bool{}
+	if chunk, err := lua.Parse(content); err == nil {
+		lua.ApplyDefines(chunk, b.defines)
+		unreachable = lua.UnreachableStrings(chunk)
+	}
 
-	for _, line := range lines {
-		// Skip if HttpGet is inside a function call (e.g., queue_on_teleport("loadstring(...)"))
-		if moduleFuncWrapHTTPGet.MatchString(line) {
-			continue
+	// Remote loads (loadstring(game:HttpGet(...))() and friends, see remote.go)
+	for _, load := range b.remoteLoads(content) {
+		if err := b.embedRemoteModule(load.url, filePath, unreachable); err != nil {
+			return err
 		}
+	}
 
-		// Check for loadstring(game:HttpGet(...))()
-		if matches := moduleHTTPGetRegex.FindStringSubmatch(line); len(matches) > 1 {
-			url := matches[1]
+	lines := strings.Split(content, "\n")
+
+	for _, line := range lines {
+		// Check for local require()
+		if matches := moduleRequireRegex.FindStringSubmatch(line); len(matches) > 1 {
+			modulePath := matches[1]
 
-			// Skip if already processed
-			if _, exists := b.modules[url]; exists {
-				continue
+			// Process local files (relative, absolute from base, or subdirectory)
+			if b.isLocalModule(modulePath) && unreachable[modulePath] {
+				b.skipUnreachable(b.canonicalKey(filePath, modulePath), filePath)
+			} else if b.isLocalModule(modulePath) {
+				resolvedPath, err := b.findModuleFile(filePath, modulePath)
+				if err != nil {
+					return err
+				}
+				if err := b.embedLocalModule(b.followLinks(resolvedPath)); err != nil {
+					return err
+				}
 			}
+		}
 
-			// Download content from URL
-			httpContent, err := b.downloadHTTP(url)
-			if err != nil {
-				return err
+		// Check for Roblox instance-path require(script.Parent.X) — only those
+		// that map onto a file on disk are embedded; the rest stay runtime requires.
+		for _, m := range scriptRequireRegex.FindAllStringSubmatch(line, -1) {
+			if resolvedPath, ok := b.resolveScriptRequire(filePath, m[1]); ok {
+				if err := b.embedLocalModule(resolvedPath); err != nil {
+					return err
+				}
 			}
+		}
+	}
 
-			// Apply env var substitution to HTTP module content
-			httpContent = substituteEnvVars(httpContent, b.envVars, b.verbose)
+	return nil
+}
 
-			// Rewrite nested HttpGet/require calls before storing, so the embedded
-			// body calls loadModule() instead of live-fetching at runtime.
-			// Pass the raw (pre-rewrite) content to processFile so the HttpGet
-			// patterns are still present for nested dependency discovery.
-			rawHTTPContent := httpContent
-			httpContent = b.rewriteModuleCalls(httpContent, url)
+// embedRemoteModule downloads the script at url, stores it as an HTTP module
+// (never obfuscated) and discovers its own dependencies. URLs already embedded
+// or only loaded from dead code in filePath are skipped.
+func (b *Bundler) embedRemoteModule(url, filePath string, unreachable map[string]bool) error {
+	// Skip if already processed
+	if _, exists := b.modules[url]; exists {
+		return nil
+	}
+	if unreachable[url] {
+		b.skipUnreachable(url, filePath)
+		return nil
+	}
 
-			// Mark as HTTP module (do not obfuscate)
-			b.httpModules[url] = true
-			b.modules[url] = httpContent
+	// Download content from URL
+	httpContent, err := b.downloadHTTP(url)
+	if err != nil {
+		return err
+	}
 
-			// Process raw downloaded content (might have nested requires/HttpGets in it)
-			if err := b.processFile(url, rawHTTPContent); err != nil {
-				return err
-			}
-		}
+	// Apply env var substitution to HTTP module content
+	httpContent, err = substituteEnvVars(httpContent, b.envVars, b.verbose, b.strictEnv)
+	if err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
 
-		// Check for local require()
-		if matches := moduleRequireRegex.FindStringSubmatch(line); len(matches) > 1 {
-			modulePath := matches[1]
+	// Rewrite nested remote loads/requires before storing, so the embedded
+	// body calls loadModule() instead of live-fetching at runtime.
+	// Pass the raw (pre-rewrite) content to processFile so the load
+	// patterns are still present for nested dependency discovery.
+	rawHTTPContent := httpContent
+	httpContent = b.rewriteModuleCalls(httpContent, url)
 
-			// Process local files (relative, absolute from base, or subdirectory)
-			if b.isLocalModule(modulePath) {
-				resolvedPath := b.resolveModulePath(filePath, modulePath)
-				key := b.canonicalKey(filePath, modulePath)
+	// Mark as HTTP module (do not obfuscate)
+	b.httpModules[url] = true
+	b.modules[url] = httpContent
 
-				// Skip if already processed (by canonical key)
-				if _, exists := b.modules[key]; exists {
-					continue
-				}
+	// Process raw downloaded content (might have nested requires/remote loads in it)
+	return b.processFile(url, rawHTTPContent)
+}
 
-				// Read local file
-				fileContent, err := os.ReadFile(resolvedPath)
-				if err != nil {
-					return fmt.Errorf("failed to read file %s: %w", resolvedPath, err)
-				}
+// skipUnreachable records that key was required only from dead code in
+// filePath and so was not embedded.
+func (b *Bundler) skipUnreachable(key, filePath string) {
+	if b.skipped == nil {
+		b.skipped = make(map[string]string)
+	}
+	if _, seen := b.skipped[key]; !seen {
+		b.skipped[key] = filePath
+		if b.verbose {
+			fmt.Printf("⚠️  Unreachable require skipped: %s (in %s)\n", key, filePath)
+		}
+	}
+}
 
-				moduleContent := string(fileContent)
+// embedLocalModule reads and rewrites the local file at resolvedPath, stores
+// it under its canonical key, then discovers its own dependencies. A file
+// whose key is already embedded is skipped.
+func (b *Bundler) embedLocalModule(resolvedPath string) error {
+	key := b.keyForPath(resolvedPath)
 
-				// Apply env var substitution before obfuscation
-				moduleContent = substituteEnvVars(moduleContent, b.envVars, b.verbose)
-				moduleContent = b.rewriteModuleCalls(moduleContent, resolvedPath)
+	// Skip if already processed (by canonical key)
+	if _, exists := b.modules[key]; exists {
+		return nil
+	}
 
-				// Obfuscate local module if obfuscation is enabled
-				if b.obfuscateLevel > 0 && b.obfuscator != nil {
-					moduleContent = b.obfuscator.Obfuscate(moduleContent)
-				}
+	if isAssetPath(resolvedPath) {
+		return b.embedAsset(resolvedPath, key)
+	}
 
-				b.modules[key] = moduleContent
+	// Read local file
+	fileContent, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", resolvedPath, err)
+	}
 
-				if b.verbose {
-					fmt.Printf("📄 Processed: %s\n", key)
-				}
+	source, err := b.preprocess(resolvedPath, string(fileContent))
+	if err != nil {
+		return err
+	}
 
-				// Process file recursively (pass raw fileContent so nested requires remain intact)
-				if err := b.processFile(resolvedPath, string(fileContent)); err != nil {
-					return err
-				}
-			}
-		}
+	// Apply env var substitution before obfuscation, and before dependency
+	// discovery so branches on placeholders are seen as dead
+	source, err = substituteEnvVars(source, b.envVars, b.verbose, b.strictEnv)
+	if err != nil {
+		return fmt.Errorf("%s: %w", resolvedPath, err)
 	}
+	moduleContent := b.rewriteModuleCalls(source, resolvedPath)
+	moduleContent = b.applyDefines(moduleContent, key)
 
-	return nil
+	// Obfuscation runs later, once every module is known (see Bundle).
+	b.modules[key] = moduleContent
+
+	if b.verbose {
+		fmt.Printf("📄 Processed: %s\n", key)
+	}
+
+	// Process file recursively (pass the un-rewritten source so nested requires remain intact)
+	return b.processFile(resolvedPath, source)
 }
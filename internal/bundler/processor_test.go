@@ -185,6 +185,71 @@ func TestProcessFile_KeysByCanonical(t *testing.T) {
 	}
 }
 
+func TestResolveModulePath_DirectoryAndLuau(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "ui"), 0o755))
+	for _, p := range []string{"main.lua", "ui/init.lua", "net.luau"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte("return {}"), 0o644))
+	}
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+
+	entry := filepath.Join(dir, "main.lua")
+	assert.Equal(t, filepath.Join(dir, "ui/init.lua"), b.resolveModulePath(entry, "./ui"))
+	assert.Equal(t, filepath.Join(dir, "net.luau"), b.resolveModulePath(entry, "./net"))
+	assert.Equal(t, "ui/init", b.canonicalKey(entry, "./ui"))
+	assert.Equal(t, "ui/init", b.canonicalKey(entry, "./ui/init"), "both spellings share one key")
+	assert.Equal(t, "net", b.canonicalKey(entry, "./net"))
+
+	// A custom order that prefers .luau wins over a sibling .lua file.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "net.lua"), []byte("return {}"), 0o644))
+	assert.Equal(t, filepath.Join(dir, "net.lua"), b.resolveModulePath(entry, "./net"))
+	b.SetResolveOrder([]string{".luau", ".lua"})
+	assert.Equal(t, filepath.Join(dir, "net.luau"), b.resolveModulePath(entry, "./net"))
+}
+
+func TestProcessFile_MissingModuleListsCandidates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"),
+		[]byte(`local M = require("./missing")`), 0o644))
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	_, err = b.Bundle(false)
+	require.Error(t, err)
+	for _, suffix := range DefaultResolveOrder {
+		assert.Contains(t, err.Error(), filepath.Join(dir, "missing"+filepath.FromSlash(suffix)))
+	}
+}
+
+func TestResolveModulePath_AliasesAndSearchRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"main.lua", "src/shared/net.lua", "vendor/json.lua", "game/deep/ui.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte("return {}"), 0o644))
+	}
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetAliases(map[string]string{"@shared": "src/shared", "vendor": filepath.Join(dir, "vendor")})
+	b.SetSearchRoots([]string{"vendor"})
+
+	deep := filepath.Join(dir, "game/deep/ui.lua")
+	assert.Equal(t, filepath.Join(dir, "src/shared/net.lua"), b.resolveModulePath(deep, "@shared/net"))
+	assert.Equal(t, "src/shared/net", b.canonicalKey(deep, "@shared/net"))
+	assert.Equal(t, b.canonicalKey(deep, "../../src/shared/net"), b.canonicalKey(deep, "@shared/net"),
+		"alias and relative spellings must share one key")
+	assert.Equal(t, "vendor/json", b.canonicalKey(deep, "@vendor/json"))
+	assert.Equal(t, "vendor/json", b.canonicalKey(deep, "json"), "bare path falls back to search roots")
+
+	_, err = b.findModuleFile(deep, "@missing/x")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown module alias "@missing"`)
+
+	// "./" paths never consult search roots.
+	_, err = b.findModuleFile(deep, "./json")
+	require.Error(t, err)
+}
+
 func keysOf(m map[string]string) []string {
 	var k []string
 	for key := range m {
@@ -192,3 +257,54 @@ func keysOf(m map[string]string) []string {
 	}
 	return k
 }
+
+func TestProcessFile_SkipsUnreachableRequires(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("devtools.lua", `return require("./heavy")`)
+	write("heavy.lua", `return {}`)
+	write("shared.lua", `return {}`)
+	write("main.lua", `if {{DEBUG}} then
+	local dev = require("./devtools")
+	dev.attach()
+end
+if false then require("./shared") end
+local S = require("./shared")
+return S`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetEnvVars(map[string]string{"DEBUG": "false"})
+	_, err = b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.NotContains(t, mods, "devtools", "module required only from a dead branch must not be embedded")
+	assert.NotContains(t, mods, "heavy", "its dependencies are never discovered")
+	assert.Contains(t, mods, "shared", "a module also required from live code is embedded")
+	assert.Equal(t, []string{"devtools"}, b.GetSkippedModules())
+}
+
+func TestProcessFile_SkipsUnreachableRequiresInModules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("devtools.lua", `return {}`)
+	write("mid.lua", `if {{DEBUG}} then require("./devtools") end
+return {}`)
+	write("main.lua", `return require("./mid")`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetEnvVars(map[string]string{"DEBUG": "false"})
+	_, err = b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.Contains(t, mods, "mid")
+	assert.NotContains(t, mods, "devtools", "placeholders in nested modules are substituted before dead branches are found")
+	assert.Equal(t, []string{"devtools"}, b.GetSkippedModules())
+}
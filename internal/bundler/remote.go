@@ -0,0 +1,211 @@
+package bundler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// remoteURLArg matches the URL argument of a remote load: a string literal
+// (group 1) or the name of a local string constant (group 2).
+const remoteURLArg = `\s*(?:["']([^"']+)["']|([A-Za-z_]\w*))\s*`
+
+// builtinRemoteLoaders are the executor idioms that load a remote script.
+// Each pattern spans a `loadstring(<fetch>)` expression, which evaluates to
+// the compiled chunk; see remoteLoads for how it is rewritten.
+var builtinRemoteLoaders = []struct{ name, pattern string }{
+	{"HttpGet", `loadstring\s*\(\s*game\s*:\s*HttpGet\s*\(` + remoteURLArg + `(?:,\s*(?:true|false)\s*)?\)\s*\)`},
+	{"HttpGetAsync", `loadstring\s*\(\s*game\s*:\s*HttpGetAsync\s*\(` + remoteURLArg + `(?:,\s*(?:true|false)\s*)?\)\s*\)`},
+	{"request", `loadstring\s*\(\s*(?:request|http_request|syn\s*\.\s*request|http\s*\.\s*request|fluxus\s*\.\s*request)\s*\(\s*\{[^{}]*?\bUrl\s*=` + remoteURLArg + `(?:[,;][^{}]*)?\}\s*\)\s*\.\s*Body\s*\)`},
+	{"GetAsync", `loadstring\s*\(\s*(?:game\s*:\s*GetService\s*\(\s*["']HttpService["']\s*\)|[A-Za-z_][\w.]*)\s*:\s*GetAsync\s*\(` + remoteURLArg + `(?:,[^()]*)?\)\s*\)`},
+}
+
+// immediateCallRegex matches the `()` that runs a loaded chunk right away.
+var immediateCallRegex = regexp.MustCompile(`^\s*\(\s*\)`)
+
+// DefaultRemoteLoaders names every built-in remote-load idiom.
+var DefaultRemoteLoaders = func() []string {
+	names := make([]string, len(builtinRemoteLoaders))
+	for i, l := range builtinRemoteLoaders {
+		names[i] = l.name
+	}
+	return names
+}()
+
+// remoteLoader is one recognized remote-load pattern. A custom pattern spans
+// an expression evaluating to the module's value; a built-in one spans the
+// loadstring(...) call, which evaluates to the chunk.
+type remoteLoader struct {
+	name    string
+	regex   *regexp.Regexp
+	builtin bool
+}
+
+// remoteLoad is a remote load found in a file. replacement is the embedded
+// equivalent of content[start:end].
+type remoteLoad struct {
+	start, end  int
+	url         string
+	replacement string
+}
+
+// SetRemoteLoaders selects the built-in remote-load idioms to embed, by name
+// (see DefaultRemoteLoaders), and adds custom patterns: regular expressions
+// spanning the whole load expression with exactly one capture group, the URL.
+// With no names and no patterns every built-in idiom is used.
+func (b *Bundler) SetRemoteLoaders(names, patterns []string) error {
+	if len(names) == 0 && len(patterns) == 0 {
+		b.remoteLoaders = nil
+		return nil
+	}
+	loaders := make([]remoteLoader, 0, len(names)+len(patterns))
+	for _, name := range names {
+		found := false
+		for _, l := range builtinRemoteLoaders {
+			if strings.EqualFold(l.name, name) {
+				loaders = append(loaders, remoteLoader{l.name, regexp.MustCompile(l.pattern), true})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown remote loader %q (known: %s)", name, strings.Join(DefaultRemoteLoaders, ", "))
+		}
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid remote pattern %q: %w", pattern, err)
+		}
+		if re.NumSubexp() != 1 {
+			return fmt.Errorf("remote pattern %q must have exactly one capture group (the URL)", pattern)
+		}
+		loaders = append(loaders, remoteLoader{pattern, re, false})
+	}
+	b.remoteLoaders = loaders
+	return nil
+}
+
+// activeRemoteLoaders returns the configured loaders, or every built-in one.
+func (b *Bundler) activeRemoteLoaders() []remoteLoader {
+	if b.remoteLoaders != nil {
+		return b.remoteLoaders
+	}
+	loaders := make([]remoteLoader, len(builtinRemoteLoaders))
+	for i, l := range builtinRemoteLoaders {
+		loaders[i] = remoteLoader{l.name, regexp.MustCompile(l.pattern), true}
+	}
+	b.remoteLoaders = loaders
+	return loaders
+}
+
+// remoteLoads returns the non-overlapping remote loads in content, in order,
+// wherever they are evaluated as code, including as arguments: both
+// setup(loadstring(game:HttpGet(url))()) and
+// queue_on_teleport(loadstring(...)()) run the load now. Matches inside
+// string literals and comments are source text run later, such as the
+// argument of queue_on_teleport("loadstring(...)()"), and are left alone. A
+// URL given as a name is looked up in the file's string constants (see
+// lua.StringConstants); loads whose URL cannot be determined are skipped.
+//
+// A built-in load called immediately with no arguments is replaced by
+// loadModule(url); any other use of the chunk (pcall(loadstring(...)), a
+// stored function) by a function returning loadModule(url).
+func (b *Bundler) remoteLoads(content string) []remoteLoad {
+	var consts map[string]string
+	var loads []remoteLoad
+	for _, l := range b.activeRemoteLoaders() {
+		for _, m := range l.regex.FindAllStringSubmatchIndex(content, -1) {
+			code, called := loadContext(content, m[0], m[1])
+			if !code {
+				continue
+			}
+			url := ""
+			if m[2] >= 0 {
+				url = content[m[2]:m[3]]
+			}
+			if len(m) > 5 && m[4] >= 0 {
+				if consts == nil {
+					consts = stringConstants(content)
+				}
+				url = consts[content[m[4]:m[5]]]
+			}
+			end := m[1]
+			replacement := fmt.Sprintf("loadModule(\"%s\")", escapeString(url))
+			if l.builtin {
+				if call := immediateCallRegex.FindString(content[end:]); call != "" && called {
+					end += len(call)
+				} else {
+					replacement = "(function() return " + replacement + " end)"
+				}
+			}
+			if url == "" || overlaps(loads, m[0], end) {
+				continue
+			}
+			loads = append(loads, remoteLoad{m[0], end, url, replacement})
+		}
+	}
+	sort.Slice(loads, func(i, j int) bool { return loads[i].start < loads[j].start })
+	return loads
+}
+
+// remoteLoadPlaceholder stands in for a matched load while loadContext parses
+// the file around it.
+const remoteLoadPlaceholder = "__lb_remote_load"
+
+// loadContext classifies content[start:end], a matched load expression, on
+// the AST: with the match replaced by a placeholder name, code reports
+// whether the name is an expression of the parsed file (rather than text in
+// a string literal or comment), and called whether it is called right away
+// with no arguments. Content that does not parse is classified by its tokens
+// instead; the match is code if it is not inside a string or comment.
+func loadContext(content string, start, end int) (code, called bool) {
+	placeholder := remoteLoadPlaceholder
+	for strings.Contains(content, placeholder) {
+		placeholder += "_"
+	}
+	chunk, err := lua.Parse(content[:start] + placeholder + content[end:])
+	if err != nil {
+		for _, r := range lua.TextRanges(content) {
+			if r[0] <= start && start < r[1] {
+				return false, false
+			}
+		}
+		return true, immediateCallRegex.MatchString(content[end:])
+	}
+	lua.Inspect(chunk, func(n lua.Node) bool {
+		switch v := n.(type) {
+		case *lua.CallExpr:
+			if fn, ok := v.Fn.(*lua.NameExpr); ok && fn.Name == placeholder && len(v.Args) == 0 {
+				called = true
+			}
+		case *lua.NameExpr:
+			if v.Name == placeholder {
+				code = true
+			}
+		}
+		return true
+	})
+	return code, called
+}
+
+func overlaps(loads []remoteLoad, start, end int) bool {
+	for _, l := range loads {
+		if start < l.end && l.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// stringConstants is lua.StringConstants for source that may not parse.
+func stringConstants(content string) map[string]string {
+	chunk, err := lua.Parse(content)
+	if err != nil {
+		return nil
+	}
+	return lua.StringConstants(chunk)
+}
@@ -0,0 +1,106 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteModuleCalls_RemoteIdioms(t *testing.T) {
+	const want = `local lib = loadModule("https://x.dev/lib.lua")`
+	for _, src := range []string{
+		`local lib = loadstring(game:HttpGet("https://x.dev/lib.lua"))()`,
+		`local lib = loadstring(game:HttpGet("https://x.dev/lib.lua", true))()`,
+		`local lib = loadstring(game:HttpGetAsync('https://x.dev/lib.lua'))()`,
+		`local lib = loadstring(request({Url = "https://x.dev/lib.lua", Method = "GET"}).Body)()`,
+		`local lib = loadstring(syn.request({ Method = "GET", Url = "https://x.dev/lib.lua" }).Body)()`,
+		`local lib = loadstring(game:GetService("HttpService"):GetAsync("https://x.dev/lib.lua"))()`,
+		`local lib = loadstring(HttpService:GetAsync("https://x.dev/lib.lua"))()`,
+	} {
+		b := &Bundler{modules: map[string]string{}, httpModules: map[string]bool{}, baseDir: "/tmp"}
+		assert.Equal(t, want, b.rewriteModuleCalls(src, "/tmp/main.lua"), src)
+	}
+}
+
+func TestRewriteModuleCalls_RemoteURLConstant(t *testing.T) {
+	b := &Bundler{modules: map[string]string{}, httpModules: map[string]bool{}, baseDir: "/tmp"}
+	src := "local LIB_URL = \"https://x.dev/lib.lua\"\nlocal lib = loadstring(game:HttpGet(LIB_URL))()"
+	out := b.rewriteModuleCalls(src, "/tmp/main.lua")
+	assert.Contains(t, out, `local lib = loadModule("https://x.dev/lib.lua")`)
+
+	src = "local u = \"https://x.dev/a.lua\"\nu = pick()\nlocal lib = loadstring(game:HttpGet(u))()"
+	assert.Equal(t, src, b.rewriteModuleCalls(src, "/tmp/main.lua"), "a reassigned local is not a constant")
+}
+
+func TestSetRemoteLoaders(t *testing.T) {
+	b := &Bundler{modules: map[string]string{}, httpModules: map[string]bool{}, baseDir: "/tmp"}
+	require.NoError(t, b.SetRemoteLoaders([]string{"httpget"}, []string{`fetchScript\(\s*"([^"]+)"\s*\)`}))
+
+	out := b.rewriteModuleCalls(`a = fetchScript("https://x.dev/a.lua") b = loadstring(game:HttpGetAsync("https://x.dev/b.lua"))()`, "/tmp/main.lua")
+	assert.Contains(t, out, `a = loadModule("https://x.dev/a.lua")`)
+	assert.Contains(t, out, "HttpGetAsync", "idioms not selected stay runtime loads")
+
+	assert.Error(t, b.SetRemoteLoaders([]string{"curl"}, nil))
+	assert.Error(t, b.SetRemoteLoaders(nil, []string{`fetch\((.*)\)\((.*)\)`}), "exactly one capture group")
+	assert.Error(t, b.SetRemoteLoaders(nil, []string{`(`}))
+}
+
+func TestBundle_EmbedsRemoteIdioms(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "remote", "lib.lua")
+	require.NoError(t, os.MkdirAll(filepath.Dir(lib), 0o755))
+	require.NoError(t, os.WriteFile(lib, []byte(`return { ok = true }`), 0o644))
+	main := filepath.Join(dir, "main.lua")
+	require.NoError(t, os.WriteFile(main, []byte(`local LIB = "file://`+filepath.ToSlash(lib)+`"
+local lib = loadstring(request({Url = LIB}).Body)()
+return lib.ok`), 0o644))
+
+	b, err := NewBundler(main, false, false)
+	require.NoError(t, err)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	url := "file://" + filepath.ToSlash(lib)
+	assert.Contains(t, b.GetModules(), url)
+	assert.Contains(t, out, `loadModule("`+url+`")`)
+	assert.NotContains(t, out, "request(")
+}
+
+func TestRewriteModuleCalls_NestedRemoteLoads(t *testing.T) {
+	b := &Bundler{modules: map[string]string{}, httpModules: map[string]bool{}, baseDir: "/tmp"}
+
+	src := `setup(loadstring(game:HttpGet("https://x.dev/a.lua"))(), 1)`
+	assert.Equal(t, `setup(loadModule("https://x.dev/a.lua"), 1)`, b.rewriteModuleCalls(src, "/tmp/main.lua"))
+
+	src = `local ok = pcall(loadstring(game:HttpGet("https://x.dev/a.lua")))`
+	assert.Equal(t, `local ok = pcall((function() return loadModule("https://x.dev/a.lua") end))`, b.rewriteModuleCalls(src, "/tmp/main.lua"))
+
+	src = "local a = loadstring(game:HttpGet(\"https://x.dev/a.lua\"))(\n)"
+	assert.Equal(t, `local a = loadModule("https://x.dev/a.lua")`, b.rewriteModuleCalls(src, "/tmp/main.lua"), "a call may span lines")
+}
+
+func TestRewriteModuleCalls_RemoteLoadsAsSourceText(t *testing.T) {
+	b := &Bundler{modules: map[string]string{}, httpModules: map[string]bool{}, baseDir: "/tmp"}
+	for _, src := range []string{
+		`task.spawn("loadstring(game:HttpGet('https://x.dev/a.lua'))()")`,
+		`local s = [[loadstring(game:HttpGet("https://x.dev/a.lua"))()]]`,
+		`-- local a = loadstring(game:HttpGet("https://x.dev/a.lua"))()`,
+		`syn.queue_on_teleport("loadstring(game:HttpGet('https://x.dev/a.lua'))()")`,
+		"print(`loadstring(game:HttpGet(\"https://x.dev/a.lua\"))()`)",
+	} {
+		assert.Equal(t, src, b.rewriteModuleCalls(src, "/tmp/main.lua"), src)
+	}
+
+	// Loads evaluated as code run now, even in a runtime API's arguments.
+	cases := map[string]string{
+		`queue_on_teleport("x") local a = loadstring(game:HttpGet("https://x.dev/a.lua"))()`: `local a = loadModule("https://x.dev/a.lua")`,
+		`syn.queue_on_teleport(loadstring(game:HttpGet("https://x.dev/a.lua"))())`:           `syn.queue_on_teleport(loadModule("https://x.dev/a.lua"))`,
+		`queue_on_teleport(loadstring(game:HttpGet("https://x.dev/a.lua")))`:                 `queue_on_teleport((function() return loadModule("https://x.dev/a.lua") end))`,
+	}
+	for src, want := range cases {
+		assert.Contains(t, b.rewriteModuleCalls(src, "/tmp/main.lua"), want, src)
+	}
+}
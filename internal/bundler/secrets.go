@@ -0,0 +1,238 @@
+package bundler
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// Secret scan modes (see SetSecretScan).
+const (
+	SecretScanOff    = "off"
+	SecretScanWarn   = "warn"
+	SecretScanFail   = "fail"
+	SecretScanRedact = "redact"
+)
+
+// DefaultSecretVars are the env var name patterns whose values are treated
+// as secrets unless SetSecretVars configures others.
+var DefaultSecretVars = []string{"*TOKEN*", "*SECRET*", "*PASSWORD*", "*PRIVATE_KEY*"}
+
+// redactedValue replaces a secret in redact mode.
+const redactedValue = "[REDACTED]"
+
+// minSecretValueLen keeps short env values ("1", "true") from matching
+// everywhere in the source.
+const minSecretValueLen = 8
+
+// secretPatterns are well-known credential formats.
+var secretPatterns = []struct {
+	kind  string
+	regex *regexp.Regexp
+}{
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"Discord webhook", regexp.MustCompile(`https://(?:ptb\.|canary\.)?discord(?:app)?\.com/api/webhooks/\d+/[A-Za-z0-9_-]+`)},
+	{"Discord bot token", regexp.MustCompile(`\b[MN][A-Za-z0-9]{23,25}\.[A-Za-z0-9_-]{6}\.[A-Za-z0-9_-]{27,}\b`)},
+	{"OpenAI API key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{32,}\b`)},
+	{"Private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+}
+
+// SecretFinding is one secret found in a module.
+type SecretFinding struct {
+	Module   string // module key, URL, or "main"
+	Line     int    // 1-based line in the module as bundled
+	Kind     string // token format, or "env <NAME>" for a secret env var value
+	Masked   string // the value with all but its first characters hidden
+	Redacted bool   // replaced in the bundle (redact mode, string literals only)
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Module, f.Line, f.Kind, f.Masked)
+}
+
+// SetSecretScan sets what happens when the bundle contains a secret: "off",
+// "warn" (report only), "fail" (Bundle returns an error) or "redact" (the
+// value is replaced with [REDACTED] inside string literals; Bundle returns an
+// error for a secret elsewhere, which cannot be removed without breaking the
+// code). An empty mode restores the default, warn.
+func (b *Bundler) SetSecretScan(mode string) error {
+	if mode == "" {
+		mode = SecretScanWarn
+	}
+	switch mode {
+	case SecretScanOff, SecretScanWarn, SecretScanFail, SecretScanRedact:
+		b.secretScan = mode
+		return nil
+	}
+	return fmt.Errorf("invalid secret scan mode %q (want off, warn, fail or redact)", mode)
+}
+
+// SetSecretVars sets the glob patterns (e.g. "*_TOKEN") naming env vars whose
+// values must never appear in the bundle. An empty list restores
+// DefaultSecretVars.
+func (b *Bundler) SetSecretVars(patterns []string) {
+	b.secretVars = patterns
+}
+
+// GetSecretFindings returns the secrets found while bundling, by module and line.
+func (b *Bundler) GetSecretFindings() []SecretFinding {
+	return b.secretFindings
+}
+
+// scanBundle scans everything the bundle embeds: the entry and every module,
+// remote modules and assets included, in their final form after rewriting,
+// defines, tree shaking and mangling. It runs just before obfuscation, which
+// would hide string values from the scan without taking them out of the
+// bundle. In redact mode the modules are updated in place and the returned
+// entry is redacted too.
+func (b *Bundler) scanBundle(mainContent string) string {
+	for _, key := range slices.Sorted(maps.Keys(b.modules)) {
+		b.modules[key] = b.scanSecrets(key, b.modules[key])
+	}
+	return b.scanSecrets("main", mainContent)
+}
+
+// scanSecrets records every known token format and secret env var value in
+// content, one unit of the bundle, and, in redact mode, returns content with
+// those inside string literals replaced.
+func (b *Bundler) scanSecrets(module, content string) string {
+	if b.secretScan == "" || b.secretScan == SecretScanOff {
+		return content
+	}
+	type hit struct {
+		start, end int
+		kind       string
+	}
+	var hits []hit
+	for _, p := range secretPatterns {
+		for _, loc := range p.regex.FindAllStringIndex(content, -1) {
+			hits = append(hits, hit{loc[0], loc[1], p.kind})
+		}
+	}
+	for _, name := range b.secretVarNames() {
+		value := b.envVars[name]
+		for offset := 0; ; {
+			i := strings.Index(content[offset:], value)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			hits = append(hits, hit{start, start + len(value), "env " + name})
+			offset = start + len(value)
+		}
+	}
+	if len(hits) == 0 {
+		return content
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].start != hits[j].start {
+			return hits[i].start < hits[j].start
+		}
+		return hits[i].end > hits[j].end
+	})
+
+	var out strings.Builder
+	last := 0
+	strs := lua.StringRanges(content)
+	for _, h := range hits {
+		if h.start < last {
+			continue // overlaps a value already reported (e.g. an env var holding a GitHub token)
+		}
+		f := SecretFinding{
+			Module: module,
+			Line:   strings.Count(content[:h.start], "\n") + 1,
+			Kind:   h.kind,
+			Masked: MaskSecret(content[h.start:h.end]),
+		}
+		out.WriteString(content[last:h.start])
+		if replacement, ok := redaction(content, strs, h.start, h.end); ok && b.secretScan == SecretScanRedact {
+			out.WriteString(replacement)
+			f.Redacted = true
+		} else {
+			out.WriteString(content[h.start:h.end])
+		}
+		b.secretFindings = append(b.secretFindings, f)
+		last = h.end
+	}
+	out.WriteString(content[last:])
+
+	if b.verbose {
+		fmt.Printf("🔐 Secret(s) found in %s\n", module)
+	}
+	return out.String()
+}
+
+// redaction returns what replaces content[start:end] in redact mode, if it
+// lies within the body of one of the string literals strs. Long strings get
+// the value without brackets, which could otherwise close them early.
+func redaction(content string, strs [][2]int, start, end int) (string, bool) {
+	i := sort.Search(len(strs), func(i int) bool { return strs[i][1] > start })
+	if i == len(strs) || strs[i][0] >= start || strs[i][1] <= end {
+		return "", false
+	}
+	if content[strs[i][0]] == '[' {
+		return strings.Trim(redactedValue, "[]"), true
+	}
+	return redactedValue, true
+}
+
+// secretVarNames lists the env vars matching the secret patterns whose values
+// are long enough to scan for.
+func (b *Bundler) secretVarNames() []string {
+	var names []string
+	for name, value := range b.envVars {
+		if len(value) >= minSecretValueLen && IsSecretVar(name, b.secretVars) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsSecretVar reports whether the env var name matches one of patterns, or
+// of DefaultSecretVars if patterns is empty.
+func IsSecretVar(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		patterns = DefaultSecretVars
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskSecret keeps the first 4 characters of a secret for identification.
+func MaskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", min(len(s)-4, 8))
+}
+
+// secretScanError summarizes the findings for fail mode, and those redact
+// mode could not remove.
+func (b *Bundler) secretScanError() error {
+	var lines []string
+	for _, f := range b.secretFindings {
+		if b.secretScan == SecretScanFail || b.secretScan == SecretScanRedact && !f.Redacted {
+			lines = append(lines, "  "+f.String())
+		}
+	}
+	switch {
+	case len(lines) == 0:
+		return nil
+	case b.secretScan == SecretScanRedact:
+		return fmt.Errorf("secret scan found %d secret(s) outside string literals, which cannot be redacted:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	return fmt.Errorf("secret scan found %d secret(s) in the bundle:\n%s", len(lines), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,81 @@
+package bundler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// loadModuleKeyRegex finds literal loadModule("key") calls in a unit that
+// could not be parsed, so its modules can still be marked fully used.
+var loadModuleKeyRegex = regexp.MustCompile(`loadModule\s*\(\s*"((?:[^"\\]|\\.)*)"\s*\)`)
+
+// ShakeResult describes what tree shaking removed from one module.
+type ShakeResult struct {
+	Module     string   // canonical module key
+	Removed    []string // dropped exports, and "local f" for dropped local functions
+	BytesSaved int      // size difference of the printed module body
+}
+
+// shakeModules drops exports that no consumer (the entry or another module)
+// reads, for local modules that return a table. Modules whose result escapes,
+// that are never loaded by a literal key, or that fail to parse are left
+// untouched. A non-literal loadModule key anywhere disables the pass.
+func (b *Bundler) shakeModules(mainContent string) {
+	usage := map[string]*lua.ExportUsage{}
+	units := []string{mainContent}
+	for _, content := range b.modules {
+		units = append(units, content)
+	}
+	for _, content := range units {
+		chunk, err := lua.Parse(content)
+		if err != nil {
+			for _, m := range loadModuleKeyRegex.FindAllStringSubmatch(content, -1) {
+				usage[m[1]] = &lua.ExportUsage{All: true}
+			}
+			continue
+		}
+		if !lua.CollectExportUsage(chunk, usage) {
+			if b.verbose {
+				fmt.Println("🌳 Tree shaking skipped: loadModule called with a non-literal key")
+			}
+			return
+		}
+	}
+
+	keys := make([]string, 0, len(b.modules))
+	for key := range b.modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		u := usage[key]
+		if b.httpModules[key] || u == nil || u.All {
+			continue
+		}
+		chunk, err := lua.Parse(b.modules[key])
+		if err != nil {
+			continue
+		}
+		before := len(chunk.Print())
+		removed := lua.ShakeExports(chunk, u.Fields)
+		if len(removed) == 0 {
+			continue
+		}
+		after := chunk.Print()
+		b.modules[key] = after
+		b.shakeReport = append(b.shakeReport, ShakeResult{Module: key, Removed: removed, BytesSaved: before - len(after)})
+		if b.verbose {
+			fmt.Printf("🌳 Shook %s: removed %v (%d bytes)\n", key, removed, before-len(after))
+		}
+	}
+}
+
+// GetShakeReport returns what tree shaking removed, one entry per changed
+// module in key order. It is empty unless SetTreeShake(true) was used.
+func (b *Bundler) GetShakeReport() []ShakeResult {
+	return b.shakeReport
+}
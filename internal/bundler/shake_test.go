@@ -0,0 +1,60 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle_TreeShake(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p, s string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("util.lua", `local M = {}
+local function pad(s) return " " .. s end
+function M.greet(name) return "hi " .. name end
+function M.shout(name) return pad(name):upper() end
+return M`)
+	write("other.lua", `local U = require("./util")
+return { run = function() return U.greet("other") end }`)
+	write("dyn.lua", `return { a = 1, b = 2 }`)
+	write("main.lua", `local U = require("./util")
+local O = require("./other")
+local D = require("./dyn")
+for k, v in pairs(D) do print(k, v) end
+print(U.greet("main"), O.run())`)
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	b.SetTreeShake(true)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	assert.NotContains(t, out, "shout", "export read by no consumer must be dropped")
+	assert.NotContains(t, out, "pad", "local reachable only from a dropped export must be dropped")
+	assert.Contains(t, out, "M.greet", "export read by the entry and another module must stay")
+	assert.Contains(t, out, "{ a = 1, b = 2 }", "module whose result escapes (pairs) must be kept whole")
+
+	report := b.GetShakeReport()
+	require.Len(t, report, 1)
+	assert.Equal(t, "util", report[0].Module)
+	assert.Equal(t, []string{"local pad", "shout"}, report[0].Removed)
+	assert.Positive(t, report[0].BytesSaved)
+}
+
+func TestBundle_TreeShakeOffByDefault(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "m.lua"), []byte(`return { a = 1, b = 2 }`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`print(require("./m").a)`), 0o644))
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+	assert.Contains(t, out, "b = 2")
+	assert.Empty(t, b.GetShakeReport())
+}
@@ -1,13 +1,61 @@
 package bundler
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/alfin-efendy/lua-bundler/internal/lua"
 )
 
-// removeDebugStatements removes print() and warn() statements for release mode
+// DefaultStripCalls are the callees whose call statements release mode removes
+// unless SetStripCalls configures others.
+var DefaultStripCalls = []string{"print", "warn"}
+
+// stripReleaseCalls removes call statements to the configured callees (see
+// lua.StripCalls) from the bundle. Output that does not parse falls back to
+// the line-based removeDebugStatements, which only knows print and warn.
+func (b *Bundler) stripReleaseCalls(content string) string {
+	callees := b.releaseCallees()
+	chunk, err := lua.Parse(content)
+	if err != nil {
+		if b.verbose {
+			fmt.Printf("⚠️  Bundle did not parse (%v); falling back to line-based print/warn removal\n", err)
+		}
+		return removeDebugStatements(content)
+	}
+	removed := lua.StripCalls(chunk, callees)
+	if b.verbose {
+		fmt.Printf("  - Removed %d call(s) to %s\n", removed, strings.Join(callees, ", "))
+	}
+	return chunk.Print()
+}
+
+// stripUnitCalls removes the release callees' call statements from one unit,
+// leaving a unit that does not parse as it is.
+func (b *Bundler) stripUnitCalls(content string) string {
+	chunk, err := lua.Parse(content)
+	if err != nil {
+		return content
+	}
+	lua.StripCalls(chunk, b.releaseCallees())
+	return chunk.Print()
+}
+
+// releaseCallees returns the callees release mode removes calls to.
+func (b *Bundler) releaseCallees() []string {
+	callees := b.stripCalls
+	if len(callees) == 0 {
+		callees = DefaultStripCalls
+	}
+	if b.stripAsserts {
+		callees = append(append([]string(nil), callees...), "assert")
+	}
+	return callees
+}
+
+// removeDebugStatements removes print() and warn() statements line by line.
+// It is the fallback for bundles the Lua parser cannot handle.
 func removeDebugStatements(content string) string {
 	lines := strings.Split(content, "\n")
 	var result []string
@@ -0,0 +1,131 @@
+package bundler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
+)
+
+// instanceStepRegex matches one step of a Roblox instance path after `script`:
+// .Name, ["Name"], or :WaitForChild("Name") / :FindFirstChild("Name").
+var instanceStepRegex = regexp.MustCompile(`\.\s*([A-Za-z_]\w*)|\[\s*(?:"([^"]*)"|'([^']*)')\s*\]|:\s*(?:WaitForChild|FindFirstChild)\s*\(\s*(?:"([^"]*)"|'([^']*)')\s*\)`)
+
+// linkModuleRegex matches a module whose whole body re-exports another module
+// by instance path — the shape of every Wally-generated link file:
+//
+//	return require(script.Parent._Index["roblox_roact@1.4.4"]["roact"])
+var linkModuleRegex = regexp.MustCompile(`^return ` + scriptRequirePattern + `;?$`)
+
+// maxLinkHops bounds link following so a cycle of link files cannot hang the build.
+const maxLinkHops = 8
+
+// resolveScriptRequire maps require(<expr>), where expr is a `script...`
+// instance path written in currentFile, onto the module file it names under
+// Rojo's filesystem conventions, following Wally link modules to the package
+// source. ok is false when currentFile is remote or the path has no file on disk.
+func (b *Bundler) resolveScriptRequire(currentFile, expr string) (string, bool) {
+	resolved, ok := b.resolveInstancePath(currentFile, expr)
+	if !ok {
+		return "", false
+	}
+	return b.followLinks(resolved), true
+}
+
+// resolveInstancePath walks expr's steps from currentFile's instance: .Parent
+// moves to the containing directory, any other name descends into it.
+func (b *Bundler) resolveInstancePath(currentFile, expr string) (string, bool) {
+	if strings.Contains(currentFile, "://") || !strings.HasPrefix(expr, "script") {
+		return "", false
+	}
+	node := instanceOf(currentFile)
+	for _, step := range instanceStepRegex.FindAllStringSubmatch(strings.TrimPrefix(expr, "script"), -1) {
+		name := firstNonEmpty(step[1:])
+		if name == "Parent" && (step[1] != "" || step[2] != "" || step[3] != "") {
+			node = filepath.Dir(node)
+			continue
+		}
+		node = filepath.Join(node, name)
+	}
+	return b.resolveInstance(node, 0)
+}
+
+// instanceOf is the extensionless instance path of a module file: Rojo turns
+// dir/init.lua into the instance "dir" and dir/x.lua into "dir/x".
+func instanceOf(file string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(file, ".luau"), ".lua")
+	if filepath.Base(base) == "init" {
+		return filepath.Dir(base)
+	}
+	return base
+}
+
+// resolveInstance finds the module file backing the instance at node: a file
+// or init module per the resolve order, or — for a Wally package folder — the
+// $path of its default.project.json tree.
+func (b *Bundler) resolveInstance(node string, depth int) (string, bool) {
+	if depth > maxLinkHops {
+		return "", false
+	}
+	for _, suffix := range b.resolveSuffixes() {
+		if candidate := node + filepath.FromSlash(suffix); isRegularFile(candidate) {
+			return candidate, true
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(node, "default.project.json"))
+	if err != nil {
+		return "", false
+	}
+	var project struct {
+		Tree struct {
+			Path string `json:"$path"`
+		} `json:"tree"`
+	}
+	if err := json.Unmarshal(data, &project); err != nil || project.Tree.Path == "" {
+		return "", false
+	}
+	target := filepath.Join(node, filepath.FromSlash(project.Tree.Path))
+	if hasLuaExt(target) && isRegularFile(target) {
+		return target, true
+	}
+	return b.resolveInstance(target, depth+1)
+}
+
+// followLinks returns the module a link file ultimately re-exports, or path
+// itself when it is not a link. Embedding the target instead of the link gives
+// every spelling of a Wally dependency (Packages/Roact, another package's
+// _Index sibling link, ...) the same canonical key per package version.
+func (b *Bundler) followLinks(path string) string {
+	if isAssetPath(path) {
+		return path
+	}
+	for hop := 0; hop < maxLinkHops; hop++ {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return path
+		}
+		m := linkModuleRegex.FindStringSubmatch(lua.Minify(string(data)))
+		if m == nil {
+			return path
+		}
+		next, ok := b.resolveInstancePath(path, m[1])
+		if !ok {
+			return path
+		}
+		path = next
+	}
+	return path
+}
+
+func firstNonEmpty(ss []string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package bundler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeWallyTree lays out a project with two Wally packages, where "signal"
+// depends on "util" through its own _Index sibling link:
+//
+//	Packages/Signal.lua                          (link)
+//	Packages/Util.lua                            (link)
+//	Packages/_Index/acme_signal@1.0.0/Util.lua   (dependency link)
+//	Packages/_Index/acme_signal@1.0.0/signal/    (default.project.json -> src)
+//	Packages/_Index/acme_util@2.1.0/util/init.lua
+func writeWallyTree(t *testing.T, dir string) {
+	write := func(p, s string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte(s), 0o644))
+	}
+	write("wally.toml", "[package]\nname = \"acme/game\"\nversion = \"0.1.0\"\n")
+	write("Packages/Signal.lua", `return require(script.Parent._Index["acme_signal@1.0.0"]["signal"])`+"\n")
+	write("Packages/Util.lua", `return require(script.Parent._Index["acme_util@2.1.0"]["util"])`+"\n")
+	write("Packages/_Index/acme_signal@1.0.0/Util.lua", `return require(script.Parent.Parent["acme_util@2.1.0"]["util"])`+"\n")
+	write("Packages/_Index/acme_signal@1.0.0/signal/default.project.json", `{"name":"signal","tree":{"$path":"src"}}`)
+	write("Packages/_Index/acme_signal@1.0.0/signal/src/init.lua", `local Util = require(script.Parent.Parent.Util)
+return { fire = function() return Util.tag end }`)
+	write("Packages/_Index/acme_util@2.1.0/util/init.lua", `return { tag = "UTIL" }`)
+}
+
+func TestResolveScriptRequire_WallyLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeWallyTree(t, dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(""), 0o644))
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+
+	signalSrc := filepath.Join(dir, "Packages/_Index/acme_signal@1.0.0/signal/src/init.lua")
+	got, ok := b.resolveScriptRequire(filepath.Join(dir, "Packages/Signal.lua"), `script.Parent._Index["acme_signal@1.0.0"]["signal"]`)
+	require.True(t, ok)
+	assert.Equal(t, signalSrc, got)
+
+	// Both the top-level link and the package-internal dependency link collapse
+	// onto the one util@2.1.0 source.
+	utilKey := "Packages/_Index/acme_util@2.1.0/util/init"
+	assert.Equal(t, utilKey, b.canonicalKey(filepath.Join(dir, "main.lua"), "./Packages/Util"))
+	dep, ok := b.resolveScriptRequire(signalSrc, "script.Parent.Parent.Util")
+	require.True(t, ok)
+	assert.Equal(t, utilKey, b.keyForPath(dep))
+
+	_, ok = b.resolveScriptRequire(filepath.Join(dir, "main.lua"), `script.Parent:WaitForChild("Missing")`)
+	assert.False(t, ok, "instance paths with no file on disk stay runtime requires")
+}
+
+func TestBundle_WallyPackagesEmbedded(t *testing.T) {
+	dir := t.TempDir()
+	writeWallyTree(t, dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`local Signal = require("./Packages/Signal")
+local Util = require(script.Parent:WaitForChild("Packages").Util)
+local Players = require(script.Parent.Players)
+print(Signal.fire(), Util.tag)`), 0o644))
+
+	b, err := NewBundler(filepath.Join(dir, "main.lua"), false, false)
+	require.NoError(t, err)
+	out, err := b.Bundle(false)
+	require.NoError(t, err)
+
+	mods := b.GetModules()
+	assert.Len(t, mods, 2, "one key per package version, no link modules: %v", keysOf(mods))
+	assert.Contains(t, mods, "Packages/_Index/acme_signal@1.0.0/signal/src/init")
+	assert.Contains(t, mods, "Packages/_Index/acme_util@2.1.0/util/init")
+	assert.Contains(t, out, `local Util = loadModule("Packages/_Index/acme_util@2.1.0/util/init")`)
+	assert.Contains(t, out, `require(script.Parent.Players)`, "unresolvable instance require must be left alone")
+	assert.False(t, strings.Contains(out, "_Index[\""), "link bodies must not be embedded")
+}
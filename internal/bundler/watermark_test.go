@@ -0,0 +1,99 @@
+package bundler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeWatermarkProject writes an entry and a module whose functions have
+// locals and strings to carry a watermark (ten functions are plenty), plus
+// ten private fields, and returns the entry's path.
+func writeWatermarkProject(t *testing.T, functions int) string {
+	t.Helper()
+	dir := t.TempDir()
+	var mod strings.Builder
+	mod.WriteString("local M = {}\n")
+	for i := range functions {
+		fmt.Fprintf(&mod, "function M.f%d(a, b)\n\tlocal label, total = \"fn%d\", a + b\n\treturn label .. total\nend\n", i, i)
+	}
+	var sum []string
+	for i := range 10 {
+		fmt.Fprintf(&mod, "M._v%d = %d\n", i, i)
+		sum = append(sum, fmt.Sprintf("M._v%d", i))
+	}
+	fmt.Fprintf(&mod, "function M.sum() return %s end\n", strings.Join(sum, " + "))
+	mod.WriteString("return M")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib.lua"), []byte(mod.String()), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.lua"), []byte(`local lib = require("./lib")
+local greeting = "hello"
+print(greeting, lib.f1(1, 2), lib.sum())`), 0o644))
+	return filepath.Join(dir, "main.lua")
+}
+
+func TestSetWatermark_Validates(t *testing.T) {
+	b := &Bundler{}
+	require.NoError(t, b.SetWatermark("user-1"))
+	assert.NotNil(t, b.watermark)
+	require.NoError(t, b.SetWatermark(""))
+	assert.Nil(t, b.watermark, "an empty ID turns watermarking off")
+	assert.Error(t, b.SetWatermark(strings.Repeat("x", 100)))
+}
+
+func TestBundle_Watermark(t *testing.T) {
+	for _, tc := range []struct {
+		functions  int
+		level      int
+		release    bool
+		antiTamper bool
+		mangle     bool
+	}{
+		{10, 2, false, false, false},
+		{10, 3, true, false, false},
+		{10, 4, true, true, false},
+		// Mangled field names look like renamed locals, so they must carry
+		// the mark as well: random ones would outvote it in a module with
+		// few locals (and here, without their votes, the locals alone are
+		// too few to carry it).
+		{2, 2, false, false, true},
+		{3, 3, true, false, true},
+	} {
+		entry := writeWatermarkProject(t, tc.functions)
+		// Names are random: build a few times so a mark that only
+		// sometimes survives fails the test.
+		for range 8 {
+			b, err := NewBundler(entry, false, false)
+			require.NoError(t, err)
+			b.SetObfuscationLevel(tc.level)
+			b.SetAntiTamper(tc.antiTamper)
+			b.SetManglePrivateFields(tc.mangle)
+			require.NoError(t, b.SetWatermark("user-48213"))
+			out, err := b.Bundle(tc.release)
+			require.NoError(t, err)
+			if tc.mangle {
+				require.NotContains(t, out, "_v1", "fields are mangled")
+			}
+
+			id, err := IdentifyWatermark(out)
+			require.NoError(t, err, "%+v", tc)
+			assert.Equal(t, "user-48213", id, "%+v", tc)
+		}
+	}
+}
+
+func TestBundle_WatermarkNeedsRenaming(t *testing.T) {
+	entry := writeWatermarkProject(t, 10)
+	for _, level := range []int{0, 1} {
+		b, err := NewBundler(entry, false, false)
+		require.NoError(t, err)
+		b.SetObfuscationLevel(level)
+		require.NoError(t, b.SetWatermark("user-1"))
+		_, err = b.Bundle(false)
+		assert.Error(t, err, "level %d has no names to carry the mark", level)
+	}
+}
@@ -0,0 +1,269 @@
+package lua
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// hoistableGlobals are the builtins HoistGlobals may fetch once at the top of
+// a chunk: Lua and Luau libraries and functions and Roblox globals whose value
+// never changes while a script runs. require, getfenv, setfenv and loadstring
+// are left alone; the bundler and the environment functions depend on seeing
+// them at the call site.
+var hoistableGlobals = map[string]bool{
+	"assert": true, "error": true, "ipairs": true, "pairs": true, "next": true,
+	"pcall": true, "xpcall": true, "print": true, "warn": true, "select": true,
+	"tonumber": true, "tostring": true, "type": true, "typeof": true,
+	"unpack": true, "rawget": true, "rawset": true, "rawequal": true,
+	"rawlen": true, "setmetatable": true, "getmetatable": true, "newproxy": true,
+	"math": true, "string": true, "table": true, "coroutine": true, "os": true,
+	"utf8": true, "bit32": true, "buffer": true, "task": true, "debug": true,
+	"game": true, "workspace": true, "script": true, "Instance": true,
+	"Enum": true, "Vector2": true, "Vector3": true, "CFrame": true,
+	"Color3": true, "BrickColor": true, "UDim": true, "UDim2": true,
+	"Ray": true, "Rect": true, "Region3": true, "TweenInfo": true,
+	"NumberRange": true, "NumberSequence": true, "NumberSequenceKeypoint": true,
+	"ColorSequence": true, "ColorSequenceKeypoint": true, "PhysicalProperties": true,
+	"Random": true, "DateTime": true, "RaycastParams": true, "OverlapParams": true,
+	"tick": true, "time": true, "wait": true, "delay": true, "spawn": true,
+}
+
+// maxHoistedGlobals bounds the locals HoistGlobals adds: each one can become
+// an upvalue of every function using it, and Lua 5.1 allows 60 per function.
+const maxHoistedGlobals = 32
+
+// HoistGlobals replaces reads of builtin globals (see hoistableGlobals) with
+// locals declared once at the top of the chunk, `local _0xa,_0xb=game,Enum`
+// in random order, so each name appears once instead of at every use. A
+// global the chunk assigns or defines a function on is left alone, and only
+// the most used ones are hoisted when there are too many or the chunk already
+// has many locals. It expects Rename to have resolved the chunk (resolving
+// again would drop the new names). The new names carry w's mark; w may be
+// nil. It returns the number of globals hoisted.
+func HoistGlobals(c *Chunk, w *Watermark) int {
+	uses := map[string][]*NameExpr{}
+	assigned := map[string]bool{}
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *AssignStat:
+			for _, t := range v.Targets {
+				if name, ok := t.(*NameExpr); ok {
+					assigned[name.Name] = true
+				}
+			}
+		case *FuncStat:
+			assigned[rootName(v.Target)] = true
+		case *NameExpr:
+			if v.Binding == nil && hoistableGlobals[v.Name] {
+				uses[v.Name] = append(uses[v.Name], v)
+			}
+		}
+		return true
+	})
+	var names []string
+	for name := range uses {
+		if !assigned[name] {
+			names = append(names, name)
+		}
+	}
+	// Most used first, ties by name, so the cut below is deterministic.
+	slices.SortFunc(names, func(a, b string) int {
+		if d := len(uses[b]) - len(uses[a]); d != 0 {
+			return d
+		}
+		if a < b {
+			return -1
+		}
+		return 1
+	})
+	names = names[:min(len(names), maxHoistedGlobals, max(0, maxHoistedLocals-topLevelLocals(c.Body)))]
+	if len(names) == 0 {
+		return 0
+	}
+
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	shuffleInts(order)
+	decl := &LocalStat{}
+	taken := map[string]bool{}
+	for _, i := range order {
+		name := names[i]
+		local := freshName(c, taken, w)
+		b := &Binding{OrigName: local, NewName: local}
+		for _, use := range uses[name] {
+			use.Name, use.Binding = local, b
+		}
+		decl.Names = append(decl.Names, &NameExpr{Name: local, Binding: b})
+		decl.Values = append(decl.Values, &NameExpr{Name: name})
+	}
+	c.Body = append([]Stat{decl}, c.Body...)
+	return len(names)
+}
+
+// rootName returns the leading name of a function statement's a.b:c target.
+func rootName(x Expr) string {
+	for {
+		switch v := x.(type) {
+		case *IndexExpr:
+			x = v.Obj
+		case *NameExpr:
+			return v.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// topLevelLocals counts the locals declared directly in stats.
+func topLevelLocals(stats []Stat) int {
+	n := 0
+	for _, s := range stats {
+		switch v := s.(type) {
+		case *LocalStat:
+			n += len(v.Names)
+		case *LocalFuncStat:
+			n++
+		}
+	}
+	return n
+}
+
+// freshName returns a renamer-style name the chunk does not use and that is
+// not in taken, and adds it to taken.
+func freshName(c *Chunk, taken map[string]bool, w *Watermark) string {
+	for {
+		name := w.name()
+		if !taken[name] && !UsesName(c, name) {
+			taken[name] = true
+			return name
+		}
+	}
+}
+
+// BracketFields turns field accesses a.b into a["b"] and method calls a:b(...)
+// on a name into a["b"](a, ...), so EncryptStrings can encrypt the field and
+// method names. A method call on any other expression, whose object must be
+// evaluated once, goes through a helper local declared at the top of the
+// chunk: obj.x:b(...) becomes h(obj["x"], "b", ...), where
+// h(o, k, ...) tail-calls o[k](o, ...), looking the method up after the
+// arguments are evaluated rather than before. Function statement targets and
+// the calls whose arguments EncryptStrings must not touch (see
+// isProtectedCall) are kept. The helper's names carry w's mark; w may be nil.
+// It returns the number of accesses rewritten.
+func BracketFields(c *Chunk, w *Watermark) int {
+	targets := map[*IndexExpr]bool{}
+	Inspect(c, func(n Node) bool {
+		if f, ok := n.(*FuncStat); ok {
+			for x := f.Target; ; {
+				ix, ok := x.(*IndexExpr)
+				if !ok {
+					break
+				}
+				targets[ix] = true
+				x = ix.Obj
+			}
+		}
+		return true
+	})
+	count := 0
+	var helper *Binding
+	taken := map[string]bool{}
+	rewriteExprs(c, func(x Expr) Expr {
+		switch v := x.(type) {
+		case *IndexExpr:
+			if v.Key == nil && !v.IsMethod && !targets[v] {
+				count++
+				return &IndexExpr{Obj: v.Obj, Key: StringLiteral(v.Field)}
+			}
+		case *CallExpr:
+			m, ok := v.Fn.(*IndexExpr)
+			if !ok || !m.IsMethod || isProtectedCall(v) {
+				return x
+			}
+			count++
+			obj, ok := m.Obj.(*NameExpr)
+			if !ok {
+				if helper == nil {
+					name := freshName(c, taken, w)
+					helper = &Binding{OrigName: name, NewName: name}
+				}
+				return &CallExpr{Fn: ref(helper), Args: append([]Expr{m.Obj, StringLiteral(m.Field)}, v.Args...)}
+			}
+			self := &NameExpr{Name: obj.Name, Binding: obj.Binding}
+			return &CallExpr{
+				Fn:   &IndexExpr{Obj: obj, Key: StringLiteral(m.Field)},
+				Args: append([]Expr{self}, v.Args...),
+			}
+		}
+		return x
+	})
+	if helper != nil {
+		c.Body = append([]Stat{methodHelper(c, helper, taken, w)}, c.Body...)
+	}
+	return count
+}
+
+// methodHelper returns the declaration of helper,
+// `local function h(o, k, ...) return o[k](o, ...) end`.
+func methodHelper(c *Chunk, helper *Binding, taken map[string]bool, w *Watermark) Stat {
+	param := func() *NameExpr {
+		name := freshName(c, taken, w)
+		return &NameExpr{Name: name, Binding: &Binding{OrigName: name, NewName: name}}
+	}
+	o, k := param(), param()
+	call := &CallExpr{
+		Fn:   &IndexExpr{Obj: ref(o.Binding), Key: ref(k.Binding)},
+		Args: []Expr{ref(o.Binding), &VarargExpr{}},
+	}
+	return &LocalFuncStat{
+		Name: ref(helper),
+		Func: &FuncExpr{Params: []*NameExpr{o, k}, IsVararg: true, Body: []Stat{&ReturnStat{Values: []Expr{call}}}},
+	}
+}
+
+// maxHiddenNumber keeps HideNumbers' operands and results exact in a double.
+const maxHiddenNumber = 1 << 31
+
+// HideNumbers rewrites integer literals (decimal or hex, below 2^31) as
+// equivalent arithmetic on other integers, (a+b), (a-b) or (q*d+r), picked at
+// random. Lua 5.1 and Luau fold such constant expressions when compiling, so
+// the rewrite costs nothing at run time. It returns the number of literals
+// rewritten.
+func HideNumbers(c *Chunk) int {
+	count := 0
+	rewriteExprs(c, func(x Expr) Expr {
+		v, ok := x.(*NumberExpr)
+		if !ok {
+			return x
+		}
+		n, err := strconv.ParseInt(v.Text, 10, 64)
+		if hex, ok := strings.CutPrefix(strings.ToLower(v.Text), "0x"); ok {
+			n, err = strconv.ParseInt(hex, 16, 64)
+		}
+		if err != nil || n < 0 || n >= maxHiddenNumber {
+			return x
+		}
+		count++
+		return hideNumber(int(n))
+	})
+	return count
+}
+
+func hideNumber(n int) Expr {
+	var e Expr
+	switch randomInt(3) {
+	case 0:
+		a := randomInt(n + 1)
+		e = &BinExpr{Op: "+", L: number(a), R: number(n - a)}
+	case 1:
+		b := 1 + randomInt(1<<16)
+		e = &BinExpr{Op: "-", L: number(n + b), R: number(b)}
+	default:
+		d := 2 + randomInt(254)
+		e = &BinExpr{Op: "+", L: &BinExpr{Op: "*", L: number(n / d), R: number(d)}, R: number(n % d)}
+	}
+	return &ParenExpr{E: e}
+}
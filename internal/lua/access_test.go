@@ -0,0 +1,112 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHoistGlobals(t *testing.T) {
+	c := parseRenamed(t, `
+		local p = game:GetService("Players")
+		print(p, math.floor(1.5), math.max(1, 2))
+		task = nil
+		function string.trim(s) return s end
+		print(require("x"), custom)`)
+	if n := HoistGlobals(c, nil); n != 3 {
+		t.Fatalf("want game, print and math hoisted, got %d:\n%s", n, c.Print())
+	}
+	decl, ok := c.Body[0].(*LocalStat)
+	if !ok || len(decl.Names) != 3 {
+		t.Fatalf("expected a leading local with three names:\n%s", c.Print())
+	}
+	out := c.Print()
+	for _, name := range []string{"game", "print", "math"} {
+		if strings.Count(out, name) != 1 {
+			t.Errorf("%s should appear only in the declaration:\n%s", name, out)
+		}
+	}
+	// Assigned or defined-on globals, require and unknown names stay.
+	for _, keep := range []string{"task=nil", "function string.trim", `require("x")`, "custom"} {
+		if !strings.Contains(out, keep) {
+			t.Errorf("missing %q:\n%s", keep, out)
+		}
+	}
+	if _, err := Parse(out); err != nil {
+		t.Fatalf("output must parse: %v\n%s", err, out)
+	}
+}
+
+func TestHoistGlobals_NothingToHoist(t *testing.T) {
+	c := parseRenamed(t, `local x = 1 return custom(x)`)
+	before := c.Print()
+	if n := HoistGlobals(c, nil); n != 0 || c.Print() != before {
+		t.Fatalf("nothing should change, got:\n%s", c.Print())
+	}
+}
+
+func TestBracketFields(t *testing.T) {
+	c := parseRenamed(t, `
+		local M = {}
+		function M.util.f(x) return x.value end
+		local s = M:get(1)
+		M.cfg.items:add(2)
+		local p = game:GetService("Players").LocalPlayer
+		p.Character:FindFirstChild("Humanoid")
+		local ok = pcall(M.run)`)
+	if n := BracketFields(c, nil); n != 10 {
+		t.Fatalf("want 10 rewrites, got %d:\n%s", n, c.Print())
+	}
+	out := c.Print()
+	for _, want := range []string{
+		`.util.f(`,                        // function statement target kept
+		`["value"]`,                       // field read
+		`["get"](`,                        // method call on a name
+		`["cfg"]["items"],"add",2)`,       // method call on another expression, through the helper
+		`["Character"],"FindFirstChild",`, // likewise
+		`["GetService"](`,
+		`["LocalPlayer"]`,
+		`pcall(`,
+		`["run"])`, // arguments of a non-protected call
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, ":") {
+		t.Errorf("no method call may keep its name in plain text:\n%s", out)
+	}
+	helper := c.Body[0].(*LocalFuncStat)
+	if got := strings.Count(out, helper.Name.Name); got != 3 {
+		t.Errorf("the helper is declared once and called twice, found %d uses:\n%s", got, out)
+	}
+	if _, err := Parse(out); err != nil {
+		t.Fatalf("output must parse: %v\n%s", err, out)
+	}
+}
+
+func TestHideNumbers(t *testing.T) {
+	src := `emit(0, 1, 7, 255, 65536, 123456789, 2147483647, 1.5, 3e2, 010, 2147483648)`
+	want := runTrace(t, parseRenamed(t, src))
+	c := parseRenamed(t, src)
+	if n := HideNumbers(c); n != 8 {
+		t.Fatalf("want 8 literals hidden, got %d:\n%s", n, c.Print())
+	}
+	out := c.Print()
+	for _, kept := range []string{"1.5", "3e2", "2147483648"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("%s must be kept:\n%s", kept, out)
+		}
+	}
+	if strings.Contains(out, "123456789") {
+		t.Errorf("literal not hidden:\n%s", out)
+	}
+	if got := runTrace(t, c); got != want {
+		t.Fatalf("values changed:\nwant %s\ngot  %s", want, got)
+	}
+
+	hex := parseRenamed(t, `emit(0x10, 0XfF)`)
+	HideNumbers(hex)
+	if got := runTrace(t, hex); got != "16 255" {
+		t.Fatalf("hex literals: got %s:\n%s", got, hex.Print())
+	}
+}
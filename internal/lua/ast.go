@@ -143,6 +143,13 @@ type NumberExpr struct{ Text string } // verbatim token text
 
 type StringExpr struct{ Text string } // verbatim token text incl. quotes/brackets
 
+// InterpExpr is a Luau interpolated string `a{x}b{y}c`. Parts are the literal
+// segments around the braces as written (escapes kept), one more than Exprs.
+type InterpExpr struct {
+	Parts []string
+	Exprs []Expr
+}
+
 type BoolExpr struct{ Val bool }
 
 type NilExpr struct{}
@@ -167,6 +174,7 @@ type FuncExpr struct {
 	Params   []*NameExpr // renameable, scoped to Body
 	IsVararg bool
 	Body     []Stat
+	Self     *Binding // implicit self of a method, set by the scope resolver
 }
 
 type TableExpr struct{ Fields []TableField }
@@ -210,6 +218,8 @@ func (*NumberExpr) node() {}
 func (*NumberExpr) expr() {}
 func (*StringExpr) node() {}
 func (*StringExpr) expr() {}
+func (*InterpExpr) node() {}
+func (*InterpExpr) expr() {}
 func (*BoolExpr) node()   {}
 func (*BoolExpr) expr()   {}
 func (*NilExpr) node()    {}
@@ -0,0 +1,322 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseConstant parses src as a single Lua constant expression (nil, true,
+// false, a number or a string, optionally combined with operators that fold
+// to a constant) and returns it as a literal node.
+func ParseConstant(src string) (Expr, error) {
+	c, err := Parse("return " + src)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Body) != 1 {
+		return nil, fmt.Errorf("%q is not a single expression", src)
+	}
+	ret, ok := c.Body[0].(*ReturnStat)
+	if !ok || len(ret.Values) != 1 {
+		return nil, fmt.Errorf("%q is not a single expression", src)
+	}
+	k, ok := constValue(ret.Values[0])
+	if !ok {
+		return nil, fmt.Errorf("%q is not a constant", src)
+	}
+	return k.literal(), nil
+}
+
+// ApplyDefines replaces every read of a free (global) name listed in defines
+// with a copy of its literal value. Locals that shadow a define, assignment
+// targets and function-statement names are left alone. It returns the number
+// of replacements made.
+func ApplyDefines(c *Chunk, defines map[string]Expr) int {
+	if len(defines) == 0 {
+		return 0
+	}
+	resolve(c)
+	targets := map[*NameExpr]bool{}
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *AssignStat:
+			for _, t := range v.Targets {
+				if name, ok := t.(*NameExpr); ok {
+					targets[name] = true
+				}
+			}
+		case *FuncStat:
+			root := v.Target
+			for {
+				ix, ok := root.(*IndexExpr)
+				if !ok {
+					break
+				}
+				root = ix.Obj
+			}
+			if name, ok := root.(*NameExpr); ok {
+				targets[name] = true
+			}
+		}
+		return true
+	})
+	count := 0
+	rewriteExprs(c, func(x Expr) Expr {
+		name, ok := x.(*NameExpr)
+		if !ok || name.Binding != nil || targets[name] {
+			return protectPrefix(x)
+		}
+		val, ok := defines[name.Name]
+		if !ok {
+			return x
+		}
+		k, _ := constValue(val)
+		count++
+		return k.literal()
+	})
+	return count
+}
+
+// FoldConstants folds expressions whose value is known at compile time into
+// literals and removes code that can never run: if/elseif branches with a
+// constant condition, while loops whose condition is constantly false, and
+// the dead side of and/or and if-expressions. It reports whether anything
+// changed.
+func FoldConstants(c *Chunk) bool {
+	changed := false
+	rewriteExprs(c, func(x Expr) Expr {
+		if y := foldExpr(x); y != x {
+			changed = true
+			return y
+		}
+		return x
+	})
+	eachBlock(c, func(stats []Stat) []Stat {
+		out, pruned := pruneBlock(stats)
+		changed = changed || pruned
+		return out
+	})
+	return changed
+}
+
+// foldExpr returns the folded form of x, or x itself when nothing folds. Its
+// children have already been folded.
+func foldExpr(x Expr) Expr {
+	switch v := x.(type) {
+	case *NilExpr, *BoolExpr, *NumberExpr, *StringExpr:
+		return x
+	case *IndexExpr, *CallExpr:
+		return protectPrefix(x)
+	case *ParenExpr:
+		if _, lit := parenLiteral(v.E).(*ParenExpr); lit {
+			return x // keeps a literal valid where it is indexed or called
+		}
+	case *UnExpr:
+		if _, ok := v.E.(*NumberExpr); ok && v.Op == "-" {
+			return x // already the literal form of a negative number
+		}
+	case *BinExpr:
+		if v.Op == "and" || v.Op == "or" {
+			l, ok := constValue(v.L)
+			if !ok {
+				return x
+			}
+			if l.truthy() == (v.Op == "or") {
+				return l.literal()
+			}
+			return singleValue(v.R)
+		}
+	case *IfExpr:
+		conds := append([]Expr{v.Cond}, v.ElifConds...)
+		thens := append([]Expr{v.Then}, v.ElifThen...)
+		for i, cond := range conds {
+			k, ok := constValue(cond)
+			if !ok {
+				if i == 0 {
+					return x
+				}
+				return &IfExpr{Cond: cond, Then: thens[i], ElifConds: conds[i+1:], ElifThen: thens[i+1:], Else: v.Else}
+			}
+			if k.truthy() {
+				return singleValue(thens[i])
+			}
+		}
+		return singleValue(v.Else)
+	}
+	if k, ok := constValue(x); ok {
+		return k.literal()
+	}
+	return x
+}
+
+// protectPrefix parenthesizes a literal that ended up as the object of an
+// index or the callee of a call ("s":upper() is not valid Lua).
+func protectPrefix(x Expr) Expr {
+	switch v := x.(type) {
+	case *IndexExpr:
+		v.Obj = parenLiteral(v.Obj)
+	case *CallExpr:
+		v.Fn = parenLiteral(v.Fn)
+	}
+	return x
+}
+
+func parenLiteral(x Expr) Expr {
+	switch x.(type) {
+	case *NilExpr, *BoolExpr, *NumberExpr, *StringExpr, *UnExpr:
+		return &ParenExpr{E: x}
+	}
+	return x
+}
+
+// singleValue wraps calls and varargs in parentheses so that replacing an
+// operator expression with one of its operands still yields exactly one value.
+func singleValue(x Expr) Expr {
+	switch x.(type) {
+	case *CallExpr, *VarargExpr:
+		return &ParenExpr{E: x}
+	}
+	return x
+}
+
+// pruneBlock removes statically dead statements from stats. An if statement
+// whose taken branch is known is replaced by that branch in a do block, which
+// keeps its locals scoped as before.
+func pruneBlock(stats []Stat) ([]Stat, bool) {
+	out := stats[:0]
+	changed := false
+	for _, st := range stats {
+		switch v := st.(type) {
+		case *IfStat:
+			repl, ok := pruneIf(v)
+			if ok {
+				changed = true
+				if repl != nil {
+					out = append(out, repl)
+				}
+				continue
+			}
+		case *WhileStat:
+			if k, ok := constValue(v.Cond); ok && !k.truthy() {
+				changed = true
+				continue
+			}
+		}
+		out = append(out, st)
+	}
+	return out, changed
+}
+
+// pruneIf drops the branches of v whose condition is constantly false and
+// everything after a branch whose condition is constantly true. It returns the
+// replacement statement (nil to delete v entirely) and whether v changed.
+func pruneIf(v *IfStat) (Stat, bool) {
+	var conds []Expr
+	var blocks [][]Stat
+	elseBody, hasElse := v.Else, v.HasElse
+	changed := false
+	for i, cond := range v.Conds {
+		k, ok := constValue(cond)
+		if !ok {
+			conds = append(conds, cond)
+			blocks = append(blocks, v.Blocks[i])
+			continue
+		}
+		changed = true
+		if k.truthy() {
+			elseBody, hasElse = v.Blocks[i], true
+			break
+		}
+	}
+	if !changed {
+		return v, false
+	}
+	if len(conds) == 0 {
+		if !hasElse || len(elseBody) == 0 {
+			return nil, true
+		}
+		return &DoStat{Body: elseBody}, true
+	}
+	return &IfStat{Conds: conds, Blocks: blocks, Else: elseBody, HasElse: hasElse}, true
+}
+
+// literal returns a fresh literal node for k. Negative numbers are written as
+// a unary minus applied to a positive literal, as the parser would produce.
+func (k constant) literal() Expr {
+	switch k.kind {
+	case constBool:
+		return &BoolExpr{Val: k.b}
+	case constNumber:
+		if k.n < 0 {
+			return &UnExpr{Op: "-", E: &NumberExpr{Text: formatNumber(-k.n)}}
+		}
+		return &NumberExpr{Text: formatNumber(k.n)}
+	case constString:
+		return &StringExpr{Text: QuoteString(k.s)}
+	}
+	return &NilExpr{}
+}
+
+func formatNumber(n float64) string {
+	if n == float64(int64(n)) {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// QuoteString returns a double-quoted Lua literal for s. Printable ASCII is
+// kept as is; everything else is written as a 3-digit decimal escape, which
+// Lua 5.1 and Luau both accept.
+func QuoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\%03d", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// StringLiteral returns a string literal node holding s.
+func StringLiteral(s string) Expr {
+	return &StringExpr{Text: QuoteString(s)}
+}
+
+// EvalCondition evaluates src as a constant Lua expression after substituting
+// defines; any other global name reads as nil. It reports the truthiness of
+// the result and fails if src is not a single expression or depends on
+// anything but defines (e.g. a function call).
+func EvalCondition(src string, defines map[string]Expr) (bool, error) {
+	c, err := Parse("return " + src)
+	if err != nil {
+		return false, err
+	}
+	if len(c.Body) != 1 {
+		return false, fmt.Errorf("%q is not a single expression", src)
+	}
+	ret, ok := c.Body[0].(*ReturnStat)
+	if !ok || len(ret.Values) != 1 {
+		return false, fmt.Errorf("%q is not a single expression", src)
+	}
+	ApplyDefines(c, defines)
+	rewriteExprs(c, func(x Expr) Expr {
+		if name, ok := x.(*NameExpr); ok && name.Binding == nil {
+			return &NilExpr{}
+		}
+		return x
+	})
+	k, ok := constValue(ret.Values[0])
+	if !ok {
+		return false, fmt.Errorf("%q is not a constant condition", src)
+	}
+	return k.truthy(), nil
+}
@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func defined(t *testing.T, src string, defines map[string]string) string {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	defs := map[string]Expr{}
+	for name, lit := range defines {
+		e, err := ParseConstant(lit)
+		if err != nil {
+			t.Fatalf("ParseConstant(%q): %v", lit, err)
+		}
+		defs[name] = e
+	}
+	ApplyDefines(c, defs)
+	FoldConstants(c)
+	return c.Print()
+}
+
+func TestParseConstant(t *testing.T) {
+	for _, src := range []string{"false", "nil", "1.5", `"1.2"`, "-3", `"a" .. "b"`} {
+		if _, err := ParseConstant(src); err != nil {
+			t.Errorf("ParseConstant(%s): %v", src, err)
+		}
+	}
+	for _, src := range []string{"x", "f()", "1, 2", ""} {
+		if _, err := ParseConstant(src); err == nil {
+			t.Errorf("ParseConstant(%s) should fail", src)
+		}
+	}
+}
+
+func TestApplyDefines_RemovesDeadBranches(t *testing.T) {
+	src := "if DEBUG then print('dev') else run() end\nreturn VERSION"
+	out := defined(t, src, map[string]string{"DEBUG": "false", "VERSION": `"1.2"`})
+	want := `do run()end return"1.2"`
+	if out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
+
+func TestApplyDefines_LocalsAndTargetsUntouched(t *testing.T) {
+	src := "local DEBUG = true\nif DEBUG then a() end"
+	if out := defined(t, src, map[string]string{"DEBUG": "false"}); !strings.Contains(out, "a()") {
+		t.Fatalf("shadowing local replaced: %q", out)
+	}
+	src = "DEBUG = 1\nfunction DEBUG.f() end"
+	if out := defined(t, src, map[string]string{"DEBUG": "false"}); strings.Contains(out, "false") {
+		t.Fatalf("assignment target replaced: %q", out)
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	cases := map[string]string{
+		"return DEBUG and f()":                    "return false",
+		"return not DEBUG and f()":                "return(f())",
+		"return DEBUG or 2 + 3":                   "return 5",
+		"while DEBUG do x() end return 1":         "return 1",
+		"if DEBUG then a() elseif c then b() end": "if c then b()end",
+		"return (NAME):upper()":                   `return("x"):upper()`,
+		"return if DEBUG then 1 else 2":           "return 2",
+		"return 1e308 * 10":                       "return 1e308*10",
+		"local x = 010 + 1":                       "local x=11",
+		"if 010 == 10 then print(1) end":          "do print(1)end",
+		"return OCTAL":                            "return 10",
+		`return 99999999999999 .. ""`:             `return"99999999999999"`,
+		`return 1e15 .. ""`:                       `return 1e15 ..""`,
+	}
+	for src, want := range cases {
+		if got := defined(t, src, map[string]string{"DEBUG": "false", "NAME": `"x"`, "OCTAL": "010"}); got != want {
+			t.Errorf("fold(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	defs := map[string]Expr{"RELEASE": &BoolExpr{Val: true}, "TARGET": StringLiteral("prod")}
+	cases := map[string]bool{
+		"RELEASE":              true,
+		"not RELEASE":          false,
+		`TARGET == "prod"`:     true,
+		"UNDEFINED":            false,
+		"UNDEFINED or RELEASE": true,
+	}
+	for src, want := range cases {
+		got, err := EvalCondition(src, defs)
+		if err != nil || got != want {
+			t.Errorf("EvalCondition(%s) = %v, %v; want %v", src, got, err, want)
+		}
+	}
+	for _, src := range []string{"f()", "", "a, b"} {
+		if _, err := EvalCondition(src, defs); err == nil {
+			t.Errorf("EvalCondition(%q) should fail", src)
+		}
+	}
+}
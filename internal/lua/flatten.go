@@ -0,0 +1,330 @@
+package lua
+
+import (
+	"encoding/hex"
+	"slices"
+	"strconv"
+)
+
+// maxHoistedLocals keeps a flattened function well under the 200-locals limit
+// of Lua 5.1 and Luau once its block-scoped locals are hoisted.
+const maxHoistedLocals = 150
+
+// maxStateID bounds state numbers so opaque predicates such as
+// x*(x+1)*(x+2) stay exact in a double.
+const maxStateID = 1 << 16
+
+// Flatten rewrites the body of the chunk and of every function in it into a
+// state machine: a dispatch loop `while st ~= END do if st == A then ...
+// elseif st == B then ... end end` whose arms run the original statements in
+// shuffled order and select the next arm, sometimes behind an opaque predicate
+// with a dead decoy arm. if/elseif/else and do blocks are split into arms;
+// loops and nested functions stay whole inside their arm (their own bodies
+// are flattened as functions), so break, continue and goto inside them keep
+// their targets, return still leaves the function and no closure is added, so
+// upvalues are untouched.
+//
+// Block-scoped locals of the split region are hoisted to the top of the
+// function. That is only sound when every such statement runs at most once
+// per call and no two locals share a name, so Flatten expects Rename to have
+// run and leaves a function unchanged when a hoisted local was not renamed,
+// when it declares labels or type aliases outside loops, or when it has too
+// many locals. It returns the number of function bodies flattened.
+func Flatten(c *Chunk) int {
+	state := stateName(c)
+	bodies := []*[]Stat{&c.Body}
+	Inspect(c, func(n Node) bool {
+		if f, ok := n.(*FuncExpr); ok {
+			bodies = append(bodies, &f.Body)
+		}
+		return true
+	})
+	count := 0
+	for _, body := range bodies {
+		if flat, ok := flattenBody(*body, state); ok {
+			*body = flat
+			count++
+		}
+	}
+	return count
+}
+
+// stateName returns a fresh identifier for the state variable, shaped like
+// the renamer's names but one digit longer so it can never be one of them.
+func stateName(c *Chunk) string {
+	for {
+		name := "_0x" + hex.EncodeToString(randomBytes(4))[:7]
+		if !UsesName(c, name) {
+			return name
+		}
+	}
+}
+
+type flattener struct {
+	state   *Binding
+	hoisted []*NameExpr
+	arms    map[int][]Stat
+	ids     map[int]bool
+	decoys  []int
+}
+
+// flattenBody returns the flattened form of one function body, or ok=false if
+// it cannot be flattened safely or is too small to be worth it.
+func flattenBody(body []Stat, state string) ([]Stat, bool) {
+	f := &flattener{
+		state: &Binding{OrigName: state, NewName: state},
+		arms:  map[int][]Stat{},
+		ids:   map[int]bool{},
+	}
+	if !f.hoistable(body) || len(f.hoisted) > maxHoistedLocals {
+		return nil, false
+	}
+	end := f.newID()
+	entry := f.seq(body, end)
+	if len(f.arms)-len(f.decoys) < 2 {
+		return nil, false
+	}
+	for range 1 + randomInt(2) {
+		id := f.newID()
+		f.decoys = append(f.decoys, id)
+		f.arms[id] = []Stat{f.set(f.realArm(entry))}
+	}
+
+	ids := make([]int, 0, len(f.arms))
+	for id := range f.arms {
+		ids = append(ids, id)
+	}
+	shuffleInts(ids)
+	dispatch := &IfStat{}
+	for _, id := range ids {
+		dispatch.Conds = append(dispatch.Conds, &BinExpr{Op: "==", L: f.ref(), R: number(id)})
+		dispatch.Blocks = append(dispatch.Blocks, f.arms[id])
+	}
+
+	var out []Stat
+	if len(f.hoisted) > 0 {
+		out = append(out, &LocalStat{Names: f.hoisted})
+	}
+	return append(out,
+		&LocalStat{Names: []*NameExpr{f.ref()}, Values: []Expr{number(entry)}},
+		&WhileStat{Cond: &BinExpr{Op: "~=", L: f.ref(), R: number(end)}, Body: []Stat{dispatch}},
+	), true
+}
+
+// hoistable collects the locals declared in the region that will be split
+// into arms (the body and its if/do blocks) and reports whether they can all
+// be hoisted.
+func (f *flattener) hoistable(stats []Stat) bool {
+	for _, s := range stats {
+		switch v := s.(type) {
+		case *LocalStat:
+			for _, n := range v.Names {
+				if !f.hoist(n) {
+					return false
+				}
+			}
+		case *LocalFuncStat:
+			if !f.hoist(v.Name) {
+				return false
+			}
+		case *DoStat:
+			if !f.hoistable(v.Body) {
+				return false
+			}
+		case *IfStat:
+			for _, b := range v.Blocks {
+				if !f.hoistable(b) {
+					return false
+				}
+			}
+			if v.HasElse && !f.hoistable(v.Else) {
+				return false
+			}
+		case *LabelStat, *GotoStat, *TypeAliasStat, *BreakStat, *ContinueStat:
+			return false
+		}
+	}
+	return true
+}
+
+func (f *flattener) hoist(n *NameExpr) bool {
+	if n.Binding == nil || n.Binding.NewName == "" {
+		return false
+	}
+	f.hoisted = append(f.hoisted, &NameExpr{Name: n.Name, Binding: n.Binding})
+	return true
+}
+
+// seq adds the arms running stats and then continuing at next, and returns
+// the id of the first one (next itself if stats is empty).
+func (f *flattener) seq(stats []Stat, next int) int {
+	cur := next
+	for i := len(stats); i > 0; {
+		switch v := stats[i-1].(type) {
+		case *DoStat:
+			cur = f.seq(v.Body, cur)
+			i--
+		case *IfStat:
+			cur = f.ifArms(v, cur)
+			i--
+		default:
+			// A run of up to two plain statements shares an arm.
+			j := i - 1
+			if j > 0 && isPlain(stats[j-1]) {
+				j--
+			}
+			cur = f.arm(stats[j:i], cur)
+			i = j
+		}
+	}
+	return cur
+}
+
+func isPlain(s Stat) bool {
+	switch s.(type) {
+	case *DoStat, *IfStat:
+		return false
+	}
+	return true
+}
+
+func isReturn(s Stat) bool {
+	_, ok := s.(*ReturnStat)
+	return ok
+}
+
+// ifArms splits an if statement into one arm per condition, each jumping to
+// its block or to the next condition; all blocks continue at join.
+func (f *flattener) ifArms(v *IfStat, join int) int {
+	next := join
+	if v.HasElse {
+		next = f.seq(v.Else, join)
+	}
+	for i := len(v.Conds) - 1; i >= 0; i-- {
+		then := f.seq(v.Blocks[i], join)
+		id := f.newID()
+		f.arms[id] = []Stat{&IfStat{
+			Conds:   []Expr{v.Conds[i]},
+			Blocks:  [][]Stat{{f.set(then)}},
+			HasElse: true,
+			Else:    []Stat{f.set(next)},
+		}}
+		next = id
+	}
+	return next
+}
+
+// arm adds an arm running stats (locals turned into assignments to their
+// hoisted declarations) and then moving to next, unless it returns.
+func (f *flattener) arm(stats []Stat, next int) int {
+	var body []Stat
+	for _, s := range stats {
+		switch v := s.(type) {
+		case *LocalStat:
+			if len(v.Values) > 0 {
+				targets := make([]Expr, len(v.Names))
+				for i, n := range v.Names {
+					targets[i] = n
+				}
+				body = append(body, &AssignStat{Targets: targets, Op: "=", Values: v.Values})
+			}
+		case *LocalFuncStat:
+			body = append(body, &AssignStat{Targets: []Expr{v.Name}, Op: "=", Values: []Expr{v.Func}})
+		default:
+			body = append(body, s)
+		}
+	}
+	if len(body) == 0 || !isReturn(body[len(body)-1]) {
+		body = append(body, f.jump(next)...)
+	}
+	id := f.newID()
+	f.arms[id] = body
+	return id
+}
+
+// jump returns the statements moving to state to: a plain assignment, or one
+// guarded by an opaque predicate whose dead branch leads to a decoy arm.
+func (f *flattener) jump(to int) []Stat {
+	if randomInt(2) == 0 {
+		return []Stat{f.set(to)}
+	}
+	decoy := f.newID()
+	f.decoys = append(f.decoys, decoy)
+	f.arms[decoy] = []Stat{f.set(f.realArm(to))}
+	pred, holds := opaquePredicate(func() Expr { return f.ref() })
+	branch := &IfStat{Conds: []Expr{pred}, HasElse: true}
+	if holds {
+		branch.Blocks = [][]Stat{{f.set(to)}}
+		branch.Else = []Stat{f.set(decoy)}
+	} else {
+		branch.Blocks = [][]Stat{{f.set(decoy)}}
+		branch.Else = []Stat{f.set(to)}
+	}
+	return []Stat{branch}
+}
+
+// opaquePredicate returns a condition on an integral variable, read by x,
+// whose value is fixed but not obvious, and that value. The variable must stay
+// below maxStateID for the arithmetic to be exact.
+func opaquePredicate(x func() Expr) (Expr, bool) {
+	holds := randomInt(2) == 0
+	eq := "=="
+	if !holds {
+		eq = "~="
+	}
+	switch randomInt(3) {
+	case 0: // x*(x+1) is even
+		prod := &BinExpr{Op: "*", L: x(), R: &ParenExpr{E: &BinExpr{Op: "+", L: x(), R: number(1)}}}
+		return &BinExpr{Op: eq, L: &BinExpr{Op: "%", L: prod, R: number(2)}, R: number(0)}, holds
+	case 1: // a square is never 2 mod 4
+		sq := &BinExpr{Op: "*", L: x(), R: x()}
+		op := "~="
+		if !holds {
+			op = "=="
+		}
+		return &BinExpr{Op: op, L: &BinExpr{Op: "%", L: sq, R: number(4)}, R: number(2)}, holds
+	default: // x*(x+1)*(x+2) is a multiple of 3
+		prod := &BinExpr{Op: "*",
+			L: &BinExpr{Op: "*", L: x(), R: &ParenExpr{E: &BinExpr{Op: "+", L: x(), R: number(1)}}},
+			R: &ParenExpr{E: &BinExpr{Op: "+", L: x(), R: number(2)}}}
+		return &BinExpr{Op: eq, L: &BinExpr{Op: "%", L: prod, R: number(3)}, R: number(0)}, holds
+	}
+}
+
+// realArm returns a random non-decoy state for decoys to point at, or
+// fallback if there is none yet.
+func (f *flattener) realArm(fallback int) int {
+	var real []int
+	for id := range f.arms {
+		if !slices.Contains(f.decoys, id) {
+			real = append(real, id)
+		}
+	}
+	if len(real) == 0 {
+		return fallback
+	}
+	slices.Sort(real)
+	return real[randomInt(len(real))]
+}
+
+func (f *flattener) newID() int {
+	for {
+		id := 1 + randomInt(maxStateID-1)
+		if !f.ids[id] {
+			f.ids[id] = true
+			return id
+		}
+	}
+}
+
+func (f *flattener) ref() *NameExpr {
+	return &NameExpr{Name: f.state.OrigName, Binding: f.state}
+}
+
+func (f *flattener) set(id int) Stat {
+	return &AssignStat{Targets: []Expr{f.ref()}, Op: "=", Values: []Expr{number(id)}}
+}
+
+func number(n int) *NumberExpr {
+	return &NumberExpr{Text: strconv.Itoa(n)}
+}
@@ -0,0 +1,375 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The tests below run programs before and after Flatten with a small
+// evaluator for the subset of Lua they use (numbers, strings, booleans,
+// locals, closures, if, while, numeric for, break, return, opaque tables) and
+// compare the values passed to emit().
+
+type evalScope struct {
+	vars   map[*Binding]*any
+	parent *evalScope
+}
+
+type evalClosure struct {
+	fn    *FuncExpr
+	scope *evalScope
+}
+
+type evalReturn struct{ vals []any }
+
+type evalBreak struct{}
+
+type evaluator struct {
+	globals map[string]any
+	trace   []string
+	steps   int
+}
+
+func (ev *evaluator) lookup(s *evalScope, n *NameExpr) *any {
+	for c := s; c != nil; c = c.parent {
+		if v, ok := c.vars[n.Binding]; ok && n.Binding != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func (ev *evaluator) get(s *evalScope, n *NameExpr) any {
+	if p := ev.lookup(s, n); p != nil {
+		return *p
+	}
+	return ev.globals[n.Name]
+}
+
+func (ev *evaluator) set(s *evalScope, n *NameExpr, v any) {
+	if p := ev.lookup(s, n); p != nil {
+		*p = v
+		return
+	}
+	ev.globals[n.Name] = v
+}
+
+func declare(s *evalScope, n *NameExpr, v any) {
+	s.vars[n.Binding] = &v
+}
+
+func truthy(v any) bool {
+	b, isBool := v.(bool)
+	return v != nil && (!isBool || b)
+}
+
+func (ev *evaluator) block(stats []Stat, parent *evalScope) any {
+	s := &evalScope{vars: map[*Binding]*any{}, parent: parent}
+	for _, st := range stats {
+		if ev.steps++; ev.steps > 100000 {
+			panic("step limit")
+		}
+		if r := ev.stat(st, s); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// stat runs one statement and returns an evalReturn or evalBreak to unwind.
+func (ev *evaluator) stat(st Stat, s *evalScope) any {
+	switch v := st.(type) {
+	case *LocalStat:
+		vals := ev.exprs(v.Values, s)
+		for i, n := range v.Names {
+			declare(s, n, at(vals, i))
+		}
+	case *LocalFuncStat:
+		declare(s, v.Name, nil)
+		ev.set(s, v.Name, &evalClosure{v.Func, s})
+	case *AssignStat:
+		vals := ev.exprs(v.Values, s)
+		for i, t := range v.Targets {
+			ev.set(s, t.(*NameExpr), at(vals, i))
+		}
+	case *CallStat:
+		ev.expr(v.Call, s)
+	case *DoStat:
+		return ev.block(v.Body, s)
+	case *IfStat:
+		for i, c := range v.Conds {
+			if truthy(ev.expr(c, s)) {
+				return ev.block(v.Blocks[i], s)
+			}
+		}
+		if v.HasElse {
+			return ev.block(v.Else, s)
+		}
+	case *WhileStat:
+		for truthy(ev.expr(v.Cond, s)) {
+			r := ev.block(v.Body, s)
+			if _, ok := r.(evalBreak); ok {
+				break
+			}
+			if r != nil {
+				return r
+			}
+		}
+	case *NumericForStat:
+		start, stop := ev.expr(v.Start, s).(float64), ev.expr(v.Stop, s).(float64)
+		for i := start; i <= stop; i++ {
+			inner := &evalScope{vars: map[*Binding]*any{}, parent: s}
+			declare(inner, v.Var, i)
+			r := ev.block(v.Body, inner)
+			if _, ok := r.(evalBreak); ok {
+				break
+			}
+			if r != nil {
+				return r
+			}
+		}
+	case *ReturnStat:
+		return evalReturn{ev.exprs(v.Values, s)}
+	case *BreakStat:
+		return evalBreak{}
+	default:
+		panic(fmt.Sprintf("eval: unsupported statement %T", st))
+	}
+	return nil
+}
+
+func at(vals []any, i int) any {
+	if i < len(vals) {
+		return vals[i]
+	}
+	return nil
+}
+
+func (ev *evaluator) exprs(xs []Expr, s *evalScope) []any {
+	var out []any
+	for i, x := range xs {
+		if call, ok := x.(*CallExpr); ok && i == len(xs)-1 {
+			return append(out, ev.call(call, s)...)
+		}
+		out = append(out, ev.expr(x, s))
+	}
+	return out
+}
+
+func (ev *evaluator) expr(x Expr, s *evalScope) any {
+	switch v := x.(type) {
+	case *NumberExpr:
+		n, _ := strconv.ParseFloat(v.Text, 64)
+		return n
+	case *StringExpr:
+		str, _ := unquoteLuaString(v.Text)
+		return str
+	case *BoolExpr:
+		return v.Val
+	case *NilExpr:
+		return nil
+	case *NameExpr:
+		return ev.get(s, v)
+	case *ParenExpr:
+		return ev.expr(v.E, s)
+	case *FuncExpr:
+		return &evalClosure{v, s}
+	case *TableExpr:
+		var items []any
+		for _, f := range v.Fields {
+			items = append(items, ev.expr(f.Value, s))
+		}
+		return &items
+	case *CallExpr:
+		return at(ev.call(v, s), 0)
+	case *UnExpr:
+		e := ev.expr(v.E, s)
+		if v.Op == "not" {
+			return !truthy(e)
+		}
+		return -e.(float64)
+	case *BinExpr:
+		switch v.Op {
+		case "and":
+			if l := ev.expr(v.L, s); !truthy(l) {
+				return l
+			}
+			return ev.expr(v.R, s)
+		case "or":
+			if l := ev.expr(v.L, s); truthy(l) {
+				return l
+			}
+			return ev.expr(v.R, s)
+		}
+		l, r := ev.expr(v.L, s), ev.expr(v.R, s)
+		switch v.Op {
+		case "==":
+			return l == r
+		case "~=":
+			return l != r
+		case "..":
+			return fmt.Sprint(l) + fmt.Sprint(r)
+		}
+		a, b := l.(float64), r.(float64)
+		switch v.Op {
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		case "/":
+			return a / b
+		case "%":
+			return a - math.Floor(a/b)*b
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		}
+	}
+	panic(fmt.Sprintf("eval: unsupported expression %T", x))
+}
+
+func (ev *evaluator) call(v *CallExpr, s *evalScope) []any {
+	if n, ok := v.Fn.(*NameExpr); ok && n.Name == "emit" && n.Binding == nil {
+		for _, a := range ev.exprs(v.Args, s) {
+			ev.trace = append(ev.trace, fmt.Sprint(a))
+		}
+		return nil
+	}
+	cl := ev.expr(v.Fn, s).(*evalClosure)
+	args := ev.exprs(v.Args, s)
+	inner := &evalScope{vars: map[*Binding]*any{}, parent: cl.scope}
+	for i, p := range cl.fn.Params {
+		declare(inner, p, at(args, i))
+	}
+	if r, ok := ev.block(cl.fn.Body, inner).(evalReturn); ok {
+		return r.vals
+	}
+	return nil
+}
+
+func runTrace(t *testing.T, c *Chunk) string {
+	t.Helper()
+	ev := &evaluator{globals: map[string]any{}}
+	ev.block(c.Body, nil)
+	return strings.Join(ev.trace, " ")
+}
+
+func parseRenamed(t *testing.T, src string) *Chunk {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	Rename(c, nil)
+	return c
+}
+
+var flattenPrograms = map[string]string{
+	"sequence": `local a = 1 local b = a + 1 emit(a, b) local c = a * b emit(c) return c`,
+	"branches": `
+		local function classify(n)
+			local kind
+			if n < 0 then kind = "neg" elseif n == 0 then kind = "zero" elseif n < 10 then
+				local big = false kind = "small" emit(big)
+			else kind = "big" end
+			emit(kind)
+			return kind
+		end
+		classify(-1) classify(0) classify(5) classify(50)`,
+	"early return": `
+		local function f(x)
+			emit("in")
+			if x > 1 then return x * 2 end
+			do local y = x + 1 emit(y) end
+			return -x
+		end
+		emit(f(1), f(3))`,
+	"loops": `
+		local total = 0
+		for i = 1, 10 do
+			if i == 7 then break end
+			total = total + i
+		end
+		local n = 0
+		while true do n = n + 1 if n > 3 then break end emit(n) end
+		emit(total)`,
+	"closures": `
+		local function counter()
+			local count = 0
+			local function inc() count = count + 1 return count end
+			return inc
+		end
+		local c1, c2 = counter(), counter()
+		c1() c1()
+		emit(c1(), c2())
+		local acc = 0
+		for i = 1, 3 do
+			local j = i * 10
+			local add = function() acc = acc + j end
+			add()
+		end
+		emit(acc)`,
+	"recursion": `
+		local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end
+		emit(fib(10))`,
+}
+
+func TestFlatten_PreservesBehaviour(t *testing.T) {
+	for name, src := range flattenPrograms {
+		want := runTrace(t, parseRenamed(t, src))
+		for range 5 { // random arm order, ids and predicates
+			c := parseRenamed(t, src)
+			if Flatten(c) == 0 {
+				t.Fatalf("%s: nothing flattened", name)
+			}
+			if _, err := Parse(c.Print()); err != nil {
+				t.Fatalf("%s: flattened output does not parse: %v\n%s", name, err, c.Print())
+			}
+			if got := runTrace(t, c); got != want {
+				t.Fatalf("%s: trace %q, want %q\n%s", name, got, want, c.Print())
+			}
+		}
+	}
+}
+
+func TestFlatten_DispatchLoop(t *testing.T) {
+	c := parseRenamed(t, `local a = 1 emit(a) local b = 2 emit(b) if a < b then emit("lt") end return b`)
+	Flatten(c)
+	out := c.Print()
+	if len(c.Body) != 3 {
+		t.Fatalf("want hoisted locals, state local and loop, got %d statements:\n%s", len(c.Body), out)
+	}
+	if _, ok := c.Body[2].(*WhileStat); !ok {
+		t.Fatalf("expected a dispatch loop: %s", out)
+	}
+	if strings.Contains(out, "local a") || strings.Count(out, "local ") != 2 {
+		t.Fatalf("block locals must be hoisted: %s", out)
+	}
+}
+
+func TestFlatten_SkipsUnsafeFunctions(t *testing.T) {
+	for _, src := range []string{
+		"local a = 1 emit(a) ::top:: emit(a) return a", // label outside loops
+		"emit(1)", // a single arm
+	} {
+		c, err := Parse(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		Rename(c, nil)
+		before := c.Print()
+		if n := Flatten(c); n != 0 || c.Print() != before {
+			t.Fatalf("%q must not be flattened, got:\n%s", src, c.Print())
+		}
+	}
+}
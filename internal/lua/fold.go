@@ -0,0 +1,350 @@
+package lua
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// constKind classifies a compile-time constant value.
+type constKind int
+
+const (
+	constNil constKind = iota
+	constBool
+	constNumber
+	constString
+)
+
+// constant is the statically known value of an expression.
+type constant struct {
+	kind constKind
+	b    bool
+	n    float64
+	s    string
+}
+
+// truthy applies Lua truthiness: only nil and false are false.
+func (k constant) truthy() bool {
+	return !(k.kind == constNil || (k.kind == constBool && !k.b))
+}
+
+// constValue evaluates x if it is built only from literals and operators with
+// no side effects (not, and, or, comparisons, arithmetic, .., #). ok is false
+// when the value depends on anything known only at run time.
+func constValue(x Expr) (constant, bool) {
+	switch v := x.(type) {
+	case *NilExpr:
+		return constant{kind: constNil}, true
+	case *BoolExpr:
+		return constant{kind: constBool, b: v.Val}, true
+	case *NumberExpr:
+		n, ok := parseNumber(v.Text)
+		return constant{kind: constNumber, n: n}, ok
+	case *StringExpr:
+		s, ok := unquoteLuaString(v.Text)
+		return constant{kind: constString, s: s}, ok
+	case *ParenExpr:
+		return constValue(v.E)
+	case *UnExpr:
+		e, ok := constValue(v.E)
+		if !ok {
+			return constant{}, false
+		}
+		switch v.Op {
+		case "not":
+			return constant{kind: constBool, b: !e.truthy()}, true
+		case "-":
+			if e.kind == constNumber {
+				return constant{kind: constNumber, n: -e.n}, true
+			}
+		case "#":
+			if e.kind == constString {
+				return constant{kind: constNumber, n: float64(len(e.s))}, true
+			}
+		}
+		return constant{}, false
+	case *BinExpr:
+		return constBinary(v)
+	case *IfExpr:
+		conds := append([]Expr{v.Cond}, v.ElifConds...)
+		thens := append([]Expr{v.Then}, v.ElifThen...)
+		for i, c := range conds {
+			k, ok := constValue(c)
+			if !ok {
+				return constant{}, false
+			}
+			if k.truthy() {
+				return constValue(thens[i])
+			}
+		}
+		return constValue(v.Else)
+	}
+	return constant{}, false
+}
+
+func constBinary(v *BinExpr) (constant, bool) {
+	l, ok := constValue(v.L)
+	if !ok {
+		return constant{}, false
+	}
+	// and/or short-circuit: the right side only matters if it is reached.
+	switch v.Op {
+	case "and":
+		if !l.truthy() {
+			return l, true
+		}
+		return constValue(v.R)
+	case "or":
+		if l.truthy() {
+			return l, true
+		}
+		return constValue(v.R)
+	}
+	r, ok := constValue(v.R)
+	if !ok {
+		return constant{}, false
+	}
+	switch v.Op {
+	case "==":
+		return constant{kind: constBool, b: l == r}, true
+	case "~=":
+		return constant{kind: constBool, b: l != r}, true
+	case "<", "<=", ">", ">=":
+		var cmp int
+		switch {
+		case l.kind == constNumber && r.kind == constNumber:
+			cmp = compareFloat(l.n, r.n)
+		case l.kind == constString && r.kind == constString:
+			cmp = strings.Compare(l.s, r.s)
+		default:
+			return constant{}, false // run-time error in Lua; leave it alone
+		}
+		var res bool
+		switch v.Op {
+		case "<":
+			res = cmp < 0
+		case "<=":
+			res = cmp <= 0
+		case ">":
+			res = cmp > 0
+		default:
+			res = cmp >= 0
+		}
+		return constant{kind: constBool, b: res}, true
+	case "+", "-", "*", "/":
+		if l.kind != constNumber || r.kind != constNumber {
+			return constant{}, false
+		}
+		var n float64
+		switch v.Op {
+		case "+":
+			n = l.n + r.n
+		case "-":
+			n = l.n - r.n
+		case "*":
+			n = l.n * r.n
+		default:
+			if r.n == 0 {
+				return constant{}, false
+			}
+			n = l.n / r.n
+		}
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return constant{}, false // no Lua literal spells it
+		}
+		return constant{kind: constNumber, n: n}, true
+	case "..":
+		ls, lok := concatOperand(l)
+		rs, rok := concatOperand(r)
+		if !lok || !rok {
+			return constant{}, false
+		}
+		return constant{kind: constString, s: ls + rs}, true
+	}
+	return constant{}, false
+}
+
+// maxConcatInteger bounds the integers concatOperand formats: Lua prints
+// numbers with %.14g, which switches to an exponent from 1e14 on.
+const maxConcatInteger = 1e14
+
+// concatOperand is the string form of a constant in a .. expression. Only
+// strings and integers Lua prints as plain digits are accepted: float
+// formatting differs between Lua versions, and -0 prints as "-0".
+func concatOperand(k constant) (string, bool) {
+	switch {
+	case k.kind == constString:
+		return k.s, true
+	case k.kind == constNumber && k.n == math.Trunc(k.n) && math.Abs(k.n) < maxConcatInteger &&
+		!(k.n == 0 && math.Signbit(k.n)):
+		return strconv.FormatInt(int64(k.n), 10), true
+	}
+	return "", false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseNumber decodes a Lua/Luau numeric literal (decimal, hex, Luau 0b
+// binary, and _ digit separators). A leading zero does not make a literal
+// octal: Lua reads 010 as 10.
+func parseNumber(text string) (float64, bool) {
+	text = strings.ReplaceAll(text, "_", "")
+	if len(text) > 2 && text[0] == '0' {
+		base := 0
+		switch text[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 0 {
+			if i, err := strconv.ParseUint(text[2:], base, 64); err == nil {
+				return float64(i), true
+			}
+		}
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, true
+	}
+	return 0, false
+}
+
+// UnreachableStrings returns the values of string literals in c that occur
+// only in code that can never run: branches of if/elseif/while and if-
+// expressions whose condition folds to a constant, and the short-circuited
+// side of and/or. A literal that also appears in live code is not included.
+// The bundler uses it to skip require paths and URLs in dead branches.
+func UnreachableStrings(c *Chunk) map[string]bool {
+	r := &reachability{live: map[string]bool{}, dead: map[string]bool{}}
+	r.visit(c, false)
+	out := map[string]bool{}
+	for s := range r.dead {
+		if !r.live[s] {
+			out[s] = true
+		}
+	}
+	return out
+}
+
+type reachability struct{ live, dead map[string]bool }
+
+func (r *reachability) visit(n Node, dead bool) {
+	Inspect(n, func(m Node) bool {
+		switch v := m.(type) {
+		case *StringExpr:
+			if s, ok := unquoteLuaString(v.Text); ok {
+				if dead {
+					r.dead[s] = true
+				} else {
+					r.live[s] = true
+				}
+			}
+		case *IfStat:
+			taken := false // an earlier branch is statically always taken
+			for i, cond := range v.Conds {
+				r.visit(cond, dead || taken)
+				k, known := constValue(cond)
+				r.visitBlock(v.Blocks[i], dead || taken || (known && !k.truthy()))
+				if known && k.truthy() {
+					taken = true
+				}
+			}
+			if v.HasElse {
+				r.visitBlock(v.Else, dead || taken)
+			}
+			return false
+		case *WhileStat:
+			r.visit(v.Cond, dead)
+			k, known := constValue(v.Cond)
+			r.visitBlock(v.Body, dead || (known && !k.truthy()))
+			return false
+		case *BinExpr:
+			if v.Op != "and" && v.Op != "or" {
+				return true
+			}
+			r.visit(v.L, dead)
+			k, known := constValue(v.L)
+			skipped := known && (k.truthy() == (v.Op == "or"))
+			r.visit(v.R, dead || skipped)
+			return false
+		case *IfExpr:
+			conds := append([]Expr{v.Cond}, v.ElifConds...)
+			thens := append([]Expr{v.Then}, v.ElifThen...)
+			taken := false
+			for i, cond := range conds {
+				r.visit(cond, dead || taken)
+				k, known := constValue(cond)
+				r.visit(thens[i], dead || taken || (known && !k.truthy()))
+				if known && k.truthy() {
+					taken = true
+				}
+			}
+			r.visit(v.Else, dead || taken)
+			return false
+		}
+		return true
+	})
+}
+
+func (r *reachability) visitBlock(stats []Stat, dead bool) {
+	for _, s := range stats {
+		r.visit(s, dead)
+	}
+}
+
+// StringConstants returns the locals in c that are bound to a string literal
+// and never reassigned, by name. A name declared more than once with
+// different values is left out, since which one a use refers to depends on
+// scope. The bundler uses it to follow URLs held in local constants.
+func StringConstants(c *Chunk) map[string]string {
+	resolve(c)
+	values := map[*Binding]string{}
+	reassigned := map[*Binding]bool{}
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *LocalStat:
+			for i, name := range v.Names {
+				if i >= len(v.Values) {
+					break
+				}
+				if str, ok := v.Values[i].(*StringExpr); ok {
+					if s, ok := unquoteLuaString(str.Text); ok {
+						values[name.Binding] = s
+					}
+				}
+			}
+		case *AssignStat:
+			for _, t := range v.Targets {
+				if name, ok := t.(*NameExpr); ok && name.Binding != nil {
+					reassigned[name.Binding] = true
+				}
+			}
+		}
+		return true
+	})
+	out := map[string]string{}
+	ambiguous := map[string]bool{}
+	for b, s := range values {
+		if reassigned[b] {
+			ambiguous[b.OrigName] = true
+			continue
+		}
+		if prev, seen := out[b.OrigName]; seen && prev != s {
+			ambiguous[b.OrigName] = true
+		}
+		out[b.OrigName] = s
+	}
+	for name := range ambiguous {
+		delete(out, name)
+	}
+	return out
+}
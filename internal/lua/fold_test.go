@@ -0,0 +1,77 @@
+package lua
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConstValue(t *testing.T) {
+	cases := map[string]bool{ // expression -> truthiness (all foldable)
+		"false":                    false,
+		"nil":                      false,
+		"0":                        true,
+		`""`:                       true,
+		"not false":                true,
+		"false and x":              false,
+		"true or x":                true,
+		"1 + 1 == 2":               true,
+		`"a" .. 1 == "a1"`:         true,
+		"0x10 > 15":                true,
+		`#"abc" ~= 3`:              false,
+		"if false then 1 else nil": false,
+	}
+	for src, want := range cases {
+		c, err := Parse("return " + src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		k, ok := constValue(c.Body[0].(*ReturnStat).Values[0])
+		if !ok || k.truthy() != want {
+			t.Errorf("constValue(%s) = (%+v, %v), want truthy=%v", src, k, ok, want)
+		}
+	}
+	for _, src := range []string{"x", "false or x", "f()", `1 < "a"`, "1 / 0", "1e308 * 10", "-1e308 - 1e308",
+		`1e15 .. ""`, `1e14 .. ""`, `-0 .. ""`, `1.5 .. ""`} {
+		c, _ := Parse("return " + src)
+		if _, ok := constValue(c.Body[0].(*ReturnStat).Values[0]); ok {
+			t.Errorf("constValue(%s) should not fold", src)
+		}
+	}
+}
+
+func TestUnreachableStrings(t *testing.T) {
+	c, err := Parse(`
+if false then require("./devtools") end
+if true then require("./live") else require("./never") end
+if x then require("./maybe") elseif true then require("./b") else require("./c") end
+while false do require("./loop") end
+local m = false and require("./and")
+local n = true or require("./or")
+local o = nil or require("./or_live")
+if false then require("./shared") end
+require("./shared")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"./devtools": true, "./never": true, "./c": true, "./loop": true, "./and": true, "./or": true}
+	if got := UnreachableStrings(c); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UnreachableStrings = %v, want %v", got, want)
+	}
+}
+
+func TestStringConstants(t *testing.T) {
+	c, err := Parse(`local URL = "https://x/a.lua"
+local other, n = "o", 1
+local moved = "a" moved = "b"
+local dup = "1"
+do local dup = "2" end
+local same = "s"
+do local same = "s" end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"URL": "https://x/a.lua", "other": "o", "same": "s"}
+	if got := StringConstants(c); !reflect.DeepEqual(got, want) {
+		t.Fatalf("StringConstants = %v, want %v", got, want)
+	}
+}
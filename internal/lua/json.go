@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var identRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// FromJSON converts a JSON document into the equivalent Lua expression:
+// objects and arrays become table constructors (object keys keep their
+// order), strings, numbers and booleans become literals and null becomes nil.
+// Note that a nil array element leaves a hole and a nil object value omits
+// the key, as in any Lua table.
+func FromJSON(data []byte) (Expr, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	x, err := jsonValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level JSON value")
+	}
+	return x, nil
+}
+
+func jsonValue(dec *json.Decoder) (Expr, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		t := &TableExpr{}
+		for dec.More() {
+			var field TableField
+			if v == '{' {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyTok.(string)
+				if identRegex.MatchString(key) && !luaKeywords[key] {
+					field.KeyName = key
+				} else {
+					field.Key = StringLiteral(key)
+				}
+			}
+			if field.Value, err = jsonValue(dec); err != nil {
+				return nil, err
+			}
+			t.Fields = append(t.Fields, field)
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return nil, err
+		}
+		return t, nil
+	case string:
+		return StringLiteral(v), nil
+	case json.Number:
+		if text, ok := strings.CutPrefix(v.String(), "-"); ok {
+			return &UnExpr{Op: "-", E: &NumberExpr{Text: text}}, nil
+		}
+		return &NumberExpr{Text: v.String()}, nil
+	case bool:
+		return &BoolExpr{Val: v}, nil
+	}
+	return &NilExpr{}, nil
+}
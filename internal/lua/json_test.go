@@ -0,0 +1,26 @@
+package lua
+
+import "testing"
+
+func TestFromJSON(t *testing.T) {
+	cases := map[string]string{
+		`{"name": "x", "end": true, "a-b": null, "n": -1.5e3}`: `return{name="x",["end"]=true,["a-b"]=nil,n=-1.5e3}`,
+		`[1, "two", [3], {}]`: `return{1,"two",{3},{}}`,
+		`"line\nbreak \"q\""`: `return"line\010break \"q\""`,
+	}
+	for in, want := range cases {
+		x, err := FromJSON([]byte(in))
+		if err != nil {
+			t.Fatalf("FromJSON(%s): %v", in, err)
+		}
+		c := &Chunk{Body: []Stat{&ReturnStat{Values: []Expr{x}}}}
+		if got := c.Print(); got != want {
+			t.Errorf("FromJSON(%s) = %q, want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{`{"a":}`, `[1] [2]`, ``} {
+		if _, err := FromJSON([]byte(bad)); err == nil {
+			t.Errorf("FromJSON(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package lua
+
+// maxFunctionLocals is Lua 5.1's limit on the active locals of a function.
+// Junk keeps each function junkLocalsReserve below it, counting every local
+// the function declares as active, which leaves room for the locals later
+// passes add (BracketFields' helper, Flatten's state).
+const (
+	maxFunctionLocals = 200
+	junkLocalsReserve = 8
+)
+
+// maxSnippetLocals is the most locals one snippet declares in its function.
+const maxSnippetLocals = 2
+
+// junkWords are the string literals junk code uses; they read like the
+// names and messages of real scripts.
+var junkWords = []string{
+	"Enabled", "Loaded", "Changed", "Heartbeat", "Value", "update", "init",
+	"Parent", "Character", "Humanoid", "state", "config", "ready", "render",
+	"Position", "cooldown", "retry", "callback", "Visible", "Size",
+}
+
+// Junk inserts dead but plausible code before the statements of the chunk
+// and of its functions, each with probability density (0 to 1), so a block
+// gets about density times its length in snippets, for as long as its
+// function stays under maxFunctionLocals: unused locals holding numbers,
+// strings or tables, local functions that are never called, and branches
+// that never run behind opaque predicates on a junk local. Junk code reads
+// no globals, has no effects and declares only fresh names shaped like the
+// renamer's (carrying w's mark; w may be nil), so it runs after Rename and
+// HoistGlobals; Flatten, HideNumbers and EncryptStrings then treat it like
+// the rest of the code. Blocks with labels are skipped, since a local
+// between a goto and its label is an error. It returns the number of
+// snippets inserted.
+func Junk(c *Chunk, density float64, w *Watermark) int {
+	if density <= 0 {
+		return 0
+	}
+	j := &junker{density: density, mark: w, taken: map[string]bool{}}
+	Inspect(c, func(n Node) bool {
+		if v, ok := n.(*NameExpr); ok {
+			j.taken[v.Name] = true
+			if v.Binding != nil && v.Binding.NewName != "" {
+				j.taken[v.Binding.NewName] = true
+			}
+		}
+		return true
+	})
+	return j.function(&c.Body)
+}
+
+type junker struct {
+	density float64
+	mark    *Watermark
+	taken   map[string]bool
+	ints    []*Binding // integer junk locals visible at the insertion point
+	budget  int        // locals the current function can still declare
+}
+
+// function inserts junk into body, the body of the chunk or of a function,
+// and then into the functions nested in it, and returns the number of
+// snippets inserted.
+func (j *junker) function(body *[]Stat) int {
+	blocks := []*[]Stat{body}
+	var funcs []*FuncExpr
+	declared := 0
+	for _, s := range *body {
+		Inspect(s, func(n Node) bool {
+			switch v := n.(type) {
+			case *FuncExpr:
+				funcs = append(funcs, v)
+				return false
+			case *DoStat:
+				blocks = append(blocks, &v.Body)
+			case *WhileStat:
+				blocks = append(blocks, &v.Body)
+			case *RepeatStat:
+				blocks = append(blocks, &v.Body)
+			case *NumericForStat:
+				blocks = append(blocks, &v.Body)
+			case *GenericForStat:
+				blocks = append(blocks, &v.Body)
+			case *IfStat:
+				for i := range v.Blocks {
+					blocks = append(blocks, &v.Blocks[i])
+				}
+				if v.HasElse {
+					blocks = append(blocks, &v.Else)
+				}
+			}
+			declared += localsDeclared(n)
+			return true
+		})
+	}
+	j.budget = maxFunctionLocals - junkLocalsReserve - declared
+	count := 0
+	for _, block := range blocks {
+		*block, count = j.block(*block, count)
+	}
+	for _, f := range funcs {
+		count += j.function(&f.Body)
+	}
+	return count
+}
+
+// localsDeclared returns the locals statement n declares in its function,
+// counting the three hidden ones of a for loop as Lua 5.1 does.
+func localsDeclared(n Node) int {
+	switch v := n.(type) {
+	case *LocalStat:
+		return len(v.Names)
+	case *LocalFuncStat:
+		return 1
+	case *NumericForStat:
+		return 4
+	case *GenericForStat:
+		return 3 + len(v.Vars)
+	}
+	return 0
+}
+
+// block returns stats with junk inserted before some of its statements.
+// Nothing is appended after the last one, which may be a return or break.
+func (j *junker) block(stats []Stat, count int) ([]Stat, int) {
+	for _, s := range stats {
+		if _, ok := s.(*LabelStat); ok {
+			return stats, count
+		}
+	}
+	j.ints = nil
+	out := make([]Stat, 0, len(stats))
+	for _, s := range stats {
+		if j.budget >= maxSnippetLocals && float64(randomInt(1<<20))/(1<<20) < j.density {
+			snippet := j.snippet()
+			for _, js := range snippet {
+				Inspect(js, func(n Node) bool {
+					j.budget -= localsDeclared(n)
+					_, fn := n.(*FuncExpr)
+					return !fn
+				})
+			}
+			out = append(out, snippet...)
+			count++
+		}
+		out = append(out, s)
+	}
+	return out, count
+}
+
+// snippet returns one piece of junk: the first in a block declares the
+// integer local later dead branches test.
+func (j *junker) snippet() []Stat {
+	if len(j.ints) == 0 {
+		x := j.local()
+		j.ints = append(j.ints, x.Binding)
+		decl := &LocalStat{Names: []*NameExpr{x}, Values: []Expr{number(1 + randomInt(maxStateID-1))}}
+		if randomInt(2) == 0 {
+			return []Stat{decl}
+		}
+		return []Stat{decl, j.deadBranch()}
+	}
+	switch randomInt(3) {
+	case 0:
+		return []Stat{j.deadBranch()}
+	case 1:
+		return []Stat{j.unusedLocal()}
+	default:
+		return []Stat{j.fakeFunction()}
+	}
+}
+
+// deadBranch returns `if <never true> then ... end`, its body updating an
+// integer junk local as real code would.
+func (j *junker) deadBranch() Stat {
+	x := j.ints[randomInt(len(j.ints))]
+	pred, holds := opaquePredicate(func() Expr { return ref(x) })
+	for holds {
+		pred, holds = opaquePredicate(func() Expr { return ref(x) })
+	}
+	body := []Stat{
+		&AssignStat{Targets: []Expr{ref(x)}, Op: "=", Values: []Expr{j.arith(ref(x))}},
+	}
+	if randomInt(2) == 0 {
+		y := j.local()
+		body = append([]Stat{&LocalStat{Names: []*NameExpr{y}, Values: []Expr{j.arith(ref(x))}}},
+			&AssignStat{Targets: []Expr{ref(x)}, Op: "=", Values: []Expr{&BinExpr{Op: "-", L: ref(x), R: ref(y.Binding)}}})
+	}
+	return &IfStat{Conds: []Expr{pred}, Blocks: [][]Stat{body}}
+}
+
+// unusedLocal returns a local holding a string, a small table or arithmetic
+// on an integer junk local.
+func (j *junker) unusedLocal() Stat {
+	var value Expr
+	switch randomInt(3) {
+	case 0:
+		value = StringLiteral(junkWords[randomInt(len(junkWords))])
+	case 1:
+		t := &TableExpr{}
+		for range 1 + randomInt(3) {
+			t.Fields = append(t.Fields, TableField{Value: number(randomInt(256))})
+		}
+		value = t
+	default:
+		value = j.arith(ref(j.ints[randomInt(len(j.ints))]))
+	}
+	return &LocalStat{Names: []*NameExpr{j.local()}, Values: []Expr{value}}
+}
+
+// fakeFunction returns a local function of two parameters that is never
+// called: some arithmetic, an early return and a result.
+func (j *junker) fakeFunction() Stat {
+	a, b, c := j.local(), j.local(), j.local()
+	body := []Stat{
+		&LocalStat{Names: []*NameExpr{c}, Values: []Expr{&BinExpr{Op: "+", L: j.arith(ref(a.Binding)), R: ref(b.Binding)}}},
+	}
+	if randomInt(2) == 0 {
+		i := j.local()
+		body = append(body, &NumericForStat{Var: i, Start: number(1), Stop: ref(b.Binding), Body: []Stat{
+			&AssignStat{Targets: []Expr{ref(c.Binding)}, Op: "=", Values: []Expr{&BinExpr{Op: "+", L: ref(c.Binding), R: ref(i.Binding)}}},
+		}})
+	}
+	body = append(body,
+		&IfStat{Conds: []Expr{&BinExpr{Op: ">", L: ref(c.Binding), R: number(randomInt(1 << 12))}},
+			Blocks: [][]Stat{{&ReturnStat{Values: []Expr{j.arith(ref(c.Binding))}}}}},
+		&ReturnStat{Values: []Expr{ref(c.Binding)}},
+	)
+	return &LocalFuncStat{Name: j.local(), Func: &FuncExpr{Params: []*NameExpr{a, b}, Body: body}}
+}
+
+// arith returns x * k + m, x % k or x - m for small random k and m.
+func (j *junker) arith(x Expr) Expr {
+	k, m := number(2+randomInt(97)), number(1+randomInt(1<<10))
+	switch randomInt(3) {
+	case 0:
+		return &BinExpr{Op: "+", L: &BinExpr{Op: "*", L: x, R: k}, R: m}
+	case 1:
+		return &BinExpr{Op: "%", L: x, R: k}
+	default:
+		return &BinExpr{Op: "-", L: x, R: m}
+	}
+}
+
+// local returns the declaration of a fresh junk local.
+func (j *junker) local() *NameExpr {
+	name := j.mark.name()
+	for j.taken[name] {
+		name = j.mark.name()
+	}
+	j.taken[name] = true
+	return &NameExpr{Name: name, Binding: &Binding{OrigName: name, NewName: name}}
+}
+
+func ref(b *Binding) *NameExpr {
+	return &NameExpr{Name: b.OrigName, Binding: b}
+}
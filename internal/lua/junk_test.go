@@ -0,0 +1,116 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJunk_PreservesBehaviour(t *testing.T) {
+	for name, src := range flattenPrograms {
+		want := runTrace(t, parseRenamed(t, src))
+		for range 5 {
+			c := parseRenamed(t, src)
+			if Junk(c, 1, nil) == 0 {
+				t.Fatalf("%s: no junk inserted", name)
+			}
+			out := c.Print()
+			if _, err := Parse(out); err != nil {
+				t.Fatalf("%s: output does not parse: %v\n%s", name, err, out)
+			}
+			if got := runTrace(t, c); got != want {
+				t.Fatalf("%s: trace %q, want %q\n%s", name, got, want, out)
+			}
+			// Junk locals are renamed-style, so Flatten can still hoist them.
+			if Flatten(c) == 0 {
+				t.Fatalf("%s: junk stopped Flatten:\n%s", name, out)
+			}
+			if got := runTrace(t, c); got != want {
+				t.Fatalf("%s: flattened trace %q, want %q\n%s", name, got, want, c.Print())
+			}
+		}
+	}
+}
+
+func TestJunk_Density(t *testing.T) {
+	src := flattenPrograms["closures"]
+	c := parseRenamed(t, src)
+	before := c.Print()
+	if n := Junk(c, 0, nil); n != 0 || c.Print() != before {
+		t.Fatalf("density 0 must not change the chunk, inserted %d", n)
+	}
+	sparse, dense := 0, 0
+	for range 20 {
+		sparse += Junk(parseRenamed(t, src), 0.2, nil)
+		dense += Junk(parseRenamed(t, src), 1, nil)
+	}
+	if sparse >= dense {
+		t.Fatalf("density 0.2 inserted %d snippets, density 1 only %d", sparse, dense)
+	}
+}
+
+func TestJunk_ScalesWithBlockLength(t *testing.T) {
+	var src strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&src, "emit(%d)\n", i)
+	}
+	if n := Junk(parseRenamed(t, src.String()), 1, nil); n != 100 {
+		t.Fatalf("density 1 must put junk before every statement, inserted %d", n)
+	}
+	if n := Junk(parseRenamed(t, src.String()), 0.1, nil); n > 50 {
+		t.Fatalf("density 0.1 inserted %d snippets in 100 statements", n)
+	}
+}
+
+func TestJunk_StaysUnderLocalsLimit(t *testing.T) {
+	var src strings.Builder
+	for i := range 150 {
+		fmt.Fprintf(&src, "local v%d = emit(%d)\n", i, i)
+	}
+	for i := range 150 {
+		fmt.Fprintf(&src, "emit(v%d)\n", i)
+	}
+	c := parseRenamed(t, src.String())
+	if Junk(c, 1, nil) == 0 {
+		t.Fatal("no junk inserted")
+	}
+	locals := 0
+	for _, s := range c.Body {
+		Inspect(s, func(n Node) bool {
+			locals += localsDeclared(n)
+			_, fn := n.(*FuncExpr)
+			return !fn
+		})
+	}
+	if locals > maxFunctionLocals-junkLocalsReserve {
+		t.Fatalf("the chunk declares %d locals", locals)
+	}
+}
+
+func TestJunk_Placement(t *testing.T) {
+	c := parseRenamed(t, `local a = 1 ::top:: a = a + 1 if a < 3 then goto top end
+		local function f() emit(a) return a end
+		return f()`)
+	Junk(c, 1, nil)
+	if _, ok := c.Body[0].(*LocalStat); !ok || len(c.Body) != 6 {
+		t.Fatalf("a block with a label must be left alone:\n%s", c.Print())
+	}
+	f := c.Body[4].(*LocalFuncStat).Func.Body
+	if _, ok := f[len(f)-1].(*ReturnStat); !ok || len(f) <= 2 {
+		t.Fatalf("junk goes before statements, never after a return:\n%s", c.Print())
+	}
+}
+
+func TestJunk_EncryptedLikeOtherCode(t *testing.T) {
+	for range 10 {
+		c := parseRenamed(t, flattenPrograms["loops"])
+		Junk(c, 1, nil)
+		EncryptStrings(c, testKey, "_d", nil)
+		out := c.Print()
+		for _, word := range junkWords {
+			if strings.Contains(out, `"`+word+`"`) {
+				t.Fatalf("junk string %q not encrypted:\n%s", word, out)
+			}
+		}
+	}
+}
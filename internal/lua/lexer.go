@@ -1,6 +1,9 @@
 package lua
 
-import "strings"
+import (
+	"slices"
+	"strings"
+)
 
 // tokenKind classifies a Lua lexical token.
 type tokenKind int
@@ -125,23 +128,49 @@ func scanLongBracket(src string, i, level int) int {
 	return len(src)
 }
 
-// scanBacktick consumes a Luau interpolated string `...` including nested {expr}
-// braces. Backslash escapes are skipped; the whole literal becomes one tkString token.
+// scanBacktick consumes a Luau interpolated string `...` including its {expr}
+// segments (see scanInterpExpr). Backslash escapes are skipped; the whole
+// literal becomes one tkString token, split by the parser (see parseInterp).
 func scanBacktick(src string, i int) int {
 	i++ // opening backtick
-	depth := 0
 	for i < len(src) {
 		switch src[i] {
 		case '\\':
 			i += 2
 			continue
 		case '{':
-			depth++
-		case '}':
-			if depth > 0 {
-				depth--
-			}
+			i = scanInterpExpr(src, i)
+			continue
 		case '`':
+			return i + 1
+		}
+		i++
+	}
+	return len(src)
+}
+
+// scanInterpExpr consumes an interpolation segment {expr} starting at its
+// '{' and returns the index just past the matching '}', skipping braces in
+// nested tables and in strings. Returns len(src) if the '}' is missing.
+func scanInterpExpr(src string, i int) int {
+	depth := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			i = scanShortString(src, i)
+			continue
+		case c == '`':
+			i = scanBacktick(src, i)
+			continue
+		case c == '[' && isLongBracketOpen(src, i):
+			level, _ := longBracketLevel(src, i)
+			i = scanLongBracket(src, i, level)
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
 			if depth == 0 {
 				return i + 1
 			}
@@ -224,3 +253,32 @@ func scanOp(src string, i int) int {
 	}
 	return i + 1
 }
+
+// TextRanges returns the byte ranges [start, end) of the string literals and
+// comments in src, in order. Code outside them is what actually runs; the
+// bundler uses this to tell a loadstring(...) call apart from the same text
+// inside a string passed to a runtime API.
+func TextRanges(src string) [][2]int {
+	return tokenRanges(src, tkString, tkComment)
+}
+
+// StringRanges returns the byte ranges [start, end) of the string literals in
+// src, quotes or long brackets included, in order.
+func StringRanges(src string) [][2]int {
+	return tokenRanges(src, tkString)
+}
+
+func tokenRanges(src string, kinds ...tokenKind) [][2]int {
+	var ranges [][2]int
+	pos := 0
+	for _, t := range lex(src) {
+		// Only whitespace separates tokens, so the next occurrence of the
+		// token's text is the token itself.
+		start := pos + strings.Index(src[pos:], t.text)
+		pos = start + len(t.text)
+		if slices.Contains(kinds, t.kind) {
+			ranges = append(ranges, [2]int{start, pos})
+		}
+	}
+	return ranges
+}
@@ -108,3 +108,20 @@ func TestLex(t *testing.T) {
 		})
 	}
 }
+
+func TestTextRanges(t *testing.T) {
+	src := "f(\"a(\") -- c\nx = [[s]] .. 'q'"
+	got := TextRanges(src)
+	want := [][2]int{{2, 6}, {8, 12}, {17, 22}, {26, 29}}
+	if len(got) != len(want) {
+		t.Fatalf("TextRanges = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("TextRanges = %v, want %v", got, want)
+		}
+		if s := src[got[i][0]:got[i][1]]; s[0] != '"' && s[0] != '-' && s[0] != '[' && s[0] != '\'' {
+			t.Fatalf("range %v covers %q", got[i], s)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package lua
+
+// instanceRoots are the globals whose fields are engine objects: Roblox
+// instances (whose children are read as fields), enums and the shared
+// global tables. Fields read through them are never mangled.
+var instanceRoots = map[string]bool{
+	"game": true, "workspace": true, "script": true, "plugin": true,
+	"Enum": true, "shared": true, "_G": true,
+}
+
+// FieldNames records the constant field names c uses: a.f, a:f(), a["f"],
+// { f = v } and { ["f"] = v }, and function a.f / a:f targets, including
+// those inside interpolated strings. Names read through a chain starting at
+// an instance root (game.Workspace._Map) also go to external, since renaming
+// them would change what they refer to.
+func FieldNames(c *Chunk, names, external map[string]bool) {
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *IndexExpr:
+			if name, ok := fieldName(v); ok {
+				names[name] = true
+				if root, ok := chainRoot(v.Obj).(*NameExpr); ok && root.Binding == nil && instanceRoots[root.Name] {
+					external[name] = true
+				}
+			}
+		case *TableExpr:
+			for _, f := range v.Fields {
+				if name, ok := tableKeyName(f); ok {
+					names[name] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+// chainRoot returns the expression an a.b:c().d chain starts from.
+func chainRoot(x Expr) Expr {
+	for {
+		switch v := x.(type) {
+		case *IndexExpr:
+			x = v.Obj
+		case *CallExpr:
+			x = v.Fn
+		case *ParenExpr:
+			x = v.E
+		default:
+			return x
+		}
+	}
+}
+
+// tableKeyName is the constant key of a table constructor field: f = v or
+// ["f"] = v.
+func tableKeyName(f TableField) (string, bool) {
+	if f.KeyName != "" {
+		return f.KeyName, true
+	}
+	if s, ok := f.Key.(*StringExpr); ok {
+		return unquoteLuaString(s.Text)
+	}
+	return "", false
+}
+
+// ExportedFields returns the field names of the table c returns, for the
+// shapes `return { ... }` and `local M = { ... } ... return M` (including
+// fields assigned as M.f = v or function M.f). It returns nil for any other
+// shape.
+func ExportedFields(c *Chunk) []string {
+	resolve(c)
+	if len(c.Body) == 0 {
+		return nil
+	}
+	ret, ok := c.Body[len(c.Body)-1].(*ReturnStat)
+	if !ok || len(ret.Values) != 1 {
+		return nil
+	}
+	var fields []string
+	addTable := func(t *TableExpr) {
+		for _, f := range t.Fields {
+			if name, ok := tableKeyName(f); ok {
+				fields = append(fields, name)
+			}
+		}
+	}
+	switch v := unwrapParen(ret.Values[0]).(type) {
+	case *TableExpr:
+		addTable(v)
+	case *NameExpr:
+		if v.Binding == nil {
+			return nil
+		}
+		Inspect(c, func(n Node) bool {
+			switch s := n.(type) {
+			case *LocalStat:
+				for i, name := range s.Names {
+					if t, ok := valueAt(s.Values, i).(*TableExpr); ok && name.Binding == v.Binding {
+						addTable(t)
+					}
+				}
+			case *IndexExpr:
+				if isNameOf(s.Obj, v.Binding) {
+					if name, ok := fieldName(s); ok {
+						fields = append(fields, name)
+					}
+				}
+			}
+			return true
+		})
+	}
+	return fields
+}
+
+func valueAt(values []Expr, i int) Expr {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
+
+// NewFieldNames returns a fresh renamer-style name for each of fields, none
+// of them in taken or shared with another field. The names carry w's mark, as
+// the renamer's do; w may be nil.
+func NewFieldNames(fields []string, taken map[string]bool, w *Watermark) map[string]string {
+	used := map[string]bool{}
+	names := make(map[string]string, len(fields))
+	for _, field := range fields {
+		name := w.name()
+		for taken[name] || used[name] {
+			name = w.name()
+		}
+		used[name] = true
+		names[field] = name
+	}
+	return names
+}
+
+// MangleFields renames the constant field names of c found in names, in
+// every form FieldNames collects. It returns the number of occurrences
+// renamed.
+func MangleFields(c *Chunk, names map[string]string) int {
+	count := 0
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *IndexExpr:
+			if v.Key == nil {
+				if name, ok := names[v.Field]; ok {
+					v.Field = name
+					count++
+				}
+			} else if s, ok := v.Key.(*StringExpr); ok {
+				if field, ok := unquoteLuaString(s.Text); ok && names[field] != "" {
+					v.Key = StringLiteral(names[field])
+					count++
+				}
+			}
+		case *TableExpr:
+			for i, f := range v.Fields {
+				field, ok := tableKeyName(f)
+				name := names[field]
+				if !ok || name == "" {
+					continue
+				}
+				if f.KeyName != "" {
+					v.Fields[i].KeyName = name
+				} else {
+					v.Fields[i].Key = StringLiteral(name)
+				}
+				count++
+			}
+		}
+		return true
+	})
+	return count
+}
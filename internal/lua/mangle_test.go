@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFieldNames(t *testing.T) {
+	c, err := Parse(`
+		local M = { _a = 1, ["_b"] = 2 }
+		function M:_c() return self._d, self["_e"] end
+		local p = game.Workspace._Map
+		local s = ` + "`{M._f}`")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	names, external := map[string]bool{}, map[string]bool{}
+	FieldNames(c, names, external)
+	for _, want := range []string{"_a", "_b", "_c", "_d", "_e", "_f", "Workspace", "_Map"} {
+		if !names[want] {
+			t.Errorf("missing field %s in %v", want, names)
+		}
+	}
+	for _, want := range []string{"Workspace", "_Map"} {
+		if !external[want] {
+			t.Errorf("%s should be external: %v", want, external)
+		}
+	}
+	if external["_d"] || external["_f"] {
+		t.Errorf("_d and _f are not read through an instance root: %v", external)
+	}
+}
+
+func TestExportedFields(t *testing.T) {
+	for src, want := range map[string][]string{
+		`return { a = 1, ["b"] = 2, [3] = 4 }`:                         {"a", "b"},
+		`local M = { a = 1 } M.b = 2 function M:c() end return M`:      {"a", "b", "c"},
+		`local M = {} local other = {} other.x = 1 M.y = 2 return (M)`: {"y"},
+		`return 1`:        nil,
+		`return f(), g()`: nil,
+	} {
+		c, err := Parse(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		got := ExportedFields(c)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestMangleFields(t *testing.T) {
+	c, err := Parse(`
+		local M = { _a = 1, ["_b"] = 2, keep = 3 }
+		function M:_c() return self._a + self["_b"] end
+		return M:_c(), M.keep`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	names := NewFieldNames([]string{"_a", "_b", "_c"}, map[string]bool{}, nil)
+	if n := MangleFields(c, names); n != 6 {
+		t.Fatalf("want 6 occurrences renamed, got %d:\n%s", n, c.Print())
+	}
+	out := c.Print()
+	for _, old := range []string{"_a", "_b", "_c"} {
+		if strings.Contains(out, old+"=") || strings.Contains(out, "."+old) || strings.Contains(out, ":"+old) || strings.Contains(out, `"`+old+`"`) {
+			t.Errorf("%s not renamed:\n%s", old, out)
+		}
+	}
+	for _, want := range []string{"keep=3", "M.keep", ":" + names["_c"] + "(", `["` + names["_b"] + `"]`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if _, err := Parse(out); err != nil {
+		t.Fatalf("output must parse: %v\n%s", err, out)
+	}
+}
+
+func TestNewFieldNames(t *testing.T) {
+	taken := map[string]bool{}
+	names := NewFieldNames([]string{"_a", "_b", "_c"}, taken, nil)
+	seen := map[string]bool{}
+	for field, name := range names {
+		if !strings.HasPrefix(name, "_0x") || seen[name] {
+			t.Errorf("%s: bad or duplicate name %q", field, name)
+		}
+		seen[name] = true
+	}
+}
@@ -10,14 +10,7 @@ type parser struct {
 // Parse scans and parses src into a Chunk. It returns an error on malformed or
 // unsupported input; callers fall back to Minify in that case.
 func Parse(src string) (*Chunk, error) {
-	raw := lex(src)
-	toks := make([]token, 0, len(raw))
-	for _, t := range raw {
-		if t.kind != tkComment {
-			toks = append(toks, t)
-		}
-	}
-	p := &parser{toks: toks}
+	p := &parser{toks: codeTokens(src)}
 	body, err := p.parseBlock()
 	if err != nil {
 		return nil, err
@@ -28,6 +21,18 @@ func Parse(src string) (*Chunk, error) {
 	return &Chunk{Body: body}, nil
 }
 
+// codeTokens lexes src without its comments.
+func codeTokens(src string) []token {
+	raw := lex(src)
+	toks := make([]token, 0, len(raw))
+	for _, t := range raw {
+		if t.kind != tkComment {
+			toks = append(toks, t)
+		}
+	}
+	return toks
+}
+
 func (p *parser) atEnd() bool { return p.pos >= len(p.toks) }
 
 func (p *parser) cur() token {
@@ -207,7 +212,11 @@ func (p *parser) parseSuffixed() (Expr, error) {
 // parseCallArgs handles (a, b), "str", and {table} call forms.
 func (p *parser) parseCallArgs() ([]Expr, error) {
 	if p.cur().kind == tkString {
-		return []Expr{&StringExpr{Text: p.advance().text}}, nil
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return []Expr{str}, nil
 	}
 	if p.isText("{") {
 		tbl, err := p.parseTable()
@@ -245,8 +254,7 @@ func (p *parser) parsePrimary() (Expr, error) {
 		p.advance()
 		return &NumberExpr{Text: t.text}, nil
 	case t.kind == tkString:
-		p.advance()
-		return &StringExpr{Text: t.text}, nil
+		return p.parseString()
 	case t.text == "nil":
 		p.advance()
 		return &NilExpr{}, nil
@@ -886,3 +894,50 @@ func (p *parser) parseIfExpr() (Expr, error) {
 	ie.Else = elseE
 	return ie, nil
 }
+
+// parseString parses a string token: a StringExpr, or an InterpExpr for a
+// backtick string.
+func (p *parser) parseString() (Expr, error) {
+	t := p.advance()
+	if t.text[0] != '`' {
+		return &StringExpr{Text: t.text}, nil
+	}
+	return p.parseInterp(t.text)
+}
+
+// parseInterp splits an interpolated string token into its literal parts and
+// parses each {expr} segment as an expression.
+func (p *parser) parseInterp(text string) (*InterpExpr, error) {
+	if len(text) < 2 || text[len(text)-1] != '`' {
+		return nil, p.errf("unterminated interpolated string")
+	}
+	body := text[1 : len(text)-1]
+	v := &InterpExpr{}
+	start := 0
+	for i := 0; i < len(body); {
+		switch body[i] {
+		case '\\':
+			i += 2
+		case '{':
+			end := scanInterpExpr(body, i)
+			if body[end-1] != '}' {
+				return nil, p.errf("unterminated {} in interpolated string")
+			}
+			sub := &parser{toks: codeTokens(body[i+1 : end-1])}
+			x, err := sub.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if !sub.atEnd() {
+				return nil, p.errf("unexpected %q in interpolated string", sub.cur().text)
+			}
+			v.Parts = append(v.Parts, body[start:i])
+			v.Exprs = append(v.Exprs, x)
+			start, i = end, end
+		default:
+			i++
+		}
+	}
+	v.Parts = append(v.Parts, body[start:])
+	return v, nil
+}
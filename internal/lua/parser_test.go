@@ -52,6 +52,32 @@ func TestParseExpr_MethodCall(t *testing.T) {
 	}
 }
 
+func TestParseExpr_Interpolation(t *testing.T) {
+	e := parseReturnExpr(t, "`a {x + 1} b {f(\"}\", {1})}{`in {y}`}\\{c\\}`")
+	v, ok := e.(*InterpExpr)
+	if !ok || len(v.Exprs) != 3 {
+		t.Fatalf("want an InterpExpr with 3 segments, got %#v", e)
+	}
+	if want := []string{"a ", " b ", "", `\{c\}`}; strings.Join(v.Parts, "|") != strings.Join(want, "|") {
+		t.Fatalf("parts = %q, want %q", v.Parts, want)
+	}
+	if _, ok := v.Exprs[0].(*BinExpr); !ok {
+		t.Fatalf("want x + 1, got %#v", v.Exprs[0])
+	}
+	if call, ok := v.Exprs[1].(*CallExpr); !ok || len(call.Args) != 2 {
+		t.Fatalf("braces inside strings and tables must not end the segment: %#v", v.Exprs[1])
+	}
+	if _, ok := v.Exprs[2].(*InterpExpr); !ok {
+		t.Fatalf("want a nested InterpExpr, got %#v", v.Exprs[2])
+	}
+
+	for _, bad := range []string{"`{}`", "`{1 2}`", "`{x`", "`{local}`"} {
+		if _, err := Parse("return " + bad); err == nil {
+			t.Errorf("Parse(%q) should fail", bad)
+		}
+	}
+}
+
 func TestParseExpr_Table(t *testing.T) {
 	e := parseReturnExpr(t, `{x = 1, [y] = 2, 3}`)
 	tbl, ok := e.(*TableExpr)
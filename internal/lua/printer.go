@@ -221,6 +221,8 @@ func (e *emitter) expr(x Expr) {
 		e.num(v.Text)
 	case *StringExpr:
 		e.str(v.Text)
+	case *InterpExpr:
+		e.str(interpText(v))
 	case *BoolExpr:
 		if v.Val {
 			e.name("true")
@@ -312,3 +314,27 @@ func (e *emitter) expr(x Expr) {
 }
 
 func isWordOp(op string) bool { return op == "and" || op == "or" }
+
+// interpText renders an interpolated string, each segment printed minified.
+// A segment starting with a table constructor is written `{ {...}}`, since
+// Luau rejects `{{`.
+func interpText(v *InterpExpr) string {
+	var b strings.Builder
+	b.WriteByte('`')
+	for i, part := range v.Parts {
+		b.WriteString(part)
+		if i < len(v.Exprs) {
+			sub := &emitter{}
+			sub.expr(v.Exprs[i])
+			text := sub.string()
+			b.WriteByte('{')
+			if strings.HasPrefix(text, "{") {
+				b.WriteByte(' ')
+			}
+			b.WriteString(text)
+			b.WriteByte('}')
+		}
+	}
+	b.WriteByte('`')
+	return b.String()
+}
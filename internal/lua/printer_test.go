@@ -13,13 +13,16 @@ func roundTrip(t *testing.T, src string) string {
 
 func TestPrint_ExprRoundTrip(t *testing.T) {
 	cases := map[string]string{
-		"return 1 + 2 * 3":       "return 1+2*3",
-		"return a.b.c(1)":        "return a.b.c(1)",
-		"return obj:M(1, 2)":     "return obj:M(1,2)",
-		`return {x = 1, [y]= 2}`: "return{x=1,[y]=2}",
-		"return 1 .. 2":          "return 1 ..2",
-		`return "a  b"`:          `return"a  b"`,
-		"return not a and b":     "return not a and b",
+		"return 1 + 2 * 3":        "return 1+2*3",
+		"return a.b.c(1)":         "return a.b.c(1)",
+		"return obj:M(1, 2)":      "return obj:M(1,2)",
+		`return {x = 1, [y]= 2}`:  "return{x=1,[y]=2}",
+		"return 1 .. 2":           "return 1 ..2",
+		`return "a  b"`:           `return"a  b"`,
+		"return not a and b":      "return not a and b",
+		"return `x = { x + 1 }!`": "return`x = {x+1}!`",
+		"return `{ {1} }\\{`":     "return`{ {1}}\\{`",
+		"return f(`a`)":           "return f(`a`)",
 	}
 	for in, want := range cases {
 		if got := roundTrip(t, in); got != want {
@@ -0,0 +1,45 @@
+package lua
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// randomSource supplies nonces, decoder masks and flattening choices; tests
+// may replace it.
+var randomSource io.Reader = rand.Reader
+
+// randomBytes fills n bytes from randomSource. Should that fail, a counter
+// keeps nonces distinct (nonces need not be secret, only varied).
+func randomBytes(n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(randomSource, buf); err != nil {
+		fallbackCounter++
+		for i := range buf {
+			buf[i] = byte(fallbackCounter >> (8 * (i % 4)))
+		}
+	}
+	return buf
+}
+
+var fallbackCounter uint32
+
+// randomNonce returns a fresh 24-bit nonce.
+func randomNonce() uint32 {
+	b := randomBytes(3)
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// randomInt returns a random int in [0, n).
+func randomInt(n int) int {
+	return int(binary.LittleEndian.Uint64(randomBytes(8)) % uint64(n))
+}
+
+// shuffleInts shuffles xs in place (Fisher-Yates).
+func shuffleInts(xs []int) {
+	for i := len(xs) - 1; i > 0; i-- {
+		j := randomInt(i + 1)
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+}
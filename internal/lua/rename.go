@@ -5,13 +5,11 @@ import (
 	"math/big"
 )
 
-// Rename resolves scopes and assigns obfuscated names to all local bindings.
-// It is a no-op if the chunk uses string interpolation, because identifiers
-// inside `{...}` are not tracked in Phase 1 and renaming could break them.
-func Rename(c *Chunk) {
-	if resolve(c) { // returns hasInterp
-		return
-	}
+// Rename resolves scopes and assigns obfuscated names to all local bindings,
+// including their uses inside interpolated strings. The names carry w's
+// mark; w may be nil.
+func Rename(c *Chunk, w *Watermark) {
+	resolve(c)
 	seen := map[*Binding]bool{}
 	// Collect every binding by walking declarations; assign a fresh name once.
 	walkBindings(c.Body, func(b *Binding) {
@@ -19,7 +17,7 @@ func Rename(c *Chunk) {
 			return
 		}
 		seen[b] = true
-		b.NewName = generateName()
+		b.NewName = w.name()
 	})
 }
 
@@ -34,6 +32,25 @@ func generateName() string {
 	return "_0x" + string(out)
 }
 
+// UsesName reports whether name is declared or referenced anywhere in the
+// chunk: as a local, parameter or loop variable (under its renamed name, if
+// any) or as a global. Field names are not bindings and do not count.
+func UsesName(c *Chunk, name string) bool {
+	found := false
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *NameExpr:
+			cur := v.Name
+			if v.Binding != nil && v.Binding.NewName != "" {
+				cur = v.Binding.NewName
+			}
+			found = found || cur == name
+		}
+		return !found
+	})
+	return found
+}
+
 // walkBindings visits each declaration NameExpr's binding exactly where it is
 // introduced. Because resolve() already shares one *Binding across a decl and
 // its uses, assigning NewName on the declaration updates every use.
@@ -149,6 +166,10 @@ func bindingsInExpr(x Expr, fn func(*Binding)) {
 			bindingsInExpr(v.ElifThen[i], fn)
 		}
 		bindingsInExpr(v.Else, fn)
+	case *InterpExpr:
+		for _, e := range v.Exprs {
+			bindingsInExpr(e, fn)
+		}
 	}
 	// NameExpr here is a *use*; its binding (if any) already got a NewName at the
 	// declaration site, so nothing to do. Leaves: nothing.
@@ -11,7 +11,7 @@ func obf(t *testing.T, src string) string {
 	if err != nil {
 		t.Fatalf("parse: %v", err)
 	}
-	Rename(c)
+	Rename(c, nil)
 	return c.Print()
 }
 
@@ -55,10 +55,13 @@ func TestRename_TableKeysPreserved(t *testing.T) {
 	}
 }
 
-func TestRename_InterpolationBailsOut(t *testing.T) {
-	out := obf(t, "local n = 1\nreturn `x {n}`")
-	if !strings.Contains(out, "local n") {
-		t.Fatalf("must NOT rename when interpolation present: %q", out)
+func TestRename_Interpolation(t *testing.T) {
+	out := obf(t, "local n = 1\nreturn `x {n} {math.max(n, 2)}`")
+	if strings.Contains(out, "local n") || strings.Contains(out, "{n}") {
+		t.Fatalf("locals inside interpolations must be renamed: %q", out)
+	}
+	if !strings.Contains(out, "`x {_0x") || !strings.Contains(out, "{math.max(_0x") {
+		t.Fatalf("interpolation must keep its text and globals: %q", out)
 	}
 }
 
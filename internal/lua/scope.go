@@ -24,15 +24,14 @@ func (s *scope) declare(name string) *Binding {
 	return b
 }
 
-type resolver struct{ hasInterp bool }
+type resolver struct{}
 
-// resolve attaches bindings to NameExprs. Returns whether the chunk uses string
-// interpolation (renamer bails if so — see Task 8).
-func resolve(c *Chunk) bool {
+// resolve attaches bindings to NameExprs, including those inside
+// interpolated strings.
+func resolve(c *Chunk) {
 	r := &resolver{}
 	top := newScope(nil)
 	r.block(c.Body, top)
-	return r.hasInterp
 }
 
 func (r *resolver) block(stats []Stat, s *scope) {
@@ -116,7 +115,7 @@ func (r *resolver) stat(st Stat, s *scope) {
 func (r *resolver) funcExpr(f *FuncExpr, parent *scope, isMethod bool) {
 	inner := newScope(parent)
 	if isMethod {
-		inner.declare("self") // implicit self; not renamed (see Task 8 guard)
+		f.Self = inner.declare("self") // implicit self; not renamed (see Task 8 guard)
 	}
 	for _, pm := range f.Params {
 		pm.Binding = inner.declare(pm.Name)
@@ -164,11 +163,11 @@ func (r *resolver) expr(x Expr, s *scope) {
 			r.expr(v.ElifThen[i], s)
 		}
 		r.expr(v.Else, s)
-	case *StringExpr:
-		if len(v.Text) > 0 && v.Text[0] == '`' {
-			r.hasInterp = true
+	case *InterpExpr:
+		for _, e := range v.Exprs {
+			r.expr(e, s)
 		}
-	case *NumberExpr, *BoolExpr, *NilExpr, *VarargExpr:
+	case *StringExpr, *NumberExpr, *BoolExpr, *NilExpr, *VarargExpr:
 		// leaves
 	case nil:
 		// nil optional expression — nothing to resolve
@@ -54,19 +54,18 @@ func TestResolve_FieldNotResolved(t *testing.T) {
 }
 
 func TestResolve_Interp(t *testing.T) {
-	c, err := Parse("local n = 1\nreturn `x {n}`")
+	c, err := Parse("local n = 1\nreturn `x {n} {m}`")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !resolve(c) {
-		t.Fatal("expected hasInterp=true for a chunk with a backtick string")
-	}
-	c2, err := Parse("local n = 1\nreturn n")
-	if err != nil {
-		t.Fatal(err)
+	resolve(c)
+	decl := c.Body[0].(*LocalStat).Names[0]
+	interp := c.Body[1].(*ReturnStat).Values[0].(*InterpExpr)
+	if interp.Exprs[0].(*NameExpr).Binding != decl.Binding {
+		t.Fatal("n inside the interpolation should bind to the local")
 	}
-	if resolve(c2) {
-		t.Fatal("expected hasInterp=false for a plain chunk")
+	if interp.Exprs[1].(*NameExpr).Binding != nil {
+		t.Fatal("m should be a global")
 	}
 }
 
@@ -0,0 +1,448 @@
+package lua
+
+import "sort"
+
+// loaderName is the bundle's module loader: the bundler rewrites every
+// embedded require into loadModule("key").
+const loaderName = "loadModule"
+
+// ExportUsage is what consumers read from one module's loadModule result.
+type ExportUsage struct {
+	All    bool            // the result escapes (passed around, indexed dynamically, ...)
+	Fields map[string]bool // fields read as M.f, M:f() or M["f"]
+}
+
+func (u *ExportUsage) addField(name string) {
+	if u.Fields == nil {
+		u.Fields = map[string]bool{}
+	}
+	u.Fields[name] = true
+}
+
+// CollectExportUsage records into usage, per module key, which fields c reads
+// from loadModule("key") results, either directly (loadModule("k").f) or
+// through a local bound to the result. Any other use of a result marks that
+// module All. It returns false if c calls loadModule with a non-literal key,
+// in which case no module's usage can be trusted.
+func CollectExportUsage(c *Chunk, usage map[string]*ExportUsage) bool {
+	resolve(c)
+	get := func(key string) *ExportUsage {
+		u := usage[key]
+		if u == nil {
+			u = &ExportUsage{}
+			usage[key] = u
+		}
+		return u
+	}
+
+	tracked := map[*Binding]string{} // local bound to a loadModule result -> key
+	allowed := map[Node]bool{}       // occurrences already accounted for
+	Inspect(c, func(n Node) bool {
+		if ls, ok := n.(*LocalStat); ok {
+			for i, v := range ls.Values {
+				if key, ok := loaderKey(v); ok && i < len(ls.Names) {
+					tracked[ls.Names[i].Binding] = key
+					allowed[ls.Names[i]] = true
+					allowed[v] = true
+					get(key)
+				}
+			}
+		}
+		return true
+	})
+
+	literal := true
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *IndexExpr:
+			obj := unwrapParen(v.Obj)
+			var u *ExportUsage
+			if key, ok := loaderKey(obj); ok {
+				u = get(key)
+			} else if nm, ok := obj.(*NameExpr); ok && nm.Binding != nil {
+				if key, ok := tracked[nm.Binding]; ok {
+					u = get(key)
+				}
+			}
+			if u != nil {
+				allowed[obj] = true
+				if name, ok := fieldName(v); ok {
+					u.addField(name)
+				} else {
+					u.All = true
+				}
+			}
+		case *CallExpr:
+			if nm, ok := unwrapParen(v.Fn).(*NameExpr); ok && nm.Name == loaderName && nm.Binding == nil {
+				key, ok := loaderKey(v)
+				if !ok {
+					literal = false
+				} else if !allowed[v] {
+					get(key).All = true
+				}
+			}
+		case *NameExpr:
+			if key, ok := tracked[v.Binding]; ok && v.Binding != nil && !allowed[v] {
+				get(key).All = true
+			}
+		}
+		return true
+	})
+	return literal
+}
+
+// loaderKey reports the key of a loadModule("key") call with a plain string
+// literal argument.
+func loaderKey(x Expr) (string, bool) {
+	call, ok := unwrapParen(x).(*CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return "", false
+	}
+	nm, ok := unwrapParen(call.Fn).(*NameExpr)
+	if !ok || nm.Name != loaderName || nm.Binding != nil {
+		return "", false
+	}
+	s, ok := call.Args[0].(*StringExpr)
+	if !ok {
+		return "", false
+	}
+	return unquoteLuaString(s.Text)
+}
+
+// fieldName is the constant field an IndexExpr reads: a.f, a:f or a["f"].
+func fieldName(v *IndexExpr) (string, bool) {
+	if v.Key == nil {
+		return v.Field, true
+	}
+	if s, ok := v.Key.(*StringExpr); ok {
+		return unquoteLuaString(s.Text)
+	}
+	return "", false
+}
+
+// shakeItem is one removable top-level definition in a module body: an
+// exported field (table-literal entry, function M.f, M.f = v) or a local
+// function.
+type shakeItem struct {
+	field     string   // exported field name; "" for a local function
+	local     *Binding // local function binding; nil for a field
+	removable bool     // false if removing it could drop a side effect
+	tableIdx  int      // index into the export table's fields, or -1
+	statIdx   int      // index into the chunk body, or -1
+	node      Node     // the definition, for dependency collection
+
+	fields []string   // exported fields the definition reads
+	refs   []*Binding // local functions the definition references
+}
+
+// ShakeExports removes from module body c the exported fields not in used,
+// and the top-level local functions reachable only from them, returning the
+// removed names ("local f" for locals) in sorted order. Supported shapes are
+// `return { ... }` and `local M = { ... } ... return M` where M is only ever
+// indexed by constant names. For any other shape, or when the analysis cannot
+// prove a definition dead, it changes nothing and returns nil.
+//
+// Reachability is name-based: a field read through self or any function
+// parameter (p.f) keeps f alive, since method calls may pass the module in.
+func ShakeExports(c *Chunk, used map[string]bool) []string {
+	resolve(c)
+	if len(c.Body) == 0 {
+		return nil
+	}
+	ret, ok := c.Body[len(c.Body)-1].(*ReturnStat)
+	if !ok || len(ret.Values) != 1 {
+		return nil
+	}
+
+	var export *TableExpr
+	var exportB *Binding
+	exportDecl := -1
+	switch v := unwrapParen(ret.Values[0]).(type) {
+	case *TableExpr:
+		export = v
+	case *NameExpr:
+		if v.Binding == nil {
+			return nil
+		}
+		exportB = v.Binding
+		for i, st := range c.Body {
+			ls, ok := st.(*LocalStat)
+			if !ok || len(ls.Names) != 1 || ls.Names[0].Binding != exportB {
+				continue
+			}
+			if len(ls.Values) != 1 {
+				return nil
+			}
+			if export, ok = ls.Values[0].(*TableExpr); !ok {
+				return nil
+			}
+			exportDecl = i
+		}
+		if export == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	params := map[*Binding]bool{}
+	Inspect(c, func(n Node) bool {
+		if f, ok := n.(*FuncExpr); ok {
+			for _, pm := range f.Params {
+				params[pm.Binding] = true
+			}
+		}
+		return true
+	})
+
+	// Collect definitions.
+	var items []*shakeItem
+	itemStats := map[int]bool{}
+	for i, f := range export.Fields {
+		if f.KeyName != "" {
+			items = append(items, &shakeItem{field: f.KeyName, removable: isPure(f.Value), tableIdx: i, statIdx: -1, node: f.Value})
+		}
+	}
+	locals := map[*Binding]*shakeItem{}
+	for i, st := range c.Body {
+		var it *shakeItem
+		switch v := st.(type) {
+		case *FuncStat:
+			if ix, ok := v.Target.(*IndexExpr); ok && exportB != nil && isNameOf(ix.Obj, exportB) {
+				it = &shakeItem{field: ix.Field, removable: true, node: v.Func}
+			}
+		case *AssignStat:
+			if len(v.Targets) == 1 && len(v.Values) == 1 && v.Op == "=" && exportB != nil {
+				if ix, ok := v.Targets[0].(*IndexExpr); ok && isNameOf(ix.Obj, exportB) {
+					if name, ok := fieldName(ix); ok {
+						it = &shakeItem{field: name, removable: isPure(v.Values[0]), node: v.Values[0]}
+					}
+				}
+			}
+		case *LocalFuncStat:
+			it = &shakeItem{local: v.Name.Binding, removable: true, node: v.Func}
+		case *LocalStat:
+			if len(v.Names) == 1 && len(v.Values) == 1 {
+				if _, ok := v.Values[0].(*FuncExpr); ok {
+					it = &shakeItem{local: v.Names[0].Binding, removable: true, node: v.Values[0]}
+				}
+			}
+		}
+		if it == nil {
+			continue
+		}
+		it.tableIdx, it.statIdx = -1, i
+		items = append(items, it)
+		itemStats[i] = true
+		if it.local != nil {
+			locals[it.local] = it
+		}
+	}
+
+	// deps returns the exported fields and local functions n refers to. ok is
+	// false if n uses the export table in a way that defeats the analysis.
+	deps := func(n Node) (fields []string, refs []*Binding, ok bool) {
+		ok = true
+		Inspect(n, func(m Node) bool {
+			switch v := m.(type) {
+			case *IndexExpr:
+				nm, isName := unwrapParen(v.Obj).(*NameExpr)
+				if !isName {
+					return true
+				}
+				toExport := exportB != nil && nm.Binding == exportB
+				if !toExport && nm.Name != "self" && !params[nm.Binding] {
+					return true
+				}
+				if name, constant := fieldName(v); constant {
+					fields = append(fields, name)
+				} else if toExport || nm.Name == "self" {
+					ok = false
+				}
+				if toExport {
+					return false // the export name itself is accounted for
+				}
+			case *NameExpr:
+				if exportB != nil && v.Binding == exportB {
+					ok = false // export table escapes as a value
+				} else if locals[v.Binding] != nil {
+					refs = append(refs, v.Binding)
+				}
+			}
+			return true
+		})
+		return fields, refs, ok
+	}
+
+	keptFields := map[string]bool{}
+	keptLocals := map[*Binding]bool{}
+	var fieldQueue []string
+	var localQueue []*Binding
+	keepAll := func(fields []string, refs []*Binding) {
+		for _, f := range fields {
+			if !keptFields[f] {
+				keptFields[f] = true
+				fieldQueue = append(fieldQueue, f)
+			}
+		}
+		for _, b := range refs {
+			if !keptLocals[b] {
+				keptLocals[b] = true
+				localQueue = append(localQueue, b)
+			}
+		}
+	}
+
+	// Roots: consumer reads, impure definitions, and every other statement.
+	for name := range used {
+		keepAll([]string{name}, nil)
+	}
+	for _, it := range items {
+		if !it.removable {
+			if it.field != "" {
+				keepAll([]string{it.field}, nil)
+			} else {
+				keepAll(nil, []*Binding{it.local})
+			}
+		}
+	}
+	for i, f := range export.Fields {
+		if f.KeyName != "" {
+			continue
+		}
+		fs, rs, ok := deps(&TableExpr{Fields: export.Fields[i : i+1]})
+		if !ok {
+			return nil
+		}
+		keepAll(fs, rs)
+	}
+	for i, st := range c.Body {
+		if itemStats[i] || i == exportDecl || i == len(c.Body)-1 {
+			continue
+		}
+		if exportB != nil {
+			if writesExport(st, exportB) {
+				return nil // e.g. M.a, M.b = x, y — too irregular to track
+			}
+		}
+		fs, rs, ok := deps(st)
+		if !ok {
+			return nil
+		}
+		keepAll(fs, rs)
+	}
+
+	// Propagate through kept definitions.
+	for _, it := range items {
+		var ok bool
+		if it.fields, it.refs, ok = deps(it.node); !ok {
+			return nil
+		}
+	}
+	for len(fieldQueue) > 0 || len(localQueue) > 0 {
+		if len(fieldQueue) > 0 {
+			f := fieldQueue[0]
+			fieldQueue = fieldQueue[1:]
+			for _, it := range items {
+				if it.field == f {
+					keepAll(it.fields, it.refs)
+				}
+			}
+			continue
+		}
+		b := localQueue[0]
+		localQueue = localQueue[1:]
+		if it := locals[b]; it != nil {
+			keepAll(it.fields, it.refs)
+		}
+	}
+
+	// Remove dead definitions.
+	var removed []string
+	dropField := map[int]bool{}
+	dropStat := map[int]bool{}
+	for _, it := range items {
+		dead := (it.field != "" && !keptFields[it.field]) || (it.local != nil && !keptLocals[it.local])
+		if !dead || !it.removable {
+			continue
+		}
+		if it.tableIdx >= 0 {
+			dropField[it.tableIdx] = true
+		} else {
+			dropStat[it.statIdx] = true
+		}
+		if it.local != nil {
+			removed = append(removed, "local "+it.local.OrigName)
+		} else {
+			removed = append(removed, it.field)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	fields := export.Fields[:0]
+	for i, f := range export.Fields {
+		if !dropField[i] {
+			fields = append(fields, f)
+		}
+	}
+	export.Fields = fields
+	body := c.Body[:0]
+	for i, st := range c.Body {
+		if !dropStat[i] {
+			body = append(body, st)
+		}
+	}
+	c.Body = body
+
+	sort.Strings(removed)
+	return dedupe(removed)
+}
+
+// writesExport reports whether st assigns a field of the export table.
+func writesExport(st Stat, exportB *Binding) bool {
+	as, ok := st.(*AssignStat)
+	if !ok {
+		return false
+	}
+	for _, t := range as.Targets {
+		if ix, ok := t.(*IndexExpr); ok && isNameOf(ix.Obj, exportB) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNameOf(x Expr, b *Binding) bool {
+	nm, ok := unwrapParen(x).(*NameExpr)
+	return ok && b != nil && nm.Binding == b
+}
+
+// isPure reports whether evaluating x cannot have a side effect, so dropping
+// an unused definition of it is safe.
+func isPure(x Expr) bool {
+	switch v := x.(type) {
+	case *FuncExpr, *NumberExpr, *StringExpr, *BoolExpr, *NilExpr, *NameExpr:
+		return true
+	case *ParenExpr:
+		return isPure(v.E)
+	case *TableExpr:
+		for _, f := range v.Fields {
+			if (f.Key != nil && !isPure(f.Key)) || !isPure(f.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
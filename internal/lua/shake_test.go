@@ -0,0 +1,135 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func usageOf(t *testing.T, src string) (map[string]*ExportUsage, bool) {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	usage := map[string]*ExportUsage{}
+	literal := CollectExportUsage(c, usage)
+	return usage, literal
+}
+
+func TestCollectExportUsage(t *testing.T) {
+	usage, literal := usageOf(t, `
+local U = loadModule("util")
+local x = U.a + U["b"]
+U:c()
+print(loadModule("direct").d)
+local E = loadModule("escapes")
+setmetatable({}, {__index = E})
+loadModule("sidefx")`)
+	if !literal {
+		t.Fatal("all keys are literal")
+	}
+	if u := usage["util"]; u == nil || u.All || !reflect.DeepEqual(u.Fields, map[string]bool{"a": true, "b": true, "c": true}) {
+		t.Fatalf("util usage = %+v", u)
+	}
+	if u := usage["direct"]; u == nil || u.All || !u.Fields["d"] {
+		t.Fatalf("direct usage = %+v", u)
+	}
+	if u := usage["escapes"]; u == nil || !u.All {
+		t.Fatalf("escapes usage = %+v", u)
+	}
+	if u := usage["sidefx"]; u == nil || !u.All {
+		t.Fatalf("a bare loadModule statement discards the result and must count as All: %+v", u)
+	}
+}
+
+func TestCollectExportUsage_DynamicKey(t *testing.T) {
+	if _, literal := usageOf(t, `local m = loadModule(name)`); literal {
+		t.Fatal("non-literal loadModule key must be reported")
+	}
+}
+
+func TestCollectExportUsage_DynamicIndexIsAll(t *testing.T) {
+	usage, _ := usageOf(t, `local U = loadModule("u") return U[k]`)
+	if !usage["u"].All {
+		t.Fatal("dynamic index must mark the module All")
+	}
+}
+
+func shake(t *testing.T, src string, used ...string) (string, []string) {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	set := map[string]bool{}
+	for _, u := range used {
+		set[u] = true
+	}
+	removed := ShakeExports(c, set)
+	return c.Print(), removed
+}
+
+func TestShakeExports_TableLiteral(t *testing.T) {
+	out, removed := shake(t, `
+local function helper() return 1 end
+local function shared() return 2 end
+return {
+	used = function() return shared() end,
+	unused = function() return helper() + shared() end,
+	version = "1.0",
+	[1] = "positional",
+}`, "used")
+	if !reflect.DeepEqual(removed, []string{"local helper", "unused", "version"}) {
+		t.Fatalf("removed = %v", removed)
+	}
+	for _, gone := range []string{"unused", "helper", "version"} {
+		if strings.Contains(out, gone) {
+			t.Fatalf("%s should be removed: %s", gone, out)
+		}
+	}
+	for _, kept := range []string{"used=", "shared", `[1]="positional"`} {
+		if !strings.Contains(out, kept) {
+			t.Fatalf("%s should be kept: %s", kept, out)
+		}
+	}
+}
+
+func TestShakeExports_LocalModuleTable(t *testing.T) {
+	out, removed := shake(t, `
+local M = {}
+M.VERSION = 3
+M.cache = setmetatable({}, {})
+function M.a() return M.b() end
+function M.b() return 1 end
+function M.c() return 2 end
+function M:d() return self:e() end
+function M:e() return 3 end
+return M`, "a", "d")
+	if !reflect.DeepEqual(removed, []string{"VERSION", "c"}) {
+		t.Fatalf("removed = %v (out: %s)", removed, out)
+	}
+	if !strings.Contains(out, "M.cache=setmetatable") {
+		t.Fatalf("impure definitions must be kept: %s", out)
+	}
+	if !strings.Contains(out, "function M:e()") {
+		t.Fatalf("fields reached through self must be kept: %s", out)
+	}
+}
+
+func TestShakeExports_BailsOut(t *testing.T) {
+	cases := map[string]string{
+		"export escapes":  "local M = {} M.a = 1 M.__index = M return M",
+		"dynamic self":    "local M = {} function M:a(k) return self[k] end M.b = 1 return M",
+		"not a table":     "return function() end",
+		"multiple assign": "local M = {} M.a, M.b = 1, 2 return M",
+		"nothing unused":  "return { a = 1 }",
+	}
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, removed := shake(t, src, "a"); removed != nil {
+				t.Fatalf("expected no change, removed %v", removed)
+			}
+		})
+	}
+}
@@ -8,8 +8,8 @@ import (
 
 // unquoteLuaString converts a Lua string-literal token (including its quotes or
 // long brackets) to its raw byte value. ok is false for forms it cannot safely
-// decode (backtick interpolation, unknown escapes, malformed input) — callers
-// then leave the literal unencrypted.
+// decode (unknown escapes, malformed input) — callers then leave the literal
+// unencrypted.
 func unquoteLuaString(text string) (string, bool) {
 	if len(text) < 2 {
 		return "", false
@@ -96,6 +96,29 @@ func unquoteLuaString(text string) (string, bool) {
 	return b.String(), true
 }
 
+// unquoteInterpPart decodes a literal part of an interpolated string (see
+// InterpExpr), which takes the escapes of quoted strings plus \{, \} and \`.
+func unquoteInterpPart(part string) (string, bool) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(part); i++ {
+		switch c := part[i]; {
+		case c == '\\' && i+1 < len(part) && strings.IndexByte("{}`", part[i+1]) >= 0:
+			b.WriteByte(part[i+1])
+			i++
+		case c == '\\' && i+1 < len(part):
+			b.WriteString(part[i : i+2])
+			i++
+		case c == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return unquoteLuaString(b.String())
+}
+
 // unquoteLongString decodes a [[...]] / [=*[...]=*] literal to its raw bytes.
 func unquoteLongString(text string) (string, bool) {
 	i := 1
@@ -121,29 +144,72 @@ func unquoteLongString(text string) (string, bool) {
 	return body, true
 }
 
-// encodeString returns a double-quoted Lua literal whose bytes are value[i]^key,
-// each written as a 3-digit decimal escape (valid in Lua 5.1 and Luau).
-func encodeString(value string, key byte) string {
+// StringKey is the level-3 string-encryption key shared by every unit of a
+// bundle and its decoder.
+type StringKey [16]byte
+
+// Parameters of the string cipher's generator: a 24-bit linear congruential
+// state, small enough that h*streamMul+streamInc stays exact in a double
+// (Lua 5.1 and Luau numbers).
+const (
+	streamMul = 1664525
+	streamInc = 1013904223
+	streamMod = 1 << 24
+)
+
+// streamCipher en- or decrypts value under key and a per-string nonce. The
+// state h is advanced with the key byte for each position and then with the
+// plaintext byte (autokey), and each byte is shifted by the top 8 bits of h
+// mod 256, so only addition, multiplication and % are needed to decode.
+func streamCipher(value string, nonce uint32, key StringKey, decrypt bool) string {
+	out := make([]byte, len(value))
+	h := nonce % streamMod
+	for i := 0; i < len(value); i++ {
+		h = (h*streamMul + streamInc + uint32(key[i%len(key)])) % streamMod
+		shift := byte(h >> 16)
+		var p byte
+		if decrypt {
+			p = value[i] - shift
+			out[i] = p
+		} else {
+			p = value[i]
+			out[i] = p + shift
+		}
+		h = (h + uint32(p)) % streamMod
+	}
+	return string(out)
+}
+
+// encodeString returns a double-quoted Lua literal of the encrypted value, each
+// byte written as a 3-digit decimal escape (valid in Lua 5.1 and Luau).
+func encodeString(value string, nonce uint32, key StringKey) string {
+	enc := streamCipher(value, nonce, key, false)
 	var b strings.Builder
 	b.WriteByte('"')
-	for i := 0; i < len(value); i++ {
-		fmt.Fprintf(&b, "\\%03d", value[i]^key)
+	for i := 0; i < len(enc); i++ {
+		fmt.Fprintf(&b, "\\%03d", enc[i])
 	}
 	b.WriteByte('"')
 	return b.String()
 }
 
-// EncryptStrings replaces eligible string literals in the chunk with _d("...")
-// decoder calls (bytes XOR-ed with key). It never encrypts: backtick
-// interpolation strings, the string argument of require(...), or the string
-// argument of game:HttpGet(...) — those must survive as literals for module
-// resolution. Literals it cannot safely decode are left unchanged.
-func EncryptStrings(c *Chunk, key byte) {
-	e := &encryptor{key: key}
+// EncryptStrings replaces eligible string literals in the chunk with
+// decoder("...", nonce) calls, each encrypted under key with its own random
+// nonce (see streamCipher). The literal parts of interpolated strings are
+// encrypted too, moved into {decoder(...)} segments. It never encrypts the
+// string argument of require(...) or of game:HttpGet(...) — those must
+// survive as literals for module resolution. Literals it cannot safely decode
+// are left unchanged. The nonces carry w's mark; w may be nil.
+func EncryptStrings(c *Chunk, key StringKey, decoder string, w *Watermark) {
+	e := &encryptor{key: key, decoder: decoder, mark: w}
 	e.block(c.Body)
 }
 
-type encryptor struct{ key byte }
+type encryptor struct {
+	key     StringKey
+	decoder string // name of the decoder local declared by DecoderPrelude
+	mark    *Watermark
+}
 
 func (e *encryptor) block(stats []Stat) {
 	for _, s := range stats {
@@ -248,6 +314,8 @@ func (e *encryptor) expr(x Expr) Expr {
 	case *FuncExpr:
 		e.block(v.Body)
 		return v
+	case *InterpExpr:
+		return e.interp(v)
 	case *IfExpr:
 		v.Cond = e.expr(v.Cond)
 		v.Then = e.expr(v.Then)
@@ -262,20 +330,46 @@ func (e *encryptor) expr(x Expr) Expr {
 	}
 }
 
-// encode turns a string literal into a _d("...") call, or reports ok=false to
-// leave it unchanged (backtick interpolation or undecodable literal).
-func (e *encryptor) encode(s *StringExpr) (Expr, bool) {
-	if len(s.Text) > 0 && s.Text[0] == '`' {
-		return nil, false
+// interp encrypts an interpolated string: `a{x}` becomes
+// `{decoder("a", nonce)}{x}`. A part with an escape it cannot decode stays
+// literal.
+func (e *encryptor) interp(v *InterpExpr) Expr {
+	out := &InterpExpr{Parts: []string{""}}
+	for i, part := range v.Parts {
+		if val, ok := unquoteInterpPart(part); ok && val != "" {
+			out.Exprs = append(out.Exprs, e.call(val))
+			out.Parts = append(out.Parts, "")
+		} else {
+			out.Parts[len(out.Parts)-1] += part
+		}
+		if i < len(v.Exprs) {
+			out.Exprs = append(out.Exprs, e.expr(v.Exprs[i]))
+			out.Parts = append(out.Parts, "")
+		}
 	}
+	return out
+}
+
+// encode turns a string literal into a decoder("...", nonce) call, or reports ok=false to
+// leave it unchanged (undecodable literal).
+func (e *encryptor) encode(s *StringExpr) (Expr, bool) {
 	val, ok := unquoteLuaString(s.Text)
 	if !ok {
 		return nil, false
 	}
+	return e.call(val), true
+}
+
+// call returns the decoder("...", nonce) call yielding val.
+func (e *encryptor) call(val string) Expr {
+	nonce := e.mark.nonce()
 	return &CallExpr{
-		Fn:   &NameExpr{Name: "_d"},
-		Args: []Expr{&StringExpr{Text: encodeString(val, e.key)}},
-	}, true
+		Fn: &NameExpr{Name: e.decoder},
+		Args: []Expr{
+			&StringExpr{Text: encodeString(val, nonce, e.key)},
+			&NumberExpr{Text: strconv.FormatUint(uint64(nonce), 10)},
+		},
+	}
 }
 
 // isProtectedCall reports whether v is a require(...), loadModule(...), or
@@ -304,13 +398,35 @@ func unwrapParen(x Expr) Expr {
 	}
 }
 
-// DecoderPrelude returns the `local _d=...` definition that decodes strings
-// produced by encodeString with the same key. It uses arithmetic XOR (no
-// bit32) so it runs under Lua 5.1 as well as Roblox Luau.
-func DecoderPrelude(key byte) string {
-	return fmt.Sprintf("local _d=(function()"+
-		"local function x(a,b)local r,p=0,1 for _=1,8 do local m,n=a%%2,b%%2 "+
-		"if m~=n then r=r+p end a,b,p=(a-m)/2,(b-n)/2,p*2 end return r end "+
-		"return function(s)local t={}for i=1,#s do t[i]=string.char(x(s:byte(i),%d))end "+
-		"return table.concat(t)end end)()", key)
+// DecoderPrelude returns the `local <decoder>=...` definition that decodes
+// strings produced by EncryptStrings with the same key and decoder name. The key never appears as a
+// literal: it is rebuilt from two random-looking tables, k[i] = a[i] + b[17-i]
+// (mod 256). Only arithmetic is used (no bit32), so it runs under Lua 5.1 as
+// well as Roblox Luau. Results are cached by nonce and ciphertext, so a
+// string or field name inside a loop is decoded once.
+func DecoderPrelude(key StringKey, decoder string) string {
+	n := len(key)
+	a := randomBytes(n)
+	b := make([]byte, n)
+	for i := range key {
+		b[n-1-i] = key[i] - a[i]
+	}
+	return fmt.Sprintf("local %s=(function()"+
+		"local a,b,k,c={%s},{%s},{},{}for i=1,%d do k[i]=(a[i]+b[%d-i])%%256 end "+
+		"return function(s,n)local m=c[n]local r=m and m[s]if r then return r end "+
+		"local h,t=n,{}for i=1,#s do "+
+		"h=(h*%d+%d+k[(i-1)%%%d+1])%%%d local p=(s:byte(i)-math.floor(h/%d))%%256 "+
+		"t[i]=string.char(p)h=(h+p)%%%d end "+
+		"r=table.concat(t)if not m then m={}c[n]=m end m[s]=r return r end end)()",
+		decoder, byteList(a), byteList(b), n, n+1,
+		streamMul, streamInc, n, streamMod, 1<<16, streamMod)
+}
+
+// byteList formats bs as comma-separated decimals for a table constructor.
+func byteList(bs []byte) string {
+	parts := make([]string, len(bs))
+	for i, c := range bs {
+		parts[i] = strconv.Itoa(int(c))
+	}
+	return strings.Join(parts, ",")
 }
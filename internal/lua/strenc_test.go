@@ -1,6 +1,11 @@
 package lua
 
-import "testing"
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func TestUnquoteLuaString(t *testing.T) {
 	cases := []struct {
@@ -17,7 +22,7 @@ func TestUnquoteLuaString(t *testing.T) {
 		{"[[raw]]", "raw", true},
 		{"[==[a]==]", "a", true},
 		{"[[\nleading]]", "leading", true}, // first newline dropped
-		{"`interp{x}`", "", false},         // backtick not handled
+		{"`interp{x}`", "", false},         // an InterpExpr, not a quoted string
 		{`"bad\q"`, "", false},             // unknown escape
 	}
 	for _, c := range cases {
@@ -28,30 +33,41 @@ func TestUnquoteLuaString(t *testing.T) {
 	}
 }
 
+var testKey = StringKey{0x5a, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 0xff}
+
 func TestEncodeStringRoundTrip(t *testing.T) {
-	// encodeString emits "\ddd..." of value[i]^key; decoding those escapes and
-	// XOR-ing by key again must recover value.
-	key := byte(0x5a)
+	// encodeString emits "\ddd..." of the encrypted bytes; decoding those
+	// escapes and decrypting with the same nonce and key must recover value.
 	value := "Hello\tWorld\000\255"
-	lit := encodeString(value, key)
+	lit := encodeString(value, 12345, testKey)
 	decoded, ok := unquoteLuaString(lit)
 	if !ok {
 		t.Fatalf("encodeString produced un-decodable literal: %q", lit)
 	}
-	if len(decoded) != len(value) {
-		t.Fatalf("length mismatch: %d vs %d", len(decoded), len(value))
+	if decoded == value {
+		t.Fatalf("value not encrypted: %q", lit)
 	}
-	var got []byte
-	for i := 0; i < len(decoded); i++ {
-		got = append(got, decoded[i]^key)
+	if got := streamCipher(decoded, 12345, testKey, true); got != value {
+		t.Fatalf("round-trip failed: %q != %q", got, value)
 	}
-	if string(got) != value {
-		t.Fatalf("round-trip failed: %q != %q", string(got), value)
+}
+
+func TestEncodeString_NonceVariesCiphertext(t *testing.T) {
+	// The same plaintext under different nonces must not share ciphertext, and
+	// a repeated byte must not encrypt to a repeated byte.
+	a := encodeString("aaaaaaaa", 1, testKey)
+	b := encodeString("aaaaaaaa", 2, testKey)
+	if a == b {
+		t.Fatalf("nonce did not change ciphertext: %q", a)
+	}
+	dec, _ := unquoteLuaString(a)
+	if strings.Count(dec, dec[:1]) == len(dec) {
+		t.Fatalf("repeated plaintext byte leaks through: %q", a)
 	}
 }
 
 func TestDecoderPrelude_NoBit32(t *testing.T) {
-	p := DecoderPrelude(137)
+	p := DecoderPrelude(testKey, "_d")
 	if p == "" {
 		t.Fatal("expected non-empty prelude")
 	}
@@ -62,8 +78,27 @@ func TestDecoderPrelude_NoBit32(t *testing.T) {
 	if contains(p, "bit32") {
 		t.Fatalf("prelude must not use bit32: %q", p)
 	}
-	if !contains(p, "137") {
-		t.Fatalf("prelude must embed the key: %q", p)
+	if _, err := Parse(p); err != nil {
+		t.Fatalf("prelude must parse: %v", err)
+	}
+}
+
+func TestDecoderPrelude_MasksKey(t *testing.T) {
+	p := DecoderPrelude(testKey, "_d")
+	m := regexp.MustCompile(`local a,b,k,c=\{([\d,]+)\},\{([\d,]+)\}`).FindStringSubmatch(p)
+	if m == nil {
+		t.Fatalf("key tables not found: %q", p)
+	}
+	if contains(p, byteList(testKey[:])) {
+		t.Fatalf("key must not appear as a literal: %q", p)
+	}
+	a, b := strings.Split(m[1], ","), strings.Split(m[2], ",")
+	for i := range testKey {
+		x, _ := strconv.Atoi(a[i])
+		y, _ := strconv.Atoi(b[len(b)-1-i])
+		if byte((x+y)%256) != testKey[i] {
+			t.Fatalf("k[%d] = %d, want %d", i+1, (x+y)%256, testKey[i])
+		}
 	}
 }
 
@@ -76,18 +111,18 @@ func contains(s, sub string) bool {
 	return false
 }
 
-func encStr(t *testing.T, src string, key byte) string {
+func encStr(t *testing.T, src string, key StringKey) string {
 	t.Helper()
 	c, err := Parse(src)
 	if err != nil {
 		t.Fatalf("parse %q: %v", src, err)
 	}
-	EncryptStrings(c, key)
+	EncryptStrings(c, key, "_d", nil)
 	return c.Print()
 }
 
 func TestEncryptStrings_PlainEncrypted(t *testing.T) {
-	out := encStr(t, `local s = "hello"`, 0x33)
+	out := encStr(t, `local s = "hello"`, testKey)
 	if contains(out, `"hello"`) {
 		t.Fatalf("plaintext leaked: %q", out)
 	}
@@ -97,14 +132,14 @@ func TestEncryptStrings_PlainEncrypted(t *testing.T) {
 }
 
 func TestEncryptStrings_RequireExcluded(t *testing.T) {
-	out := encStr(t, `local M = require("core/theme")`, 0x33)
+	out := encStr(t, `local M = require("core/theme")`, testKey)
 	if !contains(out, `require("core/theme")`) {
 		t.Fatalf("require path must NOT be encrypted: %q", out)
 	}
 }
 
 func TestEncryptStrings_HttpGetExcluded(t *testing.T) {
-	out := encStr(t, `loadstring(game:HttpGet("https://x.test/a.lua"))()`, 0x33)
+	out := encStr(t, `loadstring(game:HttpGet("https://x.test/a.lua"))()`, testKey)
 	if !contains(out, `"https://x.test/a.lua"`) {
 		t.Fatalf("HttpGet URL must NOT be encrypted: %q", out)
 	}
@@ -112,7 +147,7 @@ func TestEncryptStrings_HttpGetExcluded(t *testing.T) {
 
 func TestEncryptStrings_GetServiceEncrypted(t *testing.T) {
 	// Non-protected calls: string args ARE encrypted (runtime decodes them).
-	out := encStr(t, `local p = game:GetService("Players")`, 0x33)
+	out := encStr(t, `local p = game:GetService("Players")`, testKey)
 	if contains(out, `"Players"`) {
 		t.Fatalf("GetService arg should be encrypted: %q", out)
 	}
@@ -121,30 +156,71 @@ func TestEncryptStrings_GetServiceEncrypted(t *testing.T) {
 	}
 }
 
-func TestEncryptStrings_BacktickLeftAlone(t *testing.T) {
-	out := encStr(t, "local s = `hi {x}`", 0x33)
-	if !contains(out, "`hi {x}`") {
-		t.Fatalf("interp string must be left as-is: %q", out)
+func TestEncryptStrings_Interpolation(t *testing.T) {
+	out := encStr(t, "local s = `hi {x}!\\z{y}`", testKey)
+	if contains(out, "hi") {
+		t.Fatalf("interpolation text must be encrypted: %q", out)
+	}
+	if !contains(out, "`{_d(") || !contains(out, `{x}!\z{y}`+"`") {
+		t.Fatalf("parts become decoder segments, undecodable ones stay: %q", out)
+	}
+	if _, err := Parse(out); err != nil {
+		t.Fatalf("output must parse: %v\n%s", err, out)
+	}
+}
+
+func TestUnquoteInterpPart(t *testing.T) {
+	for in, want := range map[string]string{
+		`plain`:          "plain",
+		`a\{b\}`:         "a{b}",
+		"q\\`\"'":        "q`\"'",
+		`tab\tx\065`:     "tab\txA",
+		`brace\}\\end\{`: `brace}\end{`,
+	} {
+		if got, ok := unquoteInterpPart(in); !ok || got != want {
+			t.Errorf("unquoteInterpPart(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	if _, ok := unquoteInterpPart(`\z`); ok {
+		t.Error(`\z should not decode`)
 	}
 }
 
 func TestEncryptStrings_ParenthesizedRequireExcluded(t *testing.T) {
-	out := encStr(t, `local M = require(("core/theme"))`, 0x33)
+	out := encStr(t, `local M = require(("core/theme"))`, testKey)
 	if !contains(out, `"core/theme"`) {
 		t.Fatalf("parenthesized require path must NOT be encrypted: %q", out)
 	}
 }
 
 func TestEncryptStrings_ParenthesizedHttpGetExcluded(t *testing.T) {
-	out := encStr(t, `loadstring(game:HttpGet(("https://x.test/a.lua")))()`, 0x33)
+	out := encStr(t, `loadstring(game:HttpGet(("https://x.test/a.lua")))()`, testKey)
 	if !contains(out, `"https://x.test/a.lua"`) {
 		t.Fatalf("parenthesized HttpGet URL must NOT be encrypted: %q", out)
 	}
 }
 
 func TestEncryptStrings_LoadModuleExcluded(t *testing.T) {
-	out := encStr(t, `local M = loadModule("core/theme")`, 0x33)
+	out := encStr(t, `local M = loadModule("core/theme")`, testKey)
 	if !contains(out, `"core/theme"`) {
 		t.Fatalf("loadModule key must NOT be encrypted: %q", out)
 	}
 }
+
+func TestEncryptStrings_PerStringNonce(t *testing.T) {
+	out := encStr(t, `local a, b = "same", "same"`, testKey)
+	m := regexp.MustCompile(`_d\(("[^"]*"),(\d+)\)`).FindAllStringSubmatch(out, -1)
+	if len(m) != 2 {
+		t.Fatalf("expected two _d(s, nonce) calls: %q", out)
+	}
+	if m[0][1] == m[1][1] {
+		t.Fatalf("equal strings must not share ciphertext: %q", out)
+	}
+	for _, call := range m {
+		lit, _ := unquoteLuaString(call[1])
+		nonce, _ := strconv.Atoi(call[2])
+		if got := streamCipher(lit, uint32(nonce), testKey, true); got != "same" {
+			t.Fatalf("decrypted %q, want %q", got, "same")
+		}
+	}
+}
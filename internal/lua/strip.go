@@ -0,0 +1,114 @@
+package lua
+
+import "strings"
+
+// StripCalls removes call statements whose callee matches one of callees and
+// returns how many were removed. A callee is a global name ("print"), a field
+// or method path ("Logger.info", "Logger:debug"), or a prefix pattern ending
+// in ".*" that matches any field or method path under a name ("debug.*").
+// A path's root is matched by its source name whether it is a global or a
+// local, so `local Logger = require("./logger")` followed by
+// Logger:debug("x") is stripped. Locals initialised from a global or a path
+// and never reassigned (`local log = print`, `local dbg = debug`) stand for
+// that global or path. Calls whose result is used are kept: only CallStat
+// nodes are removed.
+func StripCalls(c *Chunk, callees []string) int {
+	if len(callees) == 0 {
+		return 0
+	}
+	resolve(c)
+	s := &stripper{callees: callees, aliases: map[*Binding]string{}}
+	reassigned := map[*Binding]bool{}
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *LocalStat:
+			for i, name := range v.Names {
+				if i >= len(v.Values) {
+					continue
+				}
+				if path, ok := s.path(v.Values[i], false); ok {
+					s.aliases[name.Binding] = path
+				}
+			}
+		case *AssignStat:
+			for _, t := range v.Targets {
+				if name, ok := t.(*NameExpr); ok && name.Binding != nil {
+					reassigned[name.Binding] = true
+				}
+			}
+		}
+		return true
+	})
+	for b := range reassigned {
+		delete(s.aliases, b)
+	}
+	removed := 0
+	eachBlock(c, func(stats []Stat) []Stat {
+		out := stats[:0]
+		for _, st := range stats {
+			if call, ok := st.(*CallStat); ok {
+				if fn, ok := call.Call.(*CallExpr); ok && s.matches(fn.Fn) {
+					removed++
+					continue
+				}
+			}
+			out = append(out, st)
+		}
+		return out
+	})
+	return removed
+}
+
+type stripper struct {
+	callees []string
+	aliases map[*Binding]string // locals bound to a global or path, by that path
+}
+
+// matches reports whether x names a stripped callee.
+func (s *stripper) matches(x Expr) bool {
+	path, ok := s.path(x, false)
+	if !ok {
+		return false
+	}
+	for _, pattern := range s.callees {
+		if prefix, ok := strings.CutSuffix(pattern, ".*"); ok {
+			if rest, ok := strings.CutPrefix(path, prefix); ok && len(rest) > 1 && (rest[0] == '.' || rest[0] == ':') {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// path renders a name or a dotted/colon field path (e.g. "Logger:debug"),
+// replacing an aliased local with the path it stands for; ok is false for
+// anything else. A local that is not an alias only counts as the root of a
+// field path (root set), where it is taken by its name.
+func (s *stripper) path(x Expr, root bool) (string, bool) {
+	switch v := x.(type) {
+	case *NameExpr:
+		if v.Binding == nil {
+			return v.Name, true
+		}
+		if path, ok := s.aliases[v.Binding]; ok {
+			return path, true
+		}
+		return v.Name, root
+	case *IndexExpr:
+		if v.Field == "" {
+			return "", false
+		}
+		obj, ok := s.path(v.Obj, true)
+		if !ok {
+			return "", false
+		}
+		sep := "."
+		if v.IsMethod {
+			sep = ":"
+		}
+		return obj + sep + v.Field, true
+	}
+	return "", false
+}
@@ -0,0 +1,50 @@
+package lua
+
+import "testing"
+
+func strip(t *testing.T, src string, callees ...string) string {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	StripCalls(c, callees)
+	return c.Print()
+}
+
+func TestStripCalls(t *testing.T) {
+	cases := map[string]string{
+		`print("a)") foo()`:                            "foo()",
+		"print(\n'x',\n(1)\n) local y = 2":             "local y=2",
+		"local log = print\nlog('x') return 1":         "local log=print return 1",
+		"local log = print log = f log('x')":           "local log=print log=f log('x')",
+		"debug.profilebegin('x') debug.x.y() f()":      "f()",
+		"Logger:debug('x') Logger:info('y')":           `Logger:info('y')`,
+		"local print = f print('x')":                   `local print=f print('x')`,
+		"local dbg = debug dbg.traceback() f()":        "local dbg=debug f()",
+		"local t = f() t:debug('x')":                   "local t=f()t:debug('x')",
+		"local x = print('kept') if a then warn() end": `local x=print('kept')if a then end`,
+	}
+	for src, want := range cases {
+		if got := strip(t, src, "print", "warn", "debug.*", "Logger:debug"); got != want {
+			t.Errorf("StripCalls(%q) = %q, want %q", src, got, want)
+		}
+	}
+	if got := strip(t, "assert(x) print(1)", "print"); got != "assert(x)" {
+		t.Errorf("assert must only be stripped when listed: %q", got)
+	}
+}
+
+func TestStripCalls_LocalLoggers(t *testing.T) {
+	cases := map[string]string{
+		`local Logger = require("./logger") Logger:debug("x") Logger:info("y")`:  `local Logger=require("./logger")Logger:info("y")`,
+		`local Logger = loadModule("logger") Logger:debug("x")`:                  `local Logger=loadModule("logger")`,
+		`local Logger = require("./logger") local d = Logger.debug d(Logger, 1)`: `local Logger=require("./logger")local d=Logger.debug`,
+		`local function f(Logger) Logger:debug("x") end`:                         `local function f(Logger)end`,
+	}
+	for src, want := range cases {
+		if got := strip(t, src, "Logger:debug", "Logger.debug"); got != want {
+			t.Errorf("StripCalls(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
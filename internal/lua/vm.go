@@ -0,0 +1,997 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// vmOp is an instruction of the virtualization bytecode. The numbers written
+// to the bytecode are a per-build permutation of these (see VM).
+type vmOp int
+
+const (
+	opLoadK     vmOp = iota // k: push K[k]
+	opLoadNil               // push nil
+	opLoadTrue              // push true
+	opLoadFalse             // push false
+	opGetLocal              // s: push R[s]
+	opSetLocal              // s: R[s] = pop
+	opNewCell               // s: R[s] = {pop} (declare a captured local)
+	opGetCell               // s: push R[s][1]
+	opSetCell               // s: R[s][1] = pop
+	opBox                   // s: R[s] = {R[s]} (a captured parameter)
+	opGetUpval              // u: push U[u][1]
+	opSetUpval              // u: U[u][1] = pop
+	opGetGlobal             // k: push env[K[k]]
+	opSetGlobal             // k: env[K[k]] = pop
+	opGetIndex              // o, k -> o[k]
+	opSetIndex              // o, k, v -> (o[k] = v)
+	opSelf                  // k: o -> o[K[k]], o
+	opNewTable              // push {}
+	opAppend                // i: t, mark, values... -> (t[i], t[i+1], ... = values)
+	opMark                  // remember the stack top
+	opAdjust                // n: truncate or nil-pad the values since the mark to n
+	opCall                  // a, r: call with a-1 args (0: since the mark), keep r-1 results (0: all)
+	opVararg                // n: push n-1 varargs (0: all)
+	opClosure               // p, n, (kind, index)*n: push a closure of P[p]
+	opRet                   // n: return n-1 values (0: since the mark)
+	opJmp                   // pc
+	opJmpIf                 // pc: jump if pop is truthy
+	opJmpIfNot              // pc: jump if pop is falsy
+	opDup                   // a -> a, a
+	opDup2                  // a, b -> a, b, a, b
+	opPop                   // a ->
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opIDiv
+	opMod
+	opPow
+	opConcat
+	opEq
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opNeg
+	opNot
+	opLen
+	opForTest  // s, pc: leave the numeric for at R[s..s+2] for pc when done
+	opForStep  // s: R[s] = R[s] + R[s+2]
+	opGForPrep // s: iterate a table in R[s] with next (Luau generalized iteration)
+	opTForCall // s, n, pc: call R[s](R[s+1], R[s+2]); push n results or leave for pc
+	numVMOps
+)
+
+// vmOpNames are the placeholders for each opcode in vmRuntime.
+var vmOpNames = [numVMOps]string{
+	"LOADK", "LOADNIL", "LOADTRUE", "LOADFALSE", "GETLOCAL", "SETLOCAL",
+	"NEWCELL", "GETCELL", "SETCELL", "BOX", "GETUPVAL", "SETUPVAL",
+	"GETGLOBAL", "SETGLOBAL", "GETINDEX", "SETINDEX", "SELF", "NEWTABLE",
+	"APPEND", "MARK", "ADJUST", "CALL", "VARARG", "CLOSURE", "RET", "JMP",
+	"JMPIF", "JMPIFNOT", "DUP", "DUP2", "POP", "ADD", "SUB", "MUL", "DIV",
+	"IDIV", "MOD", "POW", "CONCAT", "EQ", "NE", "LT", "LE", "GT", "GE",
+	"NEG", "NOT", "LEN", "FORTEST", "FORSTEP", "GFORPREP", "TFORCALL",
+}
+
+var vmBinOps = map[string]vmOp{
+	"+": opAdd, "-": opSub, "*": opMul, "/": opDiv, "//": opIDiv, "%": opMod,
+	"^": opPow, "..": opConcat, "==": opEq, "~=": opNe, "<": opLt, "<=": opLe,
+	">": opGt, ">=": opGe,
+}
+
+// VM is a per-build virtual machine: a random opcode numbering shared by
+// every chunk compiled with it and by its interpreter (see Runtime).
+type VM struct {
+	Name string // the interpreter local declared by Runtime
+	ops  [numVMOps]int
+}
+
+// NewVM returns a VM whose interpreter is declared as name, with opcode
+// numbers drawn at random.
+func NewVM(name string) *VM {
+	vm := &VM{Name: name}
+	perm := make([]int, 256)
+	for i := range perm {
+		perm[i] = i
+	}
+	shuffleInts(perm)
+	copy(vm.ops[:], perm)
+	return vm
+}
+
+// Compile translates the chunk into bytecode for vm and returns Lua source
+// that runs it: `return <vm.Name>("<bytecode>", seed)()`, passing `...` on
+// only if the chunk reads it (a module wrapper is not vararg). The bytecode
+// (constants included) is masked with a keystream seeded per chunk, so no
+// string of the original appears in the output. Chunks using escapes the
+// bundler cannot decode, a goto without a visible label, or other unsupported
+// constructs are rejected.
+func (vm *VM) Compile(c *Chunk) (string, error) {
+	resolve(c)
+	cc := &vmCompiler{vm: vm, captured: capturedBindings(c)}
+	f := cc.function(nil, nil, c.Body, true)
+	if cc.err != nil {
+		return "", cc.err
+	}
+	var w vmWriter
+	w.proto(f.p)
+	seed := uint32(randomInt(streamMod))
+	args := ""
+	if f.usesVararg {
+		args = "..."
+	}
+	return fmt.Sprintf("return %s(%s,%d)(%s)", vm.Name, QuoteString(w.masked(seed)), seed, args), nil
+}
+
+// capturedBindings returns the locals referenced from a function other than
+// the one declaring them. They live in cells so closures share them.
+func capturedBindings(c *Chunk) map[*Binding]bool {
+	owner := map[*Binding]Node{}
+	captured := map[*Binding]bool{}
+	var walk func(fn Node)
+	walk = func(fn Node) {
+		Inspect(fn, func(n Node) bool {
+			switch v := n.(type) {
+			case *FuncExpr:
+				if Node(v) != fn {
+					if v.Self != nil {
+						owner[v.Self] = v
+					}
+					walk(v)
+					return false
+				}
+			case *NameExpr:
+				if v.Binding == nil {
+					return true
+				}
+				if o, ok := owner[v.Binding]; !ok {
+					owner[v.Binding] = fn
+				} else if o != fn {
+					captured[v.Binding] = true
+				}
+			}
+			return true
+		})
+	}
+	walk(c)
+	return captured
+}
+
+type vmProto struct {
+	params int
+	vararg bool
+	slots  int
+	consts []vmConst
+	code   []int
+	protos []*vmProto
+}
+
+// vmConst is a constant: a number (as text tonumber accepts) or a string.
+type vmConst struct {
+	num  bool
+	text string
+}
+
+type vmCompiler struct {
+	vm       *VM
+	captured map[*Binding]bool
+	err      error
+}
+
+func (cc *vmCompiler) fail(format string, args ...any) {
+	if cc.err == nil {
+		cc.err = fmt.Errorf(format, args...)
+	}
+}
+
+// vmFunc is the compile state of one function.
+type vmFunc struct {
+	cc      *vmCompiler
+	parent  *vmFunc
+	p       *vmProto
+	consts  map[vmConst]int
+	locals  map[*Binding]int
+	upIndex map[*Binding]int
+	upSrc   []int // kind (0 local cell, 1 upvalue), index per upvalue
+	next    int   // next free slot
+	block   *vmBlock
+	loops   []*vmLoop
+
+	usesVararg bool
+}
+
+type vmBlock struct {
+	parent   *vmBlock
+	declared []*Binding
+	next     int
+	labels   map[string]int
+	gotos    []vmGoto
+}
+
+type vmGoto struct {
+	label string
+	at    int // code index of the jump operand
+}
+
+type vmLoop struct {
+	breaks, conts []int
+}
+
+// function compiles a function (fn is nil for the chunk) and returns its proto.
+func (cc *vmCompiler) function(parent *vmFunc, fn *FuncExpr, body []Stat, vararg bool) *vmFunc {
+	f := &vmFunc{
+		cc: cc, parent: parent, p: &vmProto{vararg: vararg},
+		consts: map[vmConst]int{}, locals: map[*Binding]int{}, upIndex: map[*Binding]int{},
+		next: 1,
+	}
+	f.enter()
+	if fn != nil {
+		var params []*Binding
+		if fn.Self != nil {
+			params = append(params, fn.Self)
+		}
+		for _, pm := range fn.Params {
+			params = append(params, pm.Binding)
+		}
+		f.p.params = len(params)
+		for _, b := range params {
+			s := f.declare(b)
+			if cc.captured[b] {
+				f.emit(opBox, s)
+			}
+		}
+	}
+	f.stats(body)
+	f.leave()
+	f.emit(opRet, 1)
+	return f
+}
+
+func (f *vmFunc) emit(op vmOp, args ...int) {
+	f.p.code = append(f.p.code, f.cc.vm.ops[op])
+	f.p.code = append(f.p.code, args...)
+}
+
+// pc returns the program counter of the next instruction (1-based).
+func (f *vmFunc) pc() int { return len(f.p.code) + 1 }
+
+// jump emits a jump and returns the index of its target operand.
+func (f *vmFunc) jump(op vmOp) int {
+	f.emit(op, 0)
+	return len(f.p.code) - 1
+}
+
+func (f *vmFunc) patch(at, pc int) { f.p.code[at] = pc }
+
+func (f *vmFunc) constant(k vmConst) int {
+	if i, ok := f.consts[k]; ok {
+		return i
+	}
+	f.p.consts = append(f.p.consts, k)
+	f.consts[k] = len(f.p.consts)
+	return len(f.p.consts)
+}
+
+func (f *vmFunc) str(s string) int { return f.constant(vmConst{text: s}) }
+
+func (f *vmFunc) num(n int) int { return f.constant(vmConst{num: true, text: strconv.Itoa(n)}) }
+
+func (f *vmFunc) enter() {
+	f.block = &vmBlock{parent: f.block, next: f.next, labels: map[string]int{}}
+}
+
+// leave closes the current block, freeing its slots; gotos to labels not
+// found in it move to the enclosing block.
+func (f *vmFunc) leave() {
+	b := f.block
+	for _, d := range b.declared {
+		delete(f.locals, d)
+	}
+	f.next = b.next
+	f.block = b.parent
+	if f.block != nil {
+		f.block.gotos = append(f.block.gotos, b.gotos...)
+	} else if len(b.gotos) > 0 {
+		f.cc.fail("no visible label %q for goto", b.gotos[0].label)
+	}
+}
+
+// declare allocates a slot for a new local.
+func (f *vmFunc) declare(b *Binding) int {
+	s := f.temp()
+	if b != nil {
+		f.locals[b] = s
+		f.block.declared = append(f.block.declared, b)
+	}
+	return s
+}
+
+// temp allocates a slot freed with the current block.
+func (f *vmFunc) temp() int {
+	s := f.next
+	f.next++
+	f.p.slots = max(f.p.slots, f.next-1)
+	return s
+}
+
+// store pops the stack top into a newly declared local.
+func (f *vmFunc) store(n *NameExpr) {
+	s := f.declare(n.Binding)
+	if f.cc.captured[n.Binding] {
+		f.emit(opNewCell, s)
+	} else {
+		f.emit(opSetLocal, s)
+	}
+}
+
+// upvalue returns the index of b among f's upvalues, adding it (and, in
+// turn, to the enclosing functions) if needed.
+func (f *vmFunc) upvalue(b *Binding) int {
+	if i, ok := f.upIndex[b]; ok {
+		return i
+	}
+	if f.parent == nil {
+		f.cc.fail("unresolved local %s", b.OrigName)
+		return 0
+	}
+	if s, ok := f.parent.locals[b]; ok {
+		f.upSrc = append(f.upSrc, 0, s)
+	} else {
+		f.upSrc = append(f.upSrc, 1, f.parent.upvalue(b))
+	}
+	f.upIndex[b] = len(f.upSrc) / 2
+	return f.upIndex[b]
+}
+
+func (f *vmFunc) loadName(n *NameExpr) {
+	switch {
+	case n.Binding == nil:
+		f.emit(opGetGlobal, f.str(n.Name))
+	case f.hasLocal(n.Binding):
+		if f.cc.captured[n.Binding] {
+			f.emit(opGetCell, f.locals[n.Binding])
+		} else {
+			f.emit(opGetLocal, f.locals[n.Binding])
+		}
+	default:
+		f.emit(opGetUpval, f.upvalue(n.Binding))
+	}
+}
+
+func (f *vmFunc) storeName(n *NameExpr) {
+	switch {
+	case n.Binding == nil:
+		f.emit(opSetGlobal, f.str(n.Name))
+	case f.hasLocal(n.Binding):
+		if f.cc.captured[n.Binding] {
+			f.emit(opSetCell, f.locals[n.Binding])
+		} else {
+			f.emit(opSetLocal, f.locals[n.Binding])
+		}
+	default:
+		f.emit(opSetUpval, f.upvalue(n.Binding))
+	}
+}
+
+func (f *vmFunc) hasLocal(b *Binding) bool {
+	_, ok := f.locals[b]
+	return ok
+}
+
+func (f *vmFunc) stats(stats []Stat) {
+	for _, s := range stats {
+		f.stat(s)
+	}
+}
+
+// scoped compiles stats in a block of their own.
+func (f *vmFunc) scoped(stats []Stat) {
+	f.enter()
+	f.stats(stats)
+	f.leave()
+}
+
+func (f *vmFunc) stat(s Stat) {
+	switch v := s.(type) {
+	case *LocalStat:
+		if len(v.Values) == 0 {
+			for range v.Names {
+				f.emit(opLoadNil)
+			}
+		} else {
+			f.values(v.Values, len(v.Names))
+		}
+		// Declare after evaluating, popping the last value first.
+		slots := make([]int, len(v.Names))
+		for i, n := range v.Names {
+			slots[i] = f.declare(n.Binding)
+		}
+		for i := len(v.Names) - 1; i >= 0; i-- {
+			if f.cc.captured[v.Names[i].Binding] {
+				f.emit(opNewCell, slots[i])
+			} else {
+				f.emit(opSetLocal, slots[i])
+			}
+		}
+	case *LocalFuncStat:
+		if f.cc.captured[v.Name.Binding] {
+			f.emit(opLoadNil)
+			f.store(v.Name)
+			f.closure(v.Func, false)
+			f.storeName(v.Name)
+		} else {
+			f.closure(v.Func, false)
+			f.store(v.Name)
+		}
+	case *FuncStat:
+		switch t := v.Target.(type) {
+		case *NameExpr:
+			f.closure(v.Func, v.IsMethod)
+			f.storeName(t)
+		case *IndexExpr:
+			f.expr(t.Obj)
+			f.emit(opLoadK, f.str(t.Field))
+			f.closure(v.Func, v.IsMethod)
+			f.emit(opSetIndex)
+		}
+	case *AssignStat:
+		f.assign(v)
+	case *CallStat:
+		f.call(v.Call.(*CallExpr), 0)
+	case *DoStat:
+		f.scoped(v.Body)
+	case *WhileStat:
+		start := f.pc()
+		f.expr(v.Cond)
+		exit := f.jump(opJmpIfNot)
+		f.loop(v.Body, nil)
+		l := f.popLoop()
+		f.emit(opJmp, start)
+		f.patchLoop(l, f.pc(), start)
+		f.patch(exit, f.pc())
+	case *RepeatStat:
+		start := f.pc()
+		f.loops = append(f.loops, &vmLoop{})
+		f.enter()
+		f.stats(v.Body)
+		cont := f.pc()
+		f.expr(v.Cond)
+		f.leave()
+		l := f.popLoop()
+		at := f.jump(opJmpIfNot)
+		f.patch(at, start)
+		f.patchLoop(l, f.pc(), cont)
+	case *IfStat:
+		var ends []int
+		for i, cond := range v.Conds {
+			f.expr(cond)
+			next := f.jump(opJmpIfNot)
+			f.scoped(v.Blocks[i])
+			if i < len(v.Conds)-1 || v.HasElse {
+				ends = append(ends, f.jump(opJmp))
+			}
+			f.patch(next, f.pc())
+		}
+		if v.HasElse {
+			f.scoped(v.Else)
+		}
+		for _, at := range ends {
+			f.patch(at, f.pc())
+		}
+	case *NumericForStat:
+		f.enter()
+		base := f.temp()
+		f.temp()
+		f.temp()
+		f.expr(v.Start)
+		f.expr(v.Stop)
+		if v.Step != nil {
+			f.expr(v.Step)
+		} else {
+			f.emit(opLoadK, f.num(1))
+		}
+		f.emit(opSetLocal, base+2)
+		f.emit(opSetLocal, base+1)
+		f.emit(opSetLocal, base)
+		test := f.pc()
+		f.emit(opForTest, base, 0)
+		exit := len(f.p.code) - 1
+		f.emit(opGetLocal, base)
+		f.loop(v.Body, []*NameExpr{v.Var})
+		l := f.popLoop()
+		cont := f.pc()
+		f.emit(opForStep, base)
+		f.emit(opJmp, test)
+		f.patchLoop(l, f.pc(), cont)
+		f.patch(exit, f.pc())
+		f.leave()
+	case *GenericForStat:
+		f.enter()
+		base := f.temp()
+		f.temp()
+		f.temp()
+		f.values(v.Exprs, 3)
+		f.emit(opSetLocal, base+2)
+		f.emit(opSetLocal, base+1)
+		f.emit(opSetLocal, base)
+		f.emit(opGForPrep, base)
+		test := f.pc()
+		f.emit(opTForCall, base, len(v.Vars), 0)
+		exit := len(f.p.code) - 1
+		f.loop(v.Body, v.Vars)
+		l := f.popLoop()
+		f.emit(opJmp, test)
+		f.patchLoop(l, f.pc(), test)
+		f.patch(exit, f.pc())
+		f.leave()
+	case *ReturnStat:
+		switch {
+		case len(v.Values) == 1 && !isMulti(v.Values[0]):
+			f.expr(v.Values[0])
+			f.emit(opRet, 2)
+		case len(v.Values) == 0:
+			f.emit(opRet, 1)
+		default:
+			f.emit(opMark)
+			f.exprList(v.Values)
+			f.emit(opRet, 0)
+		}
+	case *BreakStat:
+		if len(f.loops) == 0 {
+			f.cc.fail("break outside a loop")
+			return
+		}
+		l := f.loops[len(f.loops)-1]
+		l.breaks = append(l.breaks, f.jump(opJmp))
+	case *ContinueStat:
+		if len(f.loops) == 0 {
+			f.cc.fail("continue outside a loop")
+			return
+		}
+		l := f.loops[len(f.loops)-1]
+		l.conts = append(l.conts, f.jump(opJmp))
+	case *GotoStat:
+		at := f.jump(opJmp)
+		for b := f.block; b != nil; b = b.parent {
+			if pc, ok := b.labels[v.Label]; ok {
+				f.patch(at, pc)
+				return
+			}
+		}
+		f.block.gotos = append(f.block.gotos, vmGoto{v.Label, at})
+	case *LabelStat:
+		pc := f.pc()
+		f.block.labels[v.Name] = pc
+		pending := f.block.gotos[:0]
+		for _, g := range f.block.gotos {
+			if g.label == v.Name {
+				f.patch(g.at, pc)
+			} else {
+				pending = append(pending, g)
+			}
+		}
+		f.block.gotos = pending
+	case *TypeAliasStat:
+		// types have no runtime effect
+	default:
+		f.cc.fail("unsupported statement %T", s)
+	}
+}
+
+// loop compiles a loop body in its own block, first declaring vars from the
+// values on the stack (the last one on top).
+func (f *vmFunc) loop(body []Stat, vars []*NameExpr) {
+	f.loops = append(f.loops, &vmLoop{})
+	f.enter()
+	slots := make([]int, len(vars))
+	for i, n := range vars {
+		slots[i] = f.declare(n.Binding)
+	}
+	for i := len(vars) - 1; i >= 0; i-- {
+		if f.cc.captured[vars[i].Binding] {
+			f.emit(opNewCell, slots[i])
+		} else {
+			f.emit(opSetLocal, slots[i])
+		}
+	}
+	f.stats(body)
+	f.leave()
+}
+
+func (f *vmFunc) popLoop() *vmLoop {
+	l := f.loops[len(f.loops)-1]
+	f.loops = f.loops[:len(f.loops)-1]
+	return l
+}
+
+func (f *vmFunc) patchLoop(l *vmLoop, exit, cont int) {
+	for _, at := range l.breaks {
+		f.patch(at, exit)
+	}
+	for _, at := range l.conts {
+		f.patch(at, cont)
+	}
+}
+
+func (f *vmFunc) assign(v *AssignStat) {
+	if v.Op != "=" {
+		op, ok := vmBinOps[strings.TrimSuffix(v.Op, "=")]
+		if !ok || len(v.Targets) != 1 || len(v.Values) != 1 {
+			f.cc.fail("unsupported assignment %s", v.Op)
+			return
+		}
+		switch t := v.Targets[0].(type) {
+		case *NameExpr:
+			f.loadName(t)
+			f.expr(v.Values[0])
+			f.emit(op)
+			f.storeName(t)
+		case *IndexExpr:
+			f.indexTarget(t)
+			f.emit(opDup2)
+			f.emit(opGetIndex)
+			f.expr(v.Values[0])
+			f.emit(op)
+			f.emit(opSetIndex)
+		}
+		return
+	}
+	if len(v.Targets) == 1 {
+		switch t := v.Targets[0].(type) {
+		case *NameExpr:
+			f.expr(v.Values[0])
+			for _, extra := range v.Values[1:] {
+				f.expr(extra)
+				f.emit(opPop)
+			}
+			f.storeName(t)
+		case *IndexExpr:
+			f.indexTarget(t)
+			f.expr(v.Values[0])
+			for _, extra := range v.Values[1:] {
+				f.expr(extra)
+				f.emit(opPop)
+			}
+			f.emit(opSetIndex)
+		}
+		return
+	}
+	// Evaluate every value first, then assign left to right.
+	f.values(v.Values, len(v.Targets))
+	f.enter()
+	tmps := make([]int, len(v.Targets))
+	for i := range tmps {
+		tmps[i] = f.temp()
+	}
+	for i := len(tmps) - 1; i >= 0; i-- {
+		f.emit(opSetLocal, tmps[i])
+	}
+	for i, target := range v.Targets {
+		switch t := target.(type) {
+		case *NameExpr:
+			f.emit(opGetLocal, tmps[i])
+			f.storeName(t)
+		case *IndexExpr:
+			f.indexTarget(t)
+			f.emit(opGetLocal, tmps[i])
+			f.emit(opSetIndex)
+		}
+	}
+	f.leave()
+}
+
+// indexTarget pushes the object and key of an assignment target.
+func (f *vmFunc) indexTarget(t *IndexExpr) {
+	f.expr(t.Obj)
+	if t.Key != nil {
+		f.expr(t.Key)
+	} else {
+		f.emit(opLoadK, f.str(t.Field))
+	}
+}
+
+// values pushes exactly n values for xs (nil-padded or truncated).
+func (f *vmFunc) values(xs []Expr, n int) {
+	if len(xs) == n && !isMulti(xs[n-1]) {
+		f.exprList(xs)
+		return
+	}
+	f.emit(opMark)
+	f.exprList(xs)
+	f.emit(opAdjust, n)
+}
+
+// exprList pushes xs, expanding a trailing call or vararg to all its values.
+func (f *vmFunc) exprList(xs []Expr) {
+	for i, x := range xs {
+		if i == len(xs)-1 && isMulti(x) {
+			f.multi(x)
+		} else {
+			f.expr(x)
+		}
+	}
+}
+
+func isMulti(x Expr) bool {
+	switch x.(type) {
+	case *CallExpr, *VarargExpr:
+		return true
+	}
+	return false
+}
+
+// multi pushes every value of a call or vararg.
+func (f *vmFunc) multi(x Expr) {
+	if c, ok := x.(*CallExpr); ok {
+		f.call(c, -1)
+	} else {
+		f.usesVararg = true
+		f.emit(opVararg, 0)
+	}
+}
+
+// call compiles a call keeping nres results (-1 for all).
+func (f *vmFunc) call(c *CallExpr, nres int) {
+	spread := len(c.Args) > 0 && isMulti(c.Args[len(c.Args)-1])
+	nargs := len(c.Args)
+	if m, ok := c.Fn.(*IndexExpr); ok && m.IsMethod {
+		f.expr(m.Obj)
+		if spread {
+			f.emit(opMark)
+		}
+		f.emit(opSelf, f.str(m.Field))
+		nargs++
+	} else {
+		f.expr(c.Fn)
+		if spread {
+			f.emit(opMark)
+		}
+	}
+	f.exprList(c.Args)
+	a := nargs + 1
+	if spread {
+		a = 0
+	}
+	f.emit(opCall, a, nres+1)
+}
+
+// expr pushes exactly one value.
+func (f *vmFunc) expr(x Expr) {
+	switch v := x.(type) {
+	case *NilExpr:
+		f.emit(opLoadNil)
+	case *BoolExpr:
+		if v.Val {
+			f.emit(opLoadTrue)
+		} else {
+			f.emit(opLoadFalse)
+		}
+	case *NumberExpr:
+		f.emit(opLoadK, f.constant(vmConst{num: true, text: v.Text}))
+	case *StringExpr:
+		s, ok := unquoteLuaString(v.Text)
+		if !ok {
+			f.cc.fail("unsupported string literal %s", v.Text)
+		}
+		f.emit(opLoadK, f.str(s))
+	case *InterpExpr:
+		concat, ok := interpConcat(v)
+		if !ok {
+			f.cc.fail("unsupported interpolated string")
+			return
+		}
+		f.expr(concat)
+	case *VarargExpr:
+		f.usesVararg = true
+		f.emit(opVararg, 2)
+	case *NameExpr:
+		f.loadName(v)
+	case *ParenExpr:
+		f.expr(v.E)
+	case *IndexExpr:
+		f.expr(v.Obj)
+		if v.Key != nil {
+			f.expr(v.Key)
+		} else {
+			f.emit(opLoadK, f.str(v.Field))
+		}
+		f.emit(opGetIndex)
+	case *CallExpr:
+		f.call(v, 1)
+	case *FuncExpr:
+		f.closure(v, false)
+	case *TableExpr:
+		f.table(v)
+	case *BinExpr:
+		if v.Op == "and" || v.Op == "or" {
+			f.expr(v.L)
+			f.emit(opDup)
+			jump := opJmpIfNot
+			if v.Op == "or" {
+				jump = opJmpIf
+			}
+			end := f.jump(jump)
+			f.emit(opPop)
+			f.expr(v.R)
+			f.patch(end, f.pc())
+			return
+		}
+		op, ok := vmBinOps[v.Op]
+		if !ok {
+			f.cc.fail("unsupported operator %s", v.Op)
+			return
+		}
+		f.expr(v.L)
+		f.expr(v.R)
+		f.emit(op)
+	case *UnExpr:
+		f.expr(v.E)
+		switch v.Op {
+		case "-":
+			f.emit(opNeg)
+		case "not":
+			f.emit(opNot)
+		case "#":
+			f.emit(opLen)
+		default:
+			f.cc.fail("unsupported operator %s", v.Op)
+		}
+	case *IfExpr:
+		conds := append([]Expr{v.Cond}, v.ElifConds...)
+		thens := append([]Expr{v.Then}, v.ElifThen...)
+		var ends []int
+		for i := range conds {
+			f.expr(conds[i])
+			next := f.jump(opJmpIfNot)
+			f.expr(thens[i])
+			ends = append(ends, f.jump(opJmp))
+			f.patch(next, f.pc())
+		}
+		f.expr(v.Else)
+		for _, at := range ends {
+			f.patch(at, f.pc())
+		}
+	default:
+		f.cc.fail("unsupported expression %T", x)
+	}
+}
+
+func (f *vmFunc) table(t *TableExpr) {
+	f.emit(opNewTable)
+	index := 1
+	for i, field := range t.Fields {
+		f.emit(opDup)
+		switch {
+		case field.Key != nil:
+			f.expr(field.Key)
+		case field.KeyName != "":
+			f.emit(opLoadK, f.str(field.KeyName))
+		case i == len(t.Fields)-1 && isMulti(field.Value):
+			f.emit(opMark)
+			f.multi(field.Value)
+			f.emit(opAppend, index)
+			continue
+		default:
+			f.emit(opLoadK, f.num(index))
+			index++
+		}
+		f.expr(field.Value)
+		f.emit(opSetIndex)
+	}
+}
+
+// closure compiles fn as a child proto and pushes a closure of it.
+func (f *vmFunc) closure(fn *FuncExpr, isMethod bool) {
+	if isMethod && fn.Self == nil {
+		fn.Self = &Binding{OrigName: "self"}
+	}
+	child := f.cc.function(f, fn, fn.Body, fn.IsVararg)
+	f.p.protos = append(f.p.protos, child.p)
+	f.emit(opClosure, len(f.p.protos), len(child.upSrc)/2)
+	f.p.code = append(f.p.code, child.upSrc...)
+}
+
+// vmWriter serializes protos as unsigned varints (7 bits per byte, low
+// groups first):
+//
+//	proto := params vararg slots #consts const* #code code* #protos proto*
+//	const := 0 len bytes (number text) | 1 len bytes (string)
+type vmWriter struct{ buf []byte }
+
+func (w *vmWriter) uint(n int) {
+	for n >= 0x80 {
+		w.buf = append(w.buf, byte(n&0x7f|0x80))
+		n >>= 7
+	}
+	w.buf = append(w.buf, byte(n))
+}
+
+func (w *vmWriter) bytes(s string) {
+	w.uint(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *vmWriter) proto(p *vmProto) {
+	w.uint(p.params)
+	if p.vararg {
+		w.uint(1)
+	} else {
+		w.uint(0)
+	}
+	w.uint(p.slots)
+	w.uint(len(p.consts))
+	for _, k := range p.consts {
+		if k.num {
+			w.uint(0)
+		} else {
+			w.uint(1)
+		}
+		w.bytes(k.text)
+	}
+	w.uint(len(p.code))
+	for _, c := range p.code {
+		w.uint(c)
+	}
+	w.uint(len(p.protos))
+	for _, child := range p.protos {
+		w.proto(child)
+	}
+}
+
+// masked returns the serialized bytes shifted by the keystream the runtime
+// removes: h = (h*streamMul + streamInc) % streamMod, byte + top 8 bits of h.
+func (w *vmWriter) masked(seed uint32) string {
+	out := make([]byte, len(w.buf))
+	h := seed % streamMod
+	for i, c := range w.buf {
+		h = (h*streamMul + streamInc) % streamMod
+		out[i] = c + byte(h>>16)
+	}
+	return string(out)
+}
+
+// Runtime returns the `local <vm.Name>=...` definition of the interpreter for
+// chunks compiled with vm: plain Lua 5.1 / Luau without bit32, goto or
+// load/loadstring.
+func (vm *VM) Runtime() string {
+	pairs := make([]string, 0, 2*numVMOps+2)
+	pairs = append(pairs, "@VM@", vm.Name)
+	for op, name := range vmOpNames {
+		pairs = append(pairs, "@"+name+"@", strconv.Itoa(vm.ops[op]))
+	}
+	pairs = append(pairs,
+		"@KMUL@", strconv.Itoa(streamMul), "@KINC@", strconv.Itoa(streamInc),
+		"@KMOD@", strconv.Itoa(streamMod), "@KSHIFT@", strconv.Itoa(1<<16))
+	return strings.NewReplacer(pairs...).Replace(vmRuntime)
+}
+
+// interpConcat rewrites `a{x}b` as "a"..tostring(x).."b", which is how Luau
+// evaluates it, or reports false if a part has an escape the bundler cannot
+// decode.
+func interpConcat(v *InterpExpr) (Expr, bool) {
+	var out Expr
+	add := func(x Expr) {
+		if out == nil {
+			out = x
+		} else {
+			out = &BinExpr{Op: "..", L: out, R: x}
+		}
+	}
+	for i, part := range v.Parts {
+		s, ok := unquoteInterpPart(part)
+		if !ok {
+			return nil, false
+		}
+		if s != "" || len(v.Exprs) == 0 {
+			add(StringLiteral(s))
+		}
+		if i < len(v.Exprs) {
+			add(&CallExpr{Fn: &NameExpr{Name: "tostring"}, Args: []Expr{v.Exprs[i]}})
+		}
+	}
+	return out, true
+}
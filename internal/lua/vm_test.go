@@ -0,0 +1,563 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The tests below decode Compile's output with the keystream and run it on a
+// Go port of vmRuntime's dispatch loop, comparing the values passed to emit().
+
+type vmTestProto struct {
+	np, ns int
+	va     bool
+	k      []any
+	c      []int
+	p      []*vmTestProto
+}
+
+type vmFn func(args []any) []any
+
+type vmTable struct{ m map[any]any }
+
+type vmCell struct{ v any }
+
+// vmDecode unmasks and reads a serialized proto like the runtime's load.
+func vmDecode(t *testing.T, s string, h uint32) *vmTestProto {
+	t.Helper()
+	pos := 0
+	u8 := func() byte {
+		h = (h*streamMul + streamInc) % streamMod
+		b := s[pos] - byte(h>>16)
+		pos++
+		return b
+	}
+	uint := func() int {
+		n, m := 0, 1
+		for {
+			b := u8()
+			n += int(b&0x7f) * m
+			if b < 0x80 {
+				return n
+			}
+			m *= 128
+		}
+	}
+	str := func() string {
+		b := make([]byte, uint())
+		for i := range b {
+			b[i] = u8()
+		}
+		return string(b)
+	}
+	var proto func() *vmTestProto
+	proto = func() *vmTestProto {
+		p := &vmTestProto{np: uint(), va: uint() == 1, ns: uint()}
+		for range uint() {
+			if uint() == 0 {
+				text := str()
+				n, err := strconv.ParseFloat(text, 64)
+				if i, ierr := strconv.ParseInt(text, 0, 64); ierr == nil {
+					n, err = float64(i), nil
+				}
+				if err != nil {
+					t.Fatalf("number constant: %v", err)
+				}
+				p.k = append(p.k, n)
+			} else {
+				p.k = append(p.k, str())
+			}
+		}
+		for range uint() {
+			p.c = append(p.c, uint())
+		}
+		for range uint() {
+			p.p = append(p.p, proto())
+		}
+		return p
+	}
+	p := proto()
+	if pos != len(s) {
+		t.Fatalf("decoded %d of %d bytes", pos, len(s))
+	}
+	return p
+}
+
+type vmMachine struct {
+	ops   map[int]vmOp
+	env   map[any]any
+	trace []string
+	steps int
+}
+
+func newVMMachine(vm *VM) *vmMachine {
+	m := &vmMachine{ops: map[int]vmOp{}, env: map[any]any{}}
+	for op, n := range vm.ops {
+		m.ops[n] = vmOp(op)
+	}
+	m.env["emit"] = vmFn(func(args []any) []any {
+		for _, a := range args {
+			m.trace = append(m.trace, vmString(a))
+		}
+		return nil
+	})
+	m.env["tostring"] = vmFn(func(args []any) []any {
+		return []any{vmString(args[0])}
+	})
+	m.env["ipairs"] = vmFn(func(args []any) []any {
+		return []any{vmFn(func(a []any) []any {
+			i := a[1].(float64) + 1
+			if v := a[0].(*vmTable).m[i]; v != nil {
+				return []any{i, v}
+			}
+			return nil
+		}), args[0], 0.0}
+	})
+	return m
+}
+
+func vmString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func vmTruthy(v any) bool {
+	b, isBool := v.(bool)
+	return v != nil && (!isBool || b)
+}
+
+func vmLen(v any) float64 {
+	if s, ok := v.(string); ok {
+		return float64(len(s))
+	}
+	n := 0.0
+	for v.(*vmTable).m[n+1] != nil {
+		n++
+	}
+	return n
+}
+
+// vmNext is next() over keys in a fixed order: numbers, then strings.
+func vmNext(args []any) []any {
+	t := args[0].(*vmTable)
+	var keys []any
+	for k := range t.m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b any) int { return strings.Compare(fmt.Sprintf("%T%v", a, a), fmt.Sprintf("%T%v", b, b)) })
+	i := 0
+	if args[1] != nil {
+		i = slices.Index(keys, args[1]) + 1
+	}
+	if i >= len(keys) {
+		return []any{nil}
+	}
+	return []any{keys[i], t.m[keys[i]]}
+}
+
+func (m *vmMachine) closure(p *vmTestProto, up []*vmCell) vmFn {
+	return func(args []any) []any { return m.run(p, up, args) }
+}
+
+func (m *vmMachine) run(p *vmTestProto, up []*vmCell, args []any) []any {
+	R := make([]any, p.ns+1)
+	for i := 1; i <= p.np && i <= len(args); i++ {
+		R[i] = args[i-1]
+	}
+	var va []any
+	if p.va && len(args) > p.np {
+		va = args[p.np:]
+	}
+	var S []any
+	var M []int
+	pc := 0
+	next := func() int { pc++; return p.c[pc-1] }
+	push := func(v ...any) { S = append(S, v...) }
+	pop := func() any { v := S[len(S)-1]; S = S[:len(S)-1]; return v }
+	popMark := func() int { n := M[len(M)-1]; M = M[:len(M)-1]; return n }
+	arith := func(f func(a, b float64) any) { b, a := pop().(float64), pop().(float64); push(f(a, b)) }
+	for {
+		if m.steps++; m.steps > 1_000_000 {
+			panic("step limit")
+		}
+		switch op := m.ops[next()]; op {
+		case opLoadK:
+			push(p.k[next()-1])
+		case opLoadNil:
+			push(nil)
+		case opLoadTrue:
+			push(true)
+		case opLoadFalse:
+			push(false)
+		case opGetLocal:
+			push(R[next()])
+		case opSetLocal:
+			R[next()] = pop()
+		case opNewCell:
+			R[next()] = &vmCell{pop()}
+		case opGetCell:
+			push(R[next()].(*vmCell).v)
+		case opSetCell:
+			R[next()].(*vmCell).v = pop()
+		case opBox:
+			s := next()
+			R[s] = &vmCell{R[s]}
+		case opGetUpval:
+			push(up[next()-1].v)
+		case opSetUpval:
+			up[next()-1].v = pop()
+		case opGetGlobal:
+			push(m.env[p.k[next()-1]])
+		case opSetGlobal:
+			m.env[p.k[next()-1]] = pop()
+		case opGetIndex:
+			k := pop()
+			push(pop().(*vmTable).m[k])
+		case opSetIndex:
+			v, k := pop(), pop()
+			pop().(*vmTable).m[k] = v
+		case opSelf:
+			o := pop()
+			push(o.(*vmTable).m[p.k[next()-1]], o)
+		case opNewTable:
+			push(&vmTable{map[any]any{}})
+		case opAppend:
+			i, b := float64(next()), popMark()
+			t := S[b-1].(*vmTable)
+			for _, v := range S[b:] {
+				t.m[i] = v
+				i++
+			}
+			S = S[:b-1]
+		case opMark:
+			M = append(M, len(S))
+		case opAdjust:
+			n := popMark() + next()
+			for len(S) < n {
+				push(nil)
+			}
+			S = S[:n]
+		case opCall:
+			a, r := next(), next()
+			base := len(S) - a
+			if a == 0 {
+				base = popMark() - 1
+			}
+			fn, callArgs := S[base].(vmFn), slices.Clone(S[base+1:])
+			S = S[:base]
+			res := fn(callArgs)
+			if r > 0 {
+				for len(res) < r-1 {
+					res = append(res, nil)
+				}
+				res = res[:r-1]
+			}
+			push(res...)
+		case opVararg:
+			if n := next(); n == 0 {
+				push(va...)
+			} else {
+				for i := range n - 1 {
+					if i < len(va) {
+						push(va[i])
+					} else {
+						push(nil)
+					}
+				}
+			}
+		case opClosure:
+			cp, n := p.p[next()-1], next()
+			u := make([]*vmCell, n)
+			for i := range u {
+				if kind, idx := next(), next(); kind == 0 {
+					u[i] = R[idx].(*vmCell)
+				} else {
+					u[i] = up[idx-1]
+				}
+			}
+			push(m.closure(cp, u))
+		case opRet:
+			if n := next(); n == 0 {
+				return S[M[len(M)-1]:]
+			} else {
+				return S[len(S)-(n-1):]
+			}
+		case opJmp:
+			pc = p.c[pc] - 1
+		case opJmpIf, opJmpIfNot:
+			if vmTruthy(pop()) == (op == opJmpIf) {
+				pc = p.c[pc] - 1
+			} else {
+				pc++
+			}
+		case opDup:
+			push(S[len(S)-1])
+		case opDup2:
+			push(S[len(S)-2], S[len(S)-1])
+		case opPop:
+			pop()
+		case opAdd:
+			arith(func(a, b float64) any { return a + b })
+		case opSub:
+			arith(func(a, b float64) any { return a - b })
+		case opMul:
+			arith(func(a, b float64) any { return a * b })
+		case opDiv:
+			arith(func(a, b float64) any { return a / b })
+		case opIDiv:
+			arith(func(a, b float64) any { return math.Floor(a / b) })
+		case opMod:
+			arith(func(a, b float64) any { return a - math.Floor(a/b)*b })
+		case opPow:
+			arith(func(a, b float64) any { return math.Pow(a, b) })
+		case opLt:
+			arith(func(a, b float64) any { return a < b })
+		case opLe:
+			arith(func(a, b float64) any { return a <= b })
+		case opGt:
+			arith(func(a, b float64) any { return a > b })
+		case opGe:
+			arith(func(a, b float64) any { return a >= b })
+		case opConcat:
+			b, a := pop(), pop()
+			push(vmString(a) + vmString(b))
+		case opEq, opNe:
+			b, a := pop(), pop()
+			push((a == b) == (op == opEq))
+		case opNeg:
+			push(-pop().(float64))
+		case opNot:
+			push(!vmTruthy(pop()))
+		case opLen:
+			push(vmLen(pop()))
+		case opForTest:
+			s := next()
+			i, lim, st := R[s].(float64), R[s+1].(float64), R[s+2].(float64)
+			if st > 0 && i <= lim || st <= 0 && i >= lim {
+				pc++
+			} else {
+				pc = p.c[pc] - 1
+			}
+		case opForStep:
+			s := next()
+			R[s] = R[s].(float64) + R[s+2].(float64)
+		case opGForPrep:
+			s := next()
+			if _, ok := R[s].(*vmTable); ok {
+				R[s], R[s+1], R[s+2] = vmFn(vmNext), R[s], nil
+			}
+		case opTForCall:
+			s, n, exit := next(), next(), next()
+			res := R[s].(vmFn)([]any{R[s+1], R[s+2]})
+			if len(res) == 0 || res[0] == nil {
+				pc = exit - 1
+				continue
+			}
+			R[s+2] = res[0]
+			for i := range n {
+				if i < len(res) {
+					push(res[i])
+				} else {
+					push(nil)
+				}
+			}
+		default:
+			panic(fmt.Sprintf("unknown opcode %d", p.c[pc-1]))
+		}
+	}
+}
+
+// vmRun compiles src, checks the output's shape and runs it.
+func vmRun(t *testing.T, vm *VM, src string) (string, string) {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := vm.Compile(c)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	oc, err := Parse(out)
+	if err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, out)
+	}
+	ret := oc.Body[0].(*ReturnStat).Values[0].(*CallExpr)
+	load := ret.Fn.(*CallExpr)
+	if name := load.Fn.(*NameExpr).Name; name != vm.Name {
+		t.Fatalf("output calls %s, want %s", name, vm.Name)
+	}
+	bytecode, _ := unquoteLuaString(load.Args[0].(*StringExpr).Text)
+	seed, _ := strconv.Atoi(load.Args[1].(*NumberExpr).Text)
+	m := newVMMachine(vm)
+	m.closure(vmDecode(t, bytecode, uint32(seed)), nil)(nil)
+	return strings.Join(m.trace, " "), out
+}
+
+var vmPrograms = []struct{ name, src, want string }{
+	{"closures", `
+		local function counter()
+			local n = 0
+			return function() n = n + 1 return n end
+		end
+		local a, b = counter(), counter()
+		a() a()
+		emit(a(), b())
+		local function outer()
+			local x = 1
+			local function mid() return function() x = x + 1 return x end end
+			return mid(), function() return x end
+		end
+		local inc, get = outer()
+		inc() inc()
+		emit(get())
+		local fs = {}
+		for i = 1, 3 do fs[i] = function() return i end end
+		emit(fs[1](), fs[2](), fs[3]())
+		local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end
+		emit(fib(10))`,
+		"3 1 3 1 2 3 55"},
+	{"varargs", `
+		local function three() return 1, 2, 3 end
+		local function count(...) local t = {...} return #t end
+		local t, u = {three()}, {three(), 10}
+		emit(#t, #u, count(three()), count(three(), three()))
+		local x, y = three()
+		emit(x, y)
+		local function pass(...) return ... end
+		emit(pass(4, 5))
+		local function first(...) local a = ... return a end
+		emit(first(6, 7), (three()))`,
+		"3 2 3 4 1 2 4 5 6 1"},
+	{"tables and methods", `
+		local Account = {}
+		function Account.new(b) return {balance = b, deposit = Account.deposit} end
+		function Account:deposit(v) self.balance = self.balance + v return self end
+		local acc = Account.new(10)
+		acc:deposit(5):deposit(2)
+		emit(acc.balance)
+		local t = {x = 1, ["y"] = 2, 3, 4}
+		t.x += 10
+		t[1] *= 5
+		emit(t.x, t.y, t[1], t[2], #t)`,
+		"17 11 2 15 4 2"},
+	{"control flow", `
+		local s = 0
+		for i = 10, 1, -3 do s = s + i end
+		emit(s)
+		local n = 0
+		repeat local m = n n = n + 1 until m >= 3
+		emit(n)
+		for i = 1, 5 do
+			if i % 2 == 0 then continue end
+			if i == 5 then break end
+			emit(i)
+		end
+		local k = 0
+		::top::
+		k = k + 1
+		if k < 3 then goto top end
+		emit(k)
+		local a, b = 1, 2
+		a, b = b, a
+		emit(a, b, a and b, nil or "d", false and 1, if a > b then "gt" else "le")
+		local sum = 0
+		for i, v in ipairs({5, 6, 7}) do sum = sum + i * v end
+		local total = 0
+		for _, v in {a = 1, b = 2} do total = total + v end
+		local w = 0
+		while w < 5 do w = w + 2 end
+		emit(sum, total, w)`,
+		"22 4 1 3 3 2 1 1 d false gt 38 3 6"},
+	{"globals and operators", `
+		greeting = "hi"
+		local function shout(s) return s .. "!" end
+		emit(shout(greeting), #greeting, 7 // 2, 2 ^ 3, 7 % 3, -(1), not nil, 0x10, 1.5e1)`,
+		"hi! 2 3 8 1 -1 true 16 15"},
+	{"interpolation", `
+		local n, who = 2, "vm"
+		emit(` + "`{who} has {n + 1} \\{ops\\}`, `{n}`, `plain`, `{ {n}} ok` ~= nil" + `)`,
+		"vm has 3 {ops} 2 plain true"},
+}
+
+func TestVM_RunsCompiledPrograms(t *testing.T) {
+	for _, tc := range vmPrograms {
+		vm := NewVM("_0xvm")
+		got, out := vmRun(t, vm, tc.src)
+		if got != tc.want {
+			t.Errorf("%s: trace %q, want %q", tc.name, got, tc.want)
+		}
+		if strings.Contains(out, "emit") || strings.Contains(out, "deposit") {
+			t.Errorf("%s: names leak into the output: %s", tc.name, out)
+		}
+	}
+}
+
+func TestVM_OpcodesArePerBuild(t *testing.T) {
+	a, b := NewVM("_0xvm"), NewVM("_0xvm")
+	if a.ops == b.ops {
+		t.Fatal("two VMs drew the same opcode numbering")
+	}
+	seen := map[int]bool{}
+	for _, n := range a.ops {
+		if seen[n] {
+			t.Fatalf("opcode number %d used twice", n)
+		}
+		seen[n] = true
+	}
+}
+
+func TestVM_Runtime(t *testing.T) {
+	vm := NewVM("_0xvm")
+	rt := vm.Runtime()
+	if !strings.HasPrefix(rt, "local _0xvm=") || strings.Contains(rt, "@") {
+		t.Fatalf("runtime placeholders not replaced:\n%s", rt)
+	}
+	if _, err := Parse(rt); err != nil {
+		t.Fatalf("runtime does not parse: %v", err)
+	}
+	for op, name := range vmOpNames {
+		if !strings.Contains(rt, "op=="+strconv.Itoa(vm.ops[op])+" then") {
+			t.Errorf("runtime has no handler for %s", name)
+		}
+	}
+}
+
+func TestVM_CompileRejects(t *testing.T) {
+	for _, src := range []string{
+		"goto nowhere",
+		"return `bad \\z{1}`",
+	} {
+		c, err := Parse(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		if _, err := NewVM("_0xvm").Compile(c); err == nil {
+			t.Errorf("%q should not compile", src)
+		}
+	}
+}
+
+func TestVM_VarargOnlyWhenUsed(t *testing.T) {
+	vm := NewVM("_0xvm")
+	for src, want := range map[string]string{
+		"return 1":                               ")()",
+		"local a = ... return a":                 ")(...)",
+		"local f = function(...) return ... end": ")()",
+	} {
+		c, _ := Parse(src)
+		out, err := vm.Compile(c)
+		if err != nil || !strings.HasSuffix(out, want) {
+			t.Errorf("%q: got %q, %v; want suffix %q", src, out, err, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package lua
+
+// vmRuntime is the interpreter for Compile's bytecode. @NAME@ placeholders
+// are replaced by Runtime with the build's opcode numbers and the keystream
+// parameters. R holds a frame's locals (captured ones boxed in cells), S its
+// operand stack with top T, and M the stack positions saved by MARK.
+const vmRuntime = `local @VM@=(function()
+local E=getfenv and getfenv(1) or _ENV
+local unpack,select,type,next,getmetatable,tonumber=table.unpack or unpack,select,type,next,getmetatable,tonumber
+local byte,char,concat,floor=string.byte,string.char,table.concat,math.floor
+local function pack(...) return {n=select("#",...),...} end
+local function load(s,h)
+	local pos=1
+	local function u8()
+		h=(h*@KMUL@+@KINC@)%@KMOD@
+		local b=(byte(s,pos)-floor(h/@KSHIFT@))%256
+		pos=pos+1
+		return b
+	end
+	local function uint()
+		local n,m=0,1
+		while true do
+			local b=u8()
+			n=n+(b%128)*m
+			if b<128 then return n end
+			m=m*128
+		end
+	end
+	local function str()
+		local t={}
+		for i=1,uint() do t[i]=char(u8()) end
+		return concat(t)
+	end
+	local function proto()
+		local p={np=uint(),va=uint()==1,ns=uint(),k={},c={},p={}}
+		for i=1,uint() do
+			if uint()==0 then p.k[i]=tonumber(str()) else p.k[i]=str() end
+		end
+		for i=1,uint() do p.c[i]=uint() end
+		for i=1,uint() do p.p[i]=proto() end
+		return p
+	end
+	return proto()
+end
+local run
+local function closure(p,U)
+	return function(...) return run(p,U,pack(...)) end
+end
+run=function(p,U,args)
+	local code,K,P=p.c,p.k,p.p
+	local R,S,M={},{},{}
+	local T,MT,pc=0,0,1
+	for i=1,p.np do R[i]=args[i] end
+	local va,nva={},0
+	if p.va then
+		for i=p.np+1,args.n do nva=nva+1 va[nva]=args[i] end
+	end
+	while true do
+		local op=code[pc]
+		pc=pc+1
+		if op==@GETLOCAL@ then
+			T=T+1 S[T]=R[code[pc]] pc=pc+1
+		elseif op==@LOADK@ then
+			T=T+1 S[T]=K[code[pc]] pc=pc+1
+		elseif op==@SETLOCAL@ then
+			R[code[pc]]=S[T] T=T-1 pc=pc+1
+		elseif op==@GETINDEX@ then
+			local k=S[T] T=T-1 S[T]=S[T][k]
+		elseif op==@CALL@ then
+			local a,r=code[pc],code[pc+1]
+			pc=pc+2
+			local base
+			if a==0 then base=M[MT] MT=MT-1 else base=T-a+1 end
+			local res=pack(S[base](unpack(S,base+1,T)))
+			T=base-1
+			if r==0 then r=res.n else r=r-1 end
+			for i=1,r do T=T+1 S[T]=res[i] end
+		elseif op==@GETGLOBAL@ then
+			T=T+1 S[T]=E[K[code[pc]]] pc=pc+1
+		elseif op==@JMPIFNOT@ then
+			local v=S[T] T=T-1
+			if v then pc=pc+1 else pc=code[pc] end
+		elseif op==@JMP@ then
+			pc=code[pc]
+		elseif op==@SETINDEX@ then
+			S[T-2][S[T-1]]=S[T] T=T-3
+		elseif op==@SELF@ then
+			local o=S[T] S[T]=o[K[code[pc]]] T=T+1 S[T]=o pc=pc+1
+		elseif op==@RET@ then
+			local n=code[pc]
+			if n==0 then return unpack(S,M[MT]+1,T) end
+			return unpack(S,T-n+2,T)
+		elseif op==@LOADNIL@ then
+			T=T+1 S[T]=nil
+		elseif op==@LOADTRUE@ then
+			T=T+1 S[T]=true
+		elseif op==@LOADFALSE@ then
+			T=T+1 S[T]=false
+		elseif op==@NEWCELL@ then
+			R[code[pc]]={S[T]} T=T-1 pc=pc+1
+		elseif op==@GETCELL@ then
+			T=T+1 S[T]=R[code[pc]][1] pc=pc+1
+		elseif op==@SETCELL@ then
+			R[code[pc]][1]=S[T] T=T-1 pc=pc+1
+		elseif op==@BOX@ then
+			local s=code[pc] R[s]={R[s]} pc=pc+1
+		elseif op==@GETUPVAL@ then
+			T=T+1 S[T]=U[code[pc]][1] pc=pc+1
+		elseif op==@SETUPVAL@ then
+			U[code[pc]][1]=S[T] T=T-1 pc=pc+1
+		elseif op==@SETGLOBAL@ then
+			E[K[code[pc]]]=S[T] T=T-1 pc=pc+1
+		elseif op==@NEWTABLE@ then
+			T=T+1 S[T]={}
+		elseif op==@APPEND@ then
+			local i,b=code[pc],M[MT]
+			pc=pc+1 MT=MT-1
+			local t=S[b]
+			for j=b+1,T do t[i]=S[j] i=i+1 end
+			T=b-1
+		elseif op==@MARK@ then
+			MT=MT+1 M[MT]=T
+		elseif op==@ADJUST@ then
+			local n=M[MT]+code[pc]
+			pc=pc+1 MT=MT-1
+			for i=T+1,n do S[i]=nil end
+			T=n
+		elseif op==@VARARG@ then
+			local n=code[pc] pc=pc+1
+			if n==0 then n=nva else n=n-1 end
+			for i=1,n do T=T+1 S[T]=va[i] end
+		elseif op==@CLOSURE@ then
+			local cp,n=P[code[pc]],code[pc+1]
+			pc=pc+2
+			local u={}
+			for i=1,n do
+				if code[pc]==0 then u[i]=R[code[pc+1]] else u[i]=U[code[pc+1]] end
+				pc=pc+2
+			end
+			T=T+1 S[T]=closure(cp,u)
+		elseif op==@JMPIF@ then
+			local v=S[T] T=T-1
+			if v then pc=code[pc] else pc=pc+1 end
+		elseif op==@DUP@ then
+			T=T+1 S[T]=S[T-1]
+		elseif op==@DUP2@ then
+			S[T+1]=S[T-1] S[T+2]=S[T] T=T+2
+		elseif op==@POP@ then
+			T=T-1
+		elseif op==@ADD@ then
+			T=T-1 S[T]=S[T]+S[T+1]
+		elseif op==@SUB@ then
+			T=T-1 S[T]=S[T]-S[T+1]
+		elseif op==@MUL@ then
+			T=T-1 S[T]=S[T]*S[T+1]
+		elseif op==@DIV@ then
+			T=T-1 S[T]=S[T]/S[T+1]
+		elseif op==@IDIV@ then
+			T=T-1 S[T]=floor(S[T]/S[T+1])
+		elseif op==@MOD@ then
+			T=T-1 S[T]=S[T]%S[T+1]
+		elseif op==@POW@ then
+			T=T-1 S[T]=S[T]^S[T+1]
+		elseif op==@CONCAT@ then
+			T=T-1 S[T]=S[T]..S[T+1]
+		elseif op==@EQ@ then
+			T=T-1 S[T]=S[T]==S[T+1]
+		elseif op==@NE@ then
+			T=T-1 S[T]=S[T]~=S[T+1]
+		elseif op==@LT@ then
+			T=T-1 S[T]=S[T]<S[T+1]
+		elseif op==@LE@ then
+			T=T-1 S[T]=S[T]<=S[T+1]
+		elseif op==@GT@ then
+			T=T-1 S[T]=S[T]>S[T+1]
+		elseif op==@GE@ then
+			T=T-1 S[T]=S[T]>=S[T+1]
+		elseif op==@NEG@ then
+			S[T]=-S[T]
+		elseif op==@NOT@ then
+			S[T]=not S[T]
+		elseif op==@LEN@ then
+			S[T]=#S[T]
+		elseif op==@FORTEST@ then
+			local s=code[pc]
+			local i,lim,st=R[s],R[s+1],R[s+2]
+			if (st>0 and i<=lim) or (st<=0 and i>=lim) then pc=pc+2 else pc=code[pc+1] end
+		elseif op==@FORSTEP@ then
+			local s=code[pc] R[s]=R[s]+R[s+2] pc=pc+1
+		elseif op==@GFORPREP@ then
+			local s=code[pc] pc=pc+1
+			local f=R[s]
+			if type(f)=="table" and not (getmetatable(f) and getmetatable(f).__call) then
+				R[s],R[s+1],R[s+2]=next,f,nil
+			end
+		elseif op==@TFORCALL@ then
+			local s,n,exit=code[pc],code[pc+1],code[pc+2]
+			pc=pc+3
+			local res=pack(R[s](R[s+1],R[s+2]))
+			if res[1]==nil then
+				pc=exit
+			else
+				R[s+2]=res[1]
+				for i=1,n do T=T+1 S[T]=res[i] end
+			end
+		end
+	end
+end
+return function(s,seed) return closure(load(s,seed),{}) end
+end)()`
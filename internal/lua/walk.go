@@ -0,0 +1,242 @@
+package lua
+
+// Inspect traverses the tree rooted at n in depth-first order, like
+// go/ast.Inspect: it calls fn(n) and, if that returns true, recurses into each
+// child of n. Declaration names (local names, params, loop variables) are
+// visited as NameExprs too. nil children are skipped.
+func Inspect(n Node, fn func(Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	switch v := n.(type) {
+	case *Chunk:
+		inspectBlock(v.Body, fn)
+	case *LocalStat:
+		for _, nm := range v.Names {
+			Inspect(nm, fn)
+		}
+		inspectExprs(v.Values, fn)
+	case *AssignStat:
+		inspectExprs(v.Targets, fn)
+		inspectExprs(v.Values, fn)
+	case *CallStat:
+		inspectExpr(v.Call, fn)
+	case *DoStat:
+		inspectBlock(v.Body, fn)
+	case *WhileStat:
+		inspectExpr(v.Cond, fn)
+		inspectBlock(v.Body, fn)
+	case *RepeatStat:
+		inspectBlock(v.Body, fn)
+		inspectExpr(v.Cond, fn)
+	case *IfStat:
+		for i := range v.Conds {
+			inspectExpr(v.Conds[i], fn)
+			inspectBlock(v.Blocks[i], fn)
+		}
+		if v.HasElse {
+			inspectBlock(v.Else, fn)
+		}
+	case *NumericForStat:
+		Inspect(v.Var, fn)
+		inspectExpr(v.Start, fn)
+		inspectExpr(v.Stop, fn)
+		inspectExpr(v.Step, fn)
+		inspectBlock(v.Body, fn)
+	case *GenericForStat:
+		for _, nm := range v.Vars {
+			Inspect(nm, fn)
+		}
+		inspectExprs(v.Exprs, fn)
+		inspectBlock(v.Body, fn)
+	case *FuncStat:
+		inspectExpr(v.Target, fn)
+		Inspect(v.Func, fn)
+	case *LocalFuncStat:
+		Inspect(v.Name, fn)
+		Inspect(v.Func, fn)
+	case *ReturnStat:
+		inspectExprs(v.Values, fn)
+	case *IndexExpr:
+		inspectExpr(v.Obj, fn)
+		inspectExpr(v.Key, fn)
+	case *CallExpr:
+		inspectExpr(v.Fn, fn)
+		inspectExprs(v.Args, fn)
+	case *FuncExpr:
+		for _, pm := range v.Params {
+			Inspect(pm, fn)
+		}
+		inspectBlock(v.Body, fn)
+	case *TableExpr:
+		for _, f := range v.Fields {
+			inspectExpr(f.Key, fn)
+			inspectExpr(f.Value, fn)
+		}
+	case *BinExpr:
+		inspectExpr(v.L, fn)
+		inspectExpr(v.R, fn)
+	case *UnExpr:
+		inspectExpr(v.E, fn)
+	case *ParenExpr:
+		inspectExpr(v.E, fn)
+	case *IfExpr:
+		inspectExpr(v.Cond, fn)
+		inspectExpr(v.Then, fn)
+		for i := range v.ElifConds {
+			inspectExpr(v.ElifConds[i], fn)
+			inspectExpr(v.ElifThen[i], fn)
+		}
+		inspectExpr(v.Else, fn)
+	case *InterpExpr:
+		inspectExprs(v.Exprs, fn)
+	}
+	// Leaves (NameExpr, literals, BreakStat, ...) have no children.
+}
+
+// inspectExpr guards against typed-nil optional expressions (e.g. a missing
+// for-step) reaching Inspect as a non-nil Node interface.
+func inspectExpr(x Expr, fn func(Node) bool) {
+	if x != nil {
+		Inspect(x, fn)
+	}
+}
+
+func inspectExprs(xs []Expr, fn func(Node) bool) {
+	for _, x := range xs {
+		inspectExpr(x, fn)
+	}
+}
+
+func inspectBlock(stats []Stat, fn func(Node) bool) {
+	for _, s := range stats {
+		Inspect(s, fn)
+	}
+}
+
+// rewriteExprs replaces every expression in the tree rooted at n with fn's
+// result, bottom-up: an expression's children are rewritten before the
+// expression itself is passed to fn. Assignment targets and function-statement
+// targets are included; declaration names are not.
+func rewriteExprs(n Node, fn func(Expr) Expr) {
+	rw := func(x Expr) Expr {
+		if x == nil {
+			return nil
+		}
+		rewriteExprs(x, fn)
+		return fn(x)
+	}
+	rwList := func(xs []Expr) {
+		for i := range xs {
+			xs[i] = rw(xs[i])
+		}
+	}
+	rwBlock := func(stats []Stat) {
+		for _, s := range stats {
+			rewriteExprs(s, fn)
+		}
+	}
+	switch v := n.(type) {
+	case *Chunk:
+		rwBlock(v.Body)
+	case *LocalStat:
+		rwList(v.Values)
+	case *AssignStat:
+		rwList(v.Targets)
+		rwList(v.Values)
+	case *CallStat:
+		v.Call = rw(v.Call)
+	case *DoStat:
+		rwBlock(v.Body)
+	case *WhileStat:
+		v.Cond = rw(v.Cond)
+		rwBlock(v.Body)
+	case *RepeatStat:
+		rwBlock(v.Body)
+		v.Cond = rw(v.Cond)
+	case *IfStat:
+		for i := range v.Conds {
+			v.Conds[i] = rw(v.Conds[i])
+			rwBlock(v.Blocks[i])
+		}
+		if v.HasElse {
+			rwBlock(v.Else)
+		}
+	case *NumericForStat:
+		v.Start = rw(v.Start)
+		v.Stop = rw(v.Stop)
+		v.Step = rw(v.Step)
+		rwBlock(v.Body)
+	case *GenericForStat:
+		rwList(v.Exprs)
+		rwBlock(v.Body)
+	case *FuncStat:
+		v.Target = rw(v.Target)
+		rwBlock(v.Func.Body)
+	case *LocalFuncStat:
+		rwBlock(v.Func.Body)
+	case *ReturnStat:
+		rwList(v.Values)
+	case *IndexExpr:
+		v.Obj = rw(v.Obj)
+		v.Key = rw(v.Key)
+	case *CallExpr:
+		v.Fn = rw(v.Fn)
+		rwList(v.Args)
+	case *FuncExpr:
+		rwBlock(v.Body)
+	case *TableExpr:
+		for i := range v.Fields {
+			v.Fields[i].Key = rw(v.Fields[i].Key)
+			v.Fields[i].Value = rw(v.Fields[i].Value)
+		}
+	case *BinExpr:
+		v.L = rw(v.L)
+		v.R = rw(v.R)
+	case *UnExpr:
+		v.E = rw(v.E)
+	case *ParenExpr:
+		v.E = rw(v.E)
+	case *IfExpr:
+		v.Cond = rw(v.Cond)
+		v.Then = rw(v.Then)
+		rwList(v.ElifConds)
+		rwList(v.ElifThen)
+		v.Else = rw(v.Else)
+	case *InterpExpr:
+		rwList(v.Exprs)
+	}
+}
+
+// eachBlock calls fn on every statement list in the tree rooted at n (chunk,
+// function and control-flow bodies) and stores the list fn returns in its
+// place. Nested blocks are visited after their enclosing block has been
+// replaced.
+func eachBlock(n Node, fn func([]Stat) []Stat) {
+	Inspect(n, func(m Node) bool {
+		switch v := m.(type) {
+		case *Chunk:
+			v.Body = fn(v.Body)
+		case *FuncExpr:
+			v.Body = fn(v.Body)
+		case *DoStat:
+			v.Body = fn(v.Body)
+		case *WhileStat:
+			v.Body = fn(v.Body)
+		case *RepeatStat:
+			v.Body = fn(v.Body)
+		case *NumericForStat:
+			v.Body = fn(v.Body)
+		case *GenericForStat:
+			v.Body = fn(v.Body)
+		case *IfStat:
+			for i := range v.Blocks {
+				v.Blocks[i] = fn(v.Blocks[i])
+			}
+			if v.HasElse {
+				v.Else = fn(v.Else)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,198 @@
+package lua
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+)
+
+// MaxWatermarkLen is the longest identifier a Watermark can carry.
+const MaxWatermarkLen = 32
+
+// A Watermark hides an identifier, such as a user ID, in the names Rename and
+// HoistGlobals generate and the nonces EncryptStrings picks, so a leaked copy
+// of the output can be traced with IdentifyWatermark. Its payload is the
+// identifier's length, the identifier and a checksum byte. Each name or nonce
+// carries one payload byte and its position, mixed with random bits into 24
+// bits; since every one of them does, the mark survives minification and
+// reformatting, and a copy that lost or changed some of them still votes for
+// the right bytes. A nil *Watermark generates plain random names and nonces.
+type Watermark struct {
+	payload []byte
+	next    int             // payload position the next carrier holds
+	used    map[string]bool // names handed out, kept unique across units
+}
+
+// NewWatermark returns a Watermark carrying id.
+func NewWatermark(id string) (*Watermark, error) {
+	if id == "" || len(id) > MaxWatermarkLen {
+		return nil, fmt.Errorf("watermark must be 1 to %d bytes, got %d", MaxWatermarkLen, len(id))
+	}
+	payload := append([]byte{byte(len(id))}, id...)
+	payload = append(payload, watermarkChecksum(id))
+	return &Watermark{payload: payload, used: map[string]bool{}}, nil
+}
+
+// watermarkChecksum folds the FNV-1a hash of id into a byte.
+func watermarkChecksum(id string) byte {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	s := h.Sum32()
+	return byte(s ^ s>>8 ^ s>>16 ^ s>>24)
+}
+
+// Carriers are 24 bits: a 6-bit payload position, the payload byte and 10
+// random bits, scrambled by an invertible mix so consecutive names do not
+// share digits.
+const (
+	carrierMask = 1<<24 - 1
+	carrierMulA = 0x9e3779
+	carrierMulB = 0x5bd1e5
+)
+
+var (
+	carrierInvA = inverse24(carrierMulA)
+	carrierInvB = inverse24(carrierMulB)
+)
+
+// inverse24 returns the inverse of odd a modulo 2^24 (Newton's iteration).
+func inverse24(a uint32) uint32 {
+	x := a
+	for range 5 {
+		x *= 2 - a*x
+	}
+	return x & carrierMask
+}
+
+func mixCarrier(v uint32) uint32 {
+	v = v * carrierMulA & carrierMask
+	v ^= v >> 12
+	return v * carrierMulB & carrierMask
+}
+
+func unmixCarrier(v uint32) uint32 {
+	v = v * carrierInvB & carrierMask
+	v ^= v >> 12
+	return v * carrierInvA & carrierMask
+}
+
+// carrier returns the next payload byte, with its position, as 24 bits.
+func (w *Watermark) carrier() uint32 {
+	pos := w.next % len(w.payload)
+	w.next++
+	return mixCarrier(uint32(pos)<<18 | uint32(w.payload[pos])<<10 | uint32(randomInt(1<<10)))
+}
+
+// name returns a fresh identifier like _0x1a2b3c, carrying the mark if w is
+// not nil.
+func (w *Watermark) name() string {
+	if w == nil {
+		return generateName()
+	}
+	// Each position has 1024 names; after a few misses move on, and give up
+	// on marking this one only if every position is crowded.
+	for range 4 * len(w.payload) {
+		name := fmt.Sprintf("_0x%06x", w.carrier())
+		if !w.used[name] {
+			w.used[name] = true
+			return name
+		}
+	}
+	name := generateName()
+	for w.used[name] {
+		name = generateName()
+	}
+	w.used[name] = true
+	return name
+}
+
+// nonce returns a 24-bit string-encryption nonce, carrying the mark if w is
+// not nil. Nonces may repeat (see streamCipher).
+func (w *Watermark) nonce() uint32 {
+	if w == nil {
+		return randomNonce()
+	}
+	return w.carrier()
+}
+
+var (
+	markedNameRegex = regexp.MustCompile(`\b_0x[0-9a-f]{6}\b`)
+	decoderRegex    = regexp.MustCompile(`^_0x[0-9a-f]{10}$`)
+)
+
+// IdentifyWatermark recovers the identifier a Watermark hid in src, a bundle
+// or part of one. Each distinct renamed identifier and string-decoder nonce
+// is one vote for a payload byte; chunks embedded as strings (anti-tamper
+// bundles) are searched too. It fails if a payload byte has no votes or the
+// checksum does not match.
+func IdentifyWatermark(src string) (string, error) {
+	carriers := map[uint32]bool{}
+	collectCarriers(src, carriers)
+	votes := map[int]map[byte]int{}
+	for v := range carriers {
+		u := unmixCarrier(v)
+		pos := int(u >> 18)
+		if votes[pos] == nil {
+			votes[pos] = map[byte]int{}
+		}
+		votes[pos][byte(u>>10)]++
+	}
+	winner := func(pos int) (byte, bool) {
+		var best byte
+		n := 0
+		for b, count := range votes[pos] {
+			if count > n || count == n && b < best {
+				best, n = b, count
+			}
+		}
+		return best, n > 0
+	}
+	length, ok := winner(0)
+	if !ok || length == 0 || int(length) > MaxWatermarkLen {
+		return "", fmt.Errorf("no watermark found")
+	}
+	id := make([]byte, length)
+	for i := range id {
+		if id[i], ok = winner(i + 1); !ok {
+			return "", fmt.Errorf("watermark incomplete: byte %d of %d has no carrier left", i+1, length)
+		}
+	}
+	if sum, ok := winner(int(length) + 1); !ok || sum != watermarkChecksum(string(id)) {
+		return "", fmt.Errorf("watermark checksum mismatch (recovered %q)", id)
+	}
+	return string(id), nil
+}
+
+// collectCarriers adds to carriers the renamed identifiers in src and the
+// nonces of its decoder calls, then searches the string literals that
+// mention a renamed identifier, which may be chunks of their own.
+func collectCarriers(src string, carriers map[uint32]bool) {
+	for _, name := range markedNameRegex.FindAllString(src, -1) {
+		v, _ := strconv.ParseUint(name[3:], 16, 32)
+		carriers[uint32(v)] = true
+	}
+	c, err := Parse(src)
+	if err != nil {
+		return
+	}
+	Inspect(c, func(n Node) bool {
+		switch v := n.(type) {
+		case *CallExpr:
+			fn, ok := v.Fn.(*NameExpr)
+			if !ok || !decoderRegex.MatchString(fn.Name) || len(v.Args) != 2 {
+				break
+			}
+			if num, ok := v.Args[1].(*NumberExpr); ok {
+				if nonce, err := strconv.ParseUint(num.Text, 10, 32); err == nil {
+					carriers[uint32(nonce)] = true
+				}
+			}
+		case *StringExpr:
+			if s, ok := unquoteLuaString(v.Text); ok && markedNameRegex.MatchString(s) {
+				collectCarriers(s, carriers)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,97 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// markedSource is a chunk with enough locals and strings to carry a mark.
+func markedSource() string {
+	var b strings.Builder
+	for i := range 12 {
+		fmt.Fprintf(&b, "local function f%d(a, b) local s, n = %q, a + b return s .. n end\n", i, fmt.Sprint("text", i))
+	}
+	b.WriteString("return f0(1, 2)")
+	return b.String()
+}
+
+func mark(t *testing.T, src string, w *Watermark) string {
+	t.Helper()
+	c, err := Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	Rename(c, w)
+	HoistGlobals(c, w)
+	EncryptStrings(c, testKey, "_0x0123456789", w)
+	return c.Print()
+}
+
+func TestNewWatermark_Length(t *testing.T) {
+	for _, id := range []string{"", strings.Repeat("x", MaxWatermarkLen+1)} {
+		if _, err := NewWatermark(id); err == nil {
+			t.Errorf("NewWatermark(%d bytes) should fail", len(id))
+		}
+	}
+}
+
+func TestMixCarrier_Inverts(t *testing.T) {
+	for _, v := range []uint32{0, 1, 0x3ff, 0x123456, carrierMask} {
+		if got := unmixCarrier(mixCarrier(v)); got != v {
+			t.Errorf("unmix(mix(%#x)) = %#x", v, got)
+		}
+	}
+}
+
+func TestIdentifyWatermark_RoundTrip(t *testing.T) {
+	for _, id := range []string{"u", "user-48213", strings.Repeat("é", MaxWatermarkLen/2)} {
+		w, err := NewWatermark(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := mark(t, markedSource(), w)
+		if _, err := Parse(out); err != nil {
+			t.Fatalf("output must parse: %v\n%s", err, out)
+		}
+		if got, err := IdentifyWatermark(out); err != nil || got != id {
+			t.Errorf("IdentifyWatermark = %q, %v; want %q", got, err, id)
+		}
+		// Minified, or embedded in a string as --anti-tamper does.
+		if got, err := IdentifyWatermark(Minify(out)); err != nil || got != id {
+			t.Errorf("minified: IdentifyWatermark = %q, %v; want %q", got, err, id)
+		}
+		if got, err := IdentifyWatermark("return " + QuoteString(out)); err != nil || got != id {
+			t.Errorf("embedded: IdentifyWatermark = %q, %v; want %q", got, err, id)
+		}
+	}
+}
+
+func TestIdentifyWatermark_SurvivesNoise(t *testing.T) {
+	w, _ := NewWatermark("leaker")
+	out := mark(t, markedSource(), w)
+	// Unmarked code around the marked unit adds random names.
+	noise := mark(t, markedSource(), nil)
+	if got, err := IdentifyWatermark(out + "\n" + noise); err != nil || got != "leaker" {
+		t.Errorf("IdentifyWatermark = %q, %v", got, err)
+	}
+}
+
+func TestIdentifyWatermark_Unmarked(t *testing.T) {
+	if _, err := IdentifyWatermark(`print("hello")`); err == nil {
+		t.Error("expected an error for code without a watermark")
+	}
+}
+
+func TestWatermark_NamesUnique(t *testing.T) {
+	w, _ := NewWatermark("ab")
+	seen := map[string]bool{}
+	// Far more names than the 1024 each payload position has.
+	for range 8000 {
+		name := w.name()
+		if seen[name] {
+			t.Fatalf("duplicate name %s", name)
+		}
+		seen[name] = true
+	}
+}
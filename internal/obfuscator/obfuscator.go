@@ -2,33 +2,45 @@ package obfuscator
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"math/big"
 	"os"
 
 	"github.com/alfin-efendy/lua-bundler/internal/lua"
 )
 
 // Obfuscator transforms Lua source by level. It is stateless across units
-// except for the per-instance string-encryption key (level 3), which must be
-// shared by every unit and the injected decoder.
+// except for the per-instance string-encryption key and decoder name (level
+// 3) and the virtual machine (see Virtualize), which must be shared by every
+// unit and the injected decoder and interpreter.
 type Obfuscator struct {
-	level int
-	key   byte // string-encryption key, set when level >= 3
+	level       int
+	key         lua.StringKey  // string-encryption key, set when level >= 3
+	decoder     string         // string-decoder local, set when level >= 3
+	vm          *lua.VM        // opcode numbering and interpreter name for Virtualize
+	virtualized bool           // whether any unit was virtualized
+	mark        *lua.Watermark // identifier hidden in generated names and nonces
+	junk        float64        // density of dead code inserted from level 2
 }
 
-// NewObfuscator creates an obfuscator clamped to levels 1..3.
+// NewObfuscator creates an obfuscator clamped to levels 1..4.
 func NewObfuscator(level int) *Obfuscator {
 	if level < 1 {
 		level = 1
 	}
-	if level > 3 {
-		level = 3
+	if level > 4 {
+		level = 4
 	}
 	o := &Obfuscator{level: level}
 	if level >= 3 {
 		o.key = randomKey()
+		o.decoder = decoderName()
 	}
+	vmName := decoderName()
+	for vmName == o.decoder {
+		vmName = decoderName()
+	}
+	o.vm = lua.NewVM(vmName)
 	return o
 }
 
@@ -43,32 +55,110 @@ func (o *Obfuscator) Obfuscate(code string) string {
 		fmt.Fprintf(os.Stderr, "⚠️  obfuscate: parse failed, falling back to minify: %v\n", err)
 		return lua.Minify(code)
 	}
-	lua.Rename(chunk)
+	lua.Rename(chunk, o.mark)
+	if o.level >= 3 {
+		lua.HoistGlobals(chunk, o.mark)
+	}
+	lua.Junk(chunk, o.junk, o.mark)
+	if o.level >= 4 {
+		lua.Flatten(chunk)
+	}
 	if o.level >= 3 {
-		lua.EncryptStrings(chunk, o.key)
+		lua.BracketFields(chunk, o.mark)
+		lua.HideNumbers(chunk)
+		lua.EncryptStrings(chunk, o.key, o.decoder, o.mark)
 	}
 	return chunk.Print()
 }
 
-// DecoderPrelude returns the `local _d=...` definition that decodes strings
-// encrypted at level 3, or "" for levels 1-2. The bundler injects it once at
-// the top of the bundle.
+// SetWatermark hides w's identifier in the names and string nonces of every
+// unit obfuscated from now on (see lua.Watermark). Level 1 generates neither,
+// so it carries no mark. A nil w turns marking off.
+func (o *Obfuscator) SetWatermark(w *lua.Watermark) {
+	o.mark = w
+}
+
+// SetJunk sets the density, from 0 (off) to 1, of the dead decoy code
+// inserted into units obfuscated at level 2 and above (see lua.Junk).
+func (o *Obfuscator) SetJunk(density float64) {
+	o.junk = density
+}
+
+// Virtualize compiles one unit of Lua source into bytecode for this
+// Obfuscator's virtual machine, whose opcode numbering is random per
+// Obfuscator, and returns the source that runs it. The result needs the
+// interpreter from VMPrelude. Virtualized code runs many times slower than
+// plain Lua, so it is meant for a few sensitive units. It fails if the source
+// does not parse or uses something the compiler does not support (string
+// interpolation); callers fall back to Obfuscate.
+func (o *Obfuscator) Virtualize(code string) (string, error) {
+	chunk, err := lua.Parse(code)
+	if err != nil {
+		return "", err
+	}
+	out, err := o.vm.Compile(chunk)
+	if err != nil {
+		return "", err
+	}
+	o.virtualized = true
+	return out, nil
+}
+
+// VMPrelude returns the `local <vm>=...` definition of the interpreter that
+// runs virtualized units, or "" if nothing was virtualized. The bundler
+// injects it once at the top of the bundle.
+func (o *Obfuscator) VMPrelude() string {
+	if !o.virtualized {
+		return ""
+	}
+	return o.vm.Runtime()
+}
+
+// VMName returns the name of the interpreter local, or "" if nothing was
+// virtualized. Like DecoderName, no module may declare or assign it.
+func (o *Obfuscator) VMName() string {
+	if !o.virtualized {
+		return ""
+	}
+	return o.vm.Name
+}
+
+// DecoderPrelude returns the `local <decoder>=...` definition that decodes
+// strings encrypted at level 3, or "" for levels 1-2. The bundler injects it
+// once at the top of the bundle.
 func (o *Obfuscator) DecoderPrelude() string {
 	if o.level < 3 {
 		return ""
 	}
-	return lua.DecoderPrelude(o.key)
+	return lua.DecoderPrelude(o.key, o.decoder)
 }
 
-// fallbackKey is used only if crypto/rand fails (never on Linux). Must be
-// non-zero: a zero XOR key would make the decoder an identity and leak plaintext.
-const fallbackKey byte = 0x5a
+// DecoderName returns the name of the string-decoder local, or "" for levels
+// 1-2. It is random per Obfuscator; the bundler must make sure no module
+// declares or assigns it (see lua.UsesName).
+func (o *Obfuscator) DecoderName() string {
+	return o.decoder
+}
 
-// randomKey returns a non-zero byte for XOR string encryption.
-func randomKey() byte {
-	n, err := rand.Int(rand.Reader, big.NewInt(255))
-	if err != nil {
+// decoderName returns an identifier like _0x1a2b3c4d5e: shaped like the
+// renamer's names, so the decoder does not stand out, but longer, so it can
+// never be one of them.
+func decoderName() string {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "_0x5ac3178e61"
+	}
+	return "_0x" + hex.EncodeToString(buf)
+}
+
+// fallbackKey is used only if crypto/rand fails (never on Linux).
+var fallbackKey = lua.StringKey{0x5a, 0xc3, 0x17, 0x8e, 0x61, 0xf4, 0x2b, 0x9d, 0x40, 0xb7, 0x0e, 0xe9, 0x73, 0x26, 0xd8, 0xa5}
+
+// randomKey returns a fresh string-encryption key.
+func randomKey() lua.StringKey {
+	var key lua.StringKey
+	if _, err := rand.Read(key[:]); err != nil {
 		return fallbackKey
 	}
-	return byte(n.Int64()) + 1 // 1..255
+	return key
 }
@@ -1,15 +1,17 @@
 package obfuscator
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/alfin-efendy/lua-bundler/internal/lua"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewObfuscator_ClampsLevel(t *testing.T) {
 	assert.Equal(t, 1, NewObfuscator(0).level)
-	assert.Equal(t, 3, NewObfuscator(9).level)
+	assert.Equal(t, 4, NewObfuscator(9).level)
 }
 
 func TestObfuscate_Level1_MinifiesStringSafe(t *testing.T) {
@@ -60,7 +62,21 @@ func TestObfuscate_Level3_EncryptsStrings(t *testing.T) {
 	o := NewObfuscator(3)
 	out := o.Obfuscate(`local s = "secret"` + "\nreturn s")
 	assert.NotContains(t, out, `"secret"`, "string literal must be encrypted")
-	assert.Contains(t, out, "_d(", "expected decoder call")
+	assert.Contains(t, out, o.DecoderName()+"(", "expected decoder call")
+}
+
+func TestObfuscate_Level3_HidesGlobalsFieldsAndNumbers(t *testing.T) {
+	o := NewObfuscator(3)
+	out := o.Obfuscate(`local Players = game:GetService("Players")
+local part = Instance.new("Part")
+part.Size = Vector3.new(4, 1, 2)
+print(Players.LocalPlayer.Name, 987654321)`)
+	for _, hidden := range []string{"GetService", "LocalPlayer", "Size", "Part", "987654321"} {
+		assert.NotContains(t, out, hidden)
+	}
+	for _, global := range []string{"game", "Instance", "Vector3", "print"} {
+		assert.Equal(t, 1, strings.Count(out, global), "%s must be read once, at the top", global)
+	}
 }
 
 func TestObfuscate_Level3_PreservesRequire(t *testing.T) {
@@ -73,12 +89,74 @@ func TestObfuscate_Level3_DecoderPreludeNonEmpty(t *testing.T) {
 	o := NewObfuscator(3)
 	p := o.DecoderPrelude()
 	assert.NotEmpty(t, p)
-	assert.Contains(t, p, "_d")
+	assert.Contains(t, p, "local "+o.DecoderName()+"=")
 	assert.NotContains(t, p, "bit32")
 }
 
+func TestObfuscate_Level3_DecoderNamePerInstance(t *testing.T) {
+	a, b := NewObfuscator(3).DecoderName(), NewObfuscator(3).DecoderName()
+	assert.Regexp(t, `^_0x[0-9a-f]{10}$`, a)
+	assert.NotEqual(t, a, b, "decoder name must be randomized per build")
+	assert.Empty(t, NewObfuscator(2).DecoderName())
+}
+
 func TestObfuscate_Level2_NoDecoderPrelude(t *testing.T) {
 	assert.Empty(t, NewObfuscator(2).DecoderPrelude())
 	out := NewObfuscator(2).Obfuscate(`local s = "x"` + "\nreturn s")
-	assert.NotContains(t, out, "_d(", "level 2 must not encrypt strings")
+	assert.Contains(t, out, `"x"`, "level 2 must not encrypt strings")
+}
+
+func TestObfuscate_Level4_FlattensAndEncrypts(t *testing.T) {
+	o := NewObfuscator(4)
+	out := o.Obfuscate("local function f(x)\nlocal y = x + 1\nprint(\"v\", y)\nreturn y\nend\nreturn f(1)")
+	assert.Contains(t, out, "while", "expected a dispatch loop")
+	assert.NotContains(t, out, `"v"`, "level 4 must still encrypt strings")
+	assert.Contains(t, out, o.DecoderName()+"(")
+	assert.NotEmpty(t, o.DecoderPrelude())
+}
+
+func TestVirtualize_CompilesToBytecode(t *testing.T) {
+	o := NewObfuscator(2)
+	assert.Empty(t, o.VMPrelude(), "no interpreter until a unit is virtualized")
+	assert.Empty(t, o.VMName())
+
+	out, err := o.Virtualize(`local secret = "hunter2" return { check = function(s) return s == secret end }`)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, "check")
+	assert.Contains(t, out, "return "+o.VMName()+"(")
+	assert.NotEqual(t, o.DecoderName(), o.VMName())
+	assert.Contains(t, o.VMPrelude(), "local "+o.VMName()+"=")
+}
+
+func TestVirtualize_RejectsUnsupportedCode(t *testing.T) {
+	o := NewObfuscator(2)
+	_, err := o.Virtualize("goto nowhere")
+	assert.Error(t, err)
+	_, err = o.Virtualize("local = $$$")
+	assert.Error(t, err)
+	assert.Empty(t, o.VMPrelude(), "failed units do not need the interpreter")
+}
+
+func TestObfuscate_Junk(t *testing.T) {
+	src := `local function area(w, h)
+	local a = w * h
+	if a > 100 then return "big" end
+	return "small"
+end
+print(area(2, 3), area(20, 30))`
+	o := NewObfuscator(3)
+	plain := o.Obfuscate(src)
+	o.SetJunk(1)
+	junk := o.Obfuscate(src)
+	assert.Greater(t, len(junk), len(plain), "junk code makes the unit larger")
+	// At density 1 every block starts with a junk local.
+	assert.Greater(t, strings.Count(junk, "local "), strings.Count(plain, "local "), "junk locals are added")
+	_, err := lua.Parse(junk)
+	assert.NoError(t, err, "output must parse:\n%s", junk)
+	assert.NotContains(t, junk, `"big"`, "junk does not stop string encryption")
+
+	o = NewObfuscator(1)
+	o.SetJunk(1)
+	assert.Equal(t, NewObfuscator(1).Obfuscate(src), o.Obfuscate(src), "level 1 only minifies")
 }